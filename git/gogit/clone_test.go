@@ -17,6 +17,7 @@ limitations under the License.
 package gogit
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -452,6 +453,7 @@ func Test_ssh_KeyTypes(t *testing.T) {
 	tests := []struct {
 		name       string
 		keyType    ssh.KeyPairType
+		passphrase string
 		authorized bool
 		wantErr    string
 	}{
@@ -460,6 +462,8 @@ func Test_ssh_KeyTypes(t *testing.T) {
 		{name: "ECDSA P384", keyType: ssh.ECDSA_P384, authorized: true},
 		{name: "ECDSA P521", keyType: ssh.ECDSA_P521, authorized: true},
 		{name: "ED25519", keyType: ssh.ED25519, authorized: true},
+		{name: "ED25519 with passphrase", keyType: ssh.ED25519, passphrase: "foobar", authorized: true},
+		{name: "ECDSA P256 with passphrase", keyType: ssh.ECDSA_P256, passphrase: "foobar", authorized: true},
 		{name: "unauthorized key", keyType: ssh.RSA_4096, wantErr: "unable to authenticate, attempted methods [none publickey], no supported methods remain"},
 	}
 
@@ -509,7 +513,11 @@ func Test_ssh_KeyTypes(t *testing.T) {
 			g := NewWithT(t)
 
 			// Generate ssh keys based on key type.
-			kp, err := ssh.GenerateKeyPair(tt.keyType)
+			var opts []ssh.KeyPairOption
+			if tt.passphrase != "" {
+				opts = append(opts, ssh.WithPassphrase([]byte(tt.passphrase)))
+			}
+			kp, err := ssh.GenerateKeyPair(tt.keyType, opts...)
 			g.Expect(err).ToNot(HaveOccurred())
 
 			// Update authorized key to ensure only the new key is valid on the server.
@@ -520,6 +528,7 @@ func Test_ssh_KeyTypes(t *testing.T) {
 			authOpts := git.AuthOptions{
 				Transport:  git.SSH,
 				Identity:   kp.PrivateKey,
+				Password:   tt.passphrase,
 				KnownHosts: knownHosts,
 			}
 			tmpDir := t.TempDir()
@@ -554,6 +563,379 @@ func Test_ssh_KeyTypes(t *testing.T) {
 	}
 }
 
+// Test_ssh_UserCertificate assures support for SSH Authentication
+// with OpenSSH user certificates signed by a certificate authority.
+func Test_ssh_UserCertificate(t *testing.T) {
+	g := NewWithT(t)
+
+	ca, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	caPub, _, _, _, err := cryptossh.ParseAuthorizedKey(ca.PublicKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	serverRootDir := t.TempDir()
+	server := gittestserver.NewGitServer(serverRootDir)
+
+	// Auth needs to be called, for authentication to be enabled.
+	server.Auth("", "")
+	server.PublicKeyLookupFunc(func(content string) (*gitkit.PublicKey, error) {
+		pub, _, _, _, err := cryptossh.ParseAuthorizedKey([]byte(content))
+		if err != nil {
+			return nil, err
+		}
+		cert, ok := pub.(*cryptossh.Certificate)
+		if !ok {
+			return nil, fmt.Errorf("pubkey provided '%s' is not a certificate", content)
+		}
+		if !bytes.Equal(cert.SignatureKey.Marshal(), caPub.Marshal()) {
+			return nil, fmt.Errorf("certificate provided '%s' is not signed by the trusted CA", content)
+		}
+		return &gitkit.PublicKey{Content: content}, nil
+	})
+
+	timeout := 5 * time.Second
+
+	server.KeyDir(filepath.Join(server.Root(), "keys"))
+	g.Expect(server.ListenSSH()).To(Succeed())
+
+	go func() {
+		server.StartSSH()
+	}()
+	defer server.StopSSH()
+
+	repoPath := "test.git"
+	err = server.InitRepo(testRepositoryPath, git.DefaultBranch, repoPath)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	sshURL := server.SSHAddress()
+	repoURL := sshURL + "/" + repoPath
+
+	// Fetch host key.
+	u, err := url.Parse(sshURL)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(u.Host).ToNot(BeEmpty())
+
+	knownHosts, err := ssh.ScanHostKey(u.Host, timeout, git.HostKeyAlgos, false)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name     string
+		signCert bool
+		wantErr  string
+	}{
+		{name: "certificate signed by trusted CA", signCert: true},
+		{name: "identity without certificate", wantErr: "unable to authenticate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			kp, err := ssh.GenerateKeyPair(ssh.ECDSA_P256)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			authOpts := git.AuthOptions{
+				Transport:  git.SSH,
+				Identity:   kp.PrivateKey,
+				KnownHosts: knownHosts,
+			}
+			if tt.signCert {
+				authOpts.Certificate, err = ssh.SignUserCertificate(ca, kp.PublicKey, ssh.CertificateOptions{
+					KeyID:      "flux",
+					Principals: []string{git.DefaultPublicKeyAuthUser},
+				})
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			tmpDir := t.TempDir()
+
+			ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+			defer cancel()
+
+			ggc, err := NewClient(tmpDir, &authOpts)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cc, err := ggc.Clone(ctx, repoURL, git.CloneOptions{
+				CheckoutStrategy: git.CheckoutStrategy{
+					Branch: git.DefaultBranch,
+				},
+				ShallowClone: true,
+			})
+			if tt.wantErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(cc).ToNot(BeNil())
+				g.Expect(filepath.Join(tmpDir, "foo.txt")).To(BeARegularFile())
+			} else {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+			}
+		})
+	}
+}
+
+func Test_ssh_Agent(t *testing.T) {
+	g := NewWithT(t)
+
+	ca, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	caPub, _, _, _, err := cryptossh.ParseAuthorizedKey(ca.PublicKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	agentKP, err := ssh.GenerateKeyPair(ssh.RSA_4096)
+	g.Expect(err).ToNot(HaveOccurred())
+	identityKP, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	unknownKP, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	certKP, err := ssh.GenerateKeyPair(ssh.ECDSA_P256)
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, err := ssh.SignUserCertificate(ca, certKP.PublicKey, ssh.CertificateOptions{
+		Principals: []string{git.DefaultPublicKeyAuthUser},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	// Accepts the agent key, the identity, and certificates signed by the
+	// CA.
+	authorized := map[string]bool{
+		string(bytes.TrimSpace(agentKP.PublicKey)):    true,
+		string(bytes.TrimSpace(identityKP.PublicKey)): true,
+	}
+	server.Auth("", "")
+	server.PublicKeyLookupFunc(func(content string) (*gitkit.PublicKey, error) {
+		pub, _, _, _, err := cryptossh.ParseAuthorizedKey([]byte(content))
+		if err != nil {
+			return nil, err
+		}
+		if cert, ok := pub.(*cryptossh.Certificate); ok && bytes.Equal(cert.SignatureKey.Marshal(), caPub.Marshal()) {
+			return &gitkit.PublicKey{Content: content}, nil
+		}
+		if authorized[strings.TrimSpace(content)] {
+			return &gitkit.PublicKey{Content: content}, nil
+		}
+		return nil, fmt.Errorf("unauthorized public key '%s'", content)
+	})
+	server.KeyDir(filepath.Join(server.Root(), "keys"))
+	g.Expect(server.ListenSSH()).To(Succeed())
+	go func() {
+		server.StartSSH()
+	}()
+	defer server.StopSSH()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo(testRepositoryPath, git.DefaultBranch, repoPath)).To(Succeed())
+	repoURL := server.SSHAddress() + "/" + repoPath
+	u, err := url.Parse(server.SSHAddress())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	timeout := 5 * time.Second
+	knownHosts, err := ssh.ScanHostKey(u.Host, timeout, git.HostKeyAlgos, false)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name      string
+		agentKeys [][2][]byte
+		noAgent   bool
+		identity  []byte
+		wantErr   string
+	}{
+		{
+			name:      "agent key",
+			agentKeys: [][2][]byte{{unknownKP.PrivateKey}, {agentKP.PrivateKey}},
+		},
+		{
+			name:      "agent certificate",
+			agentKeys: [][2][]byte{{certKP.PrivateKey, cert}},
+		},
+		{
+			name:      "falls back to identity",
+			agentKeys: [][2][]byte{{unknownKP.PrivateKey}},
+			identity:  identityKP.PrivateKey,
+		},
+		{
+			name:     "falls back to identity without agent",
+			noAgent:  true,
+			identity: identityKP.PrivateKey,
+		},
+		{
+			name:      "unauthorized agent keys",
+			agentKeys: [][2][]byte{{unknownKP.PrivateKey}},
+			wantErr:   "unable to authenticate",
+		},
+		{
+			name:    "no agent and identity",
+			noAgent: true,
+			wantErr: "SSH_AUTH_SOCK is not set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			if tt.noAgent {
+				t.Setenv(ssh.AgentSocketEnvVar, "")
+			} else {
+				agent, err := gittestserver.NewSSHAgent()
+				g.Expect(err).ToNot(HaveOccurred())
+				defer agent.Stop()
+				for _, k := range tt.agentKeys {
+					g.Expect(agent.AddKey(k[0], k[1])).To(Succeed())
+				}
+				t.Setenv(ssh.AgentSocketEnvVar, agent.Socket())
+			}
+
+			authOpts := &git.AuthOptions{
+				Transport:  git.SSH,
+				Host:       u.Host,
+				Username:   git.DefaultPublicKeyAuthUser,
+				Identity:   tt.identity,
+				KnownHosts: knownHosts,
+				SSHAgent:   true,
+			}
+			g.Expect(authOpts.Validate()).To(Succeed())
+
+			ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+			defer cancel()
+
+			tmpDir := t.TempDir()
+			ggc, err := NewClient(tmpDir, authOpts)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			_, err = ggc.Clone(ctx, repoURL, git.CloneOptions{
+				CheckoutStrategy: git.CheckoutStrategy{Branch: git.DefaultBranch},
+			})
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(filepath.Join(tmpDir, "foo.txt")).To(BeARegularFile())
+		})
+	}
+}
+
+func Test_ssh_JumpHosts(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	server.KeyDir(filepath.Join(server.Root(), "keys"))
+	g.Expect(server.ListenSSH()).To(Succeed())
+	go func() {
+		server.StartSSH()
+	}()
+	defer server.StopSSH()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo(testRepositoryPath, git.DefaultBranch, repoPath)).To(Succeed())
+
+	sshURL := server.SSHAddress()
+	repoURL := sshURL + "/" + repoPath
+	u, err := url.Parse(sshURL)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	timeout := 5 * time.Second
+	knownHosts, err := ssh.ScanHostKey(u.Host, timeout, git.HostKeyAlgos, false)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	jumpKP, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Two jump servers, of which the second one is reached through the
+	// first one.
+	var jumpServers []*gittestserver.JumpServer
+	var jumpHosts []git.JumpHost
+	for i := 0; i < 2; i++ {
+		js, err := gittestserver.NewJumpServer()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(js.AuthorizedKeys(jumpKP.PublicKey)).To(Succeed())
+		g.Expect(js.Start()).To(Succeed())
+		defer js.Stop()
+		jumpServers = append(jumpServers, js)
+		jumpHosts = append(jumpHosts, git.JumpHost{
+			Host:       js.Address(),
+			Username:   "jump",
+			Identity:   jumpKP.PrivateKey,
+			KnownHosts: js.KnownHosts(),
+		})
+	}
+
+	kp, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	authOpts := &git.AuthOptions{
+		Transport:  git.SSH,
+		Host:       u.Host,
+		Username:   git.DefaultPublicKeyAuthUser,
+		Identity:   kp.PrivateKey,
+		KnownHosts: knownHosts,
+		JumpHosts:  jumpHosts,
+	}
+
+	t.Run("clone and push through jump hosts", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+		defer cancel()
+
+		tmpDir := t.TempDir()
+		ggc, err := NewClient(tmpDir, authOpts)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		cc, err := ggc.Clone(ctx, repoURL, git.CloneOptions{
+			CheckoutStrategy: git.CheckoutStrategy{Branch: git.DefaultBranch},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cc).ToNot(BeNil())
+		g.Expect(filepath.Join(tmpDir, "foo.txt")).To(BeARegularFile())
+
+		// The remote points to the Git server, not to the tunnel.
+		remote, err := ggc.repository.Remote(git.DefaultRemote)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(remote.Config().URLs).To(Equal([]string{repoURL}))
+
+		hash, err := commitFile(ggc.repository, "jump", "pushed through jump hosts", time.Now())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ggc.Push(ctx)).To(Succeed())
+
+		repo, err := extgogit.PlainOpen(filepath.Join(server.Root(), repoPath))
+		g.Expect(err).ToNot(HaveOccurred())
+		ref, err := repo.Reference(plumbing.NewBranchReferenceName(git.DefaultBranch), true)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ref.Hash()).To(Equal(hash))
+
+		// The first jump server forwarded to the second one, which
+		// forwarded to the Git server.
+		g.Expect(jumpServers[0].ForwardedAddresses()).To(ContainElement(jumpServers[1].Address()))
+		g.Expect(jumpServers[1].ForwardedAddresses()).To(ContainElement(u.Host))
+	})
+
+	t.Run("unknown jump host key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+		defer cancel()
+
+		opts := *authOpts
+		opts.JumpHosts = []git.JumpHost{jumpHosts[0], jumpHosts[1]}
+		opts.JumpHosts[1].KnownHosts = jumpHosts[0].KnownHosts
+
+		ggc, err := NewClient(t.TempDir(), &opts)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = ggc.Clone(ctx, repoURL, git.CloneOptions{
+			CheckoutStrategy: git.CheckoutStrategy{Branch: git.DefaultBranch},
+		})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("failed to connect to jump host '%s'", jumpServers[1].Address()))
+	})
+}
+
 // Test_ssh_KeyExchangeAlgos assures support for the different
 // types of SSH key exchange algorithms supported by Flux.
 func Test_ssh_KeyExchangeAlgos(t *testing.T) {
@@ -156,6 +156,31 @@ func (g *Client) Init(ctx context.Context, url, branch string) error {
 }
 
 func (g *Client) Clone(ctx context.Context, url string, cloneOpts git.CloneOptions) (*git.Commit, error) {
+	if !g.useSSHTunnel() {
+		return g.clone(ctx, url, cloneOpts)
+	}
+
+	tunnel, tunnelURL, err := g.openSSHTunnel(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer tunnel.Close()
+
+	commit, err := g.clone(ctx, tunnelURL, cloneOpts)
+	if err != nil {
+		return nil, err
+	}
+	// The remote of the cloned repository points to the tunnel, which
+	// is closed after the clone.
+	if g.repository != nil {
+		if err := g.setRemoteURL(extgogit.DefaultRemoteName, url); err != nil {
+			return nil, err
+		}
+	}
+	return commit, nil
+}
+
+func (g *Client) clone(ctx context.Context, url string, cloneOpts git.CloneOptions) (*git.Commit, error) {
 	checkoutStrat := cloneOpts.CheckoutStrategy
 	switch {
 	case checkoutStrat.Commit != "":
@@ -262,12 +287,21 @@ func (g *Client) Push(ctx context.Context) error {
 		return fmt.Errorf("failed to construct auth method with options: %w", err)
 	}
 
-	return g.repository.PushContext(ctx, &extgogit.PushOptions{
+	pushOpts := &extgogit.PushOptions{
 		RemoteName: extgogit.DefaultRemoteName,
 		Auth:       authMethod,
 		Progress:   nil,
 		CABundle:   caBundle(g.authOpts),
-	})
+	}
+	if g.useSSHTunnel() {
+		remote, tunnel, err := g.tunneledRemote(ctx, extgogit.DefaultRemoteName)
+		if err != nil {
+			return err
+		}
+		defer tunnel.Close()
+		return remote.PushContext(ctx, pushOpts)
+	}
+	return g.repository.PushContext(ctx, pushOpts)
 }
 
 func (g *Client) SwitchBranch(ctx context.Context, branchName string) error {
@@ -300,13 +334,23 @@ func (g *Client) SwitchBranch(ctx context.Context, branchName string) error {
 		return fmt.Errorf("could not checkout to branch '%s': %w", branchName, err)
 	}
 
-	g.repository.FetchContext(ctx, &extgogit.FetchOptions{
+	fetchOpts := &extgogit.FetchOptions{
 		RemoteName: extgogit.DefaultRemoteName,
 		RefSpecs: []config.RefSpec{
 			config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%[1]s", branchName, extgogit.DefaultRemoteName)),
 		},
 		Auth: authMethod,
-	})
+	}
+	if g.useSSHTunnel() {
+		remote, tunnel, err := g.tunneledRemote(ctx, extgogit.DefaultRemoteName)
+		if err != nil {
+			return err
+		}
+		remote.FetchContext(ctx, fetchOpts)
+		tunnel.Close()
+	} else {
+		g.repository.FetchContext(ctx, fetchOpts)
+	}
 	ref, err := g.repository.Reference(plumbing.ReferenceName(fmt.Sprintf("/refs/remotes/origin/%s", branchName)), true)
 
 	// If remote ref doesn't exist, no need to reset to remote target commit, exit early.
@@ -18,6 +18,7 @@ package gogit
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
@@ -25,6 +26,7 @@ import (
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/fluxcd/pkg/git"
+	pkgssh "github.com/fluxcd/pkg/ssh"
 	"github.com/fluxcd/pkg/ssh/knownhosts"
 )
 
@@ -46,17 +48,33 @@ func transportAuth(opts *git.AuthOptions) (transport.AuthMethod, error) {
 		}
 		return nil, nil
 	case git.SSH:
-		pk, err := ssh.NewPublicKeys(opts.Username, opts.Identity, opts.Password)
-		if err != nil {
-			return nil, err
+		pk := &ssh.PublicKeys{
+			User: opts.Username,
+		}
+		if len(opts.Identity) > 0 || !opts.SSHAgent {
+			signer, err := sshSigner(opts.Identity, opts.Password, opts.Certificate)
+			if err != nil {
+				return nil, err
+			}
+			pk.Signer = signer
 		}
 		callback, err := knownhosts.New(opts.KnownHosts)
 		if err != nil {
 			return nil, err
 		}
 		pk.HostKeyCallback = callback
+		if len(opts.JumpHosts) > 0 {
+			// Connections through jump hosts are made to the local
+			// address of the tunnel, verify the host key against the
+			// configured host instead.
+			hostAddr := git.JumpHost{Host: opts.Host}.Addr()
+			pk.HostKeyCallback = func(_ string, remote net.Addr, key gossh.PublicKey) error {
+				return callback(hostAddr, remote, key)
+			}
+		}
 		customPK := &CustomPublicKeys{
-			pk: pk,
+			pk:       pk,
+			sshAgent: opts.SSHAgent,
 		}
 		return customPK, nil
 	case "":
@@ -66,6 +84,48 @@ func transportAuth(opts *git.AuthOptions) (transport.AuthMethod, error) {
 	}
 }
 
+// sshSigner returns the gossh.Signer for the given private key, decrypted
+// with the password if encrypted, and the optional certificate.
+func sshSigner(identity []byte, password string, certificate []byte) (gossh.Signer, error) {
+	signer, err := pkgssh.ParsePrivateKey(identity, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	if len(certificate) > 0 {
+		return pkgssh.NewCertSigner(signer, certificate)
+	}
+	return signer, nil
+}
+
+// sshJumpHosts constructs the pkgssh.JumpHost chain for the jump hosts of
+// the given git.AuthOptions.
+func sshJumpHosts(opts *git.AuthOptions) ([]pkgssh.JumpHost, error) {
+	var jumpHosts []pkgssh.JumpHost
+	for _, j := range opts.JumpHosts {
+		signer, err := sshSigner(j.Identity, j.Password, j.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("jump host '%s': %w", j.Host, err)
+		}
+		callback, err := knownhosts.New(j.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("jump host '%s': %w", j.Host, err)
+		}
+		config := &gossh.ClientConfig{
+			User:            j.Username,
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+			HostKeyCallback: callback,
+		}
+		if len(git.KexAlgos) > 0 {
+			config.Config.KeyExchanges = git.KexAlgos
+		}
+		if len(git.HostKeyAlgos) > 0 {
+			config.HostKeyAlgorithms = git.HostKeyAlgos
+		}
+		jumpHosts = append(jumpHosts, pkgssh.JumpHost{Addr: j.Addr(), Config: config})
+	}
+	return jumpHosts, nil
+}
+
 // caBundle returns the CA bundle from the given git.AuthOptions.
 func caBundle(opts *git.AuthOptions) []byte {
 	if opts == nil {
@@ -78,6 +138,9 @@ func caBundle(opts *git.AuthOptions) []byte {
 // customize the ssh config. It implements ssh.AuthMethod.
 type CustomPublicKeys struct {
 	pk *ssh.PublicKeys
+	// sshAgent enables authentication with the keys of the SSH agent,
+	// before falling back to the signer of pk.
+	sshAgent bool
 }
 
 func (a *CustomPublicKeys) Name() string {
@@ -94,6 +157,9 @@ func (a *CustomPublicKeys) ClientConfig() (*gossh.ClientConfig, error) {
 		return nil, err
 	}
 
+	if a.sshAgent {
+		config.Auth = []gossh.AuthMethod{gossh.PublicKeysCallback(a.signers)}
+	}
 	if len(git.KexAlgos) > 0 {
 		config.Config.KeyExchanges = git.KexAlgos
 	}
@@ -103,3 +169,20 @@ func (a *CustomPublicKeys) ClientConfig() (*gossh.ClientConfig, error) {
 
 	return config, nil
 }
+
+// signers returns the signers of the SSH agent followed by the signer of
+// the identity, if any. The signer of the identity is returned on its own
+// if the SSH agent can not be reached.
+func (a *CustomPublicKeys) signers() ([]gossh.Signer, error) {
+	signers, err := pkgssh.AgentSigners(pkgssh.AgentSocket())
+	if err != nil {
+		if a.pk.Signer == nil {
+			return nil, err
+		}
+		return []gossh.Signer{a.pk.Signer}, nil
+	}
+	if a.pk.Signer != nil {
+		signers = append(signers, a.pk.Signer)
+	}
+	return signers, nil
+}
@@ -206,6 +206,23 @@ func Test_transportAuth(t *testing.T) {
 			},
 			wantErr: errors.New("knownhosts: knownhosts: missing host pattern"),
 		},
+		{
+			name: "SSH agent without private key",
+			opts: &git.AuthOptions{
+				Transport: git.SSH,
+				Username:  "example",
+				SSHAgent:  true,
+			},
+			wantFunc: func(g *WithT, t transport.AuthMethod, opts *git.AuthOptions) {
+				tt, ok := t.(*CustomPublicKeys)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(tt.sshAgent).To(BeTrue())
+				g.Expect(tt.pk.Signer).To(BeNil())
+				config, err := tt.ClientConfig()
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(config.Auth).To(HaveLen(1))
+			},
+		},
 		{
 			name:    "Empty",
 			opts:    &git.AuthOptions{},
@@ -0,0 +1,201 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	extgogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/proxy"
+
+	"github.com/fluxcd/pkg/git"
+	pkgssh "github.com/fluxcd/pkg/ssh"
+)
+
+// sshTunnel forwards the connections accepted on a local listener to an
+// SSH server through a chain of jump hosts. go-git dials SSH servers
+// directly, hence the URL of the remote is rewritten to the address of
+// the tunnel for operations configured with jump hosts.
+type sshTunnel struct {
+	ctx       context.Context
+	addr      string
+	jumpHosts []pkgssh.JumpHost
+	listener  net.Listener
+
+	mu sync.Mutex
+	// first is the connection dialed when opening the tunnel, handed out
+	// to the first accepted connection.
+	first net.Conn
+	wg    sync.WaitGroup
+}
+
+// openSSHTunnel opens a tunnel to the SSH server of the given URL through
+// the given jump hosts, and returns it together with the URL rewritten to
+// the address of the tunnel. The first connection through the jump hosts
+// is made before returning, so that connection errors surface early.
+func openSSHTunnel(ctx context.Context, url string, jumpHosts []pkgssh.JumpHost) (*sshTunnel, string, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, "", err
+	}
+	port := ep.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(ep.Host, strconv.Itoa(port))
+
+	first, err := pkgssh.DialThroughJumpHosts(ctx, proxy.Dial, addr, jumpHosts)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to '%s' through jump hosts: %w", addr, err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		first.Close()
+		return nil, "", err
+	}
+
+	t := &sshTunnel{
+		ctx:       ctx,
+		addr:      addr,
+		jumpHosts: jumpHosts,
+		listener:  listener,
+		first:     first,
+	}
+	go t.serve()
+
+	tunnelEP := *ep
+	tunnelEP.Protocol = "ssh"
+	tunnelEP.Host = "127.0.0.1"
+	tunnelEP.Port = listener.Addr().(*net.TCPAddr).Port
+	return t, tunnelEP.String(), nil
+}
+
+// Close closes the listener of the tunnel, and waits for the forwarded
+// connections to be closed.
+func (t *sshTunnel) Close() error {
+	err := t.listener.Close()
+	t.mu.Lock()
+	if t.first != nil {
+		t.first.Close()
+		t.first = nil
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	return err
+}
+
+func (t *sshTunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.forward(conn)
+		}()
+	}
+}
+
+// forward copies data between the given local connection and a
+// connection to the SSH server through the jump hosts, until either side
+// is closed.
+func (t *sshTunnel) forward(conn net.Conn) {
+	defer conn.Close()
+
+	t.mu.Lock()
+	remote := t.first
+	t.first = nil
+	t.mu.Unlock()
+	if remote == nil {
+		var err error
+		if remote, err = pkgssh.DialThroughJumpHosts(t.ctx, proxy.Dial, t.addr, t.jumpHosts); err != nil {
+			return
+		}
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	// Closing both connections once either side is done unblocks the
+	// other copy.
+	<-done
+}
+
+// useSSHTunnel returns true if the remote operations of the client must be
+// tunneled through SSH jump hosts.
+func (g *Client) useSSHTunnel() bool {
+	return g.authOpts != nil && g.authOpts.Transport == git.SSH && len(g.authOpts.JumpHosts) > 0
+}
+
+// openSSHTunnel opens a tunnel to the given URL through the jump hosts of
+// the client.
+func (g *Client) openSSHTunnel(ctx context.Context, url string) (*sshTunnel, string, error) {
+	jumpHosts, err := sshJumpHosts(g.authOpts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to construct jump hosts with options: %w", err)
+	}
+	return openSSHTunnel(ctx, url, jumpHosts)
+}
+
+// tunneledRemote returns the named remote of the repository with its URL
+// rewritten to a tunnel through the jump hosts of the client. The returned
+// tunnel must be closed after use.
+func (g *Client) tunneledRemote(ctx context.Context, name string) (*extgogit.Remote, *sshTunnel, error) {
+	remote, err := g.repository.Remote(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := *remote.Config()
+	if len(cfg.URLs) == 0 {
+		return nil, nil, fmt.Errorf("remote '%s' has no URL", name)
+	}
+	tunnel, tunnelURL, err := g.openSSHTunnel(ctx, cfg.URLs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.URLs = []string{tunnelURL}
+	return extgogit.NewRemote(g.repository.Storer, &cfg), tunnel, nil
+}
+
+// setRemoteURL sets the URL of the named remote of the repository.
+func (g *Client) setRemoteURL(name, url string) error {
+	cfg, err := g.repository.Config()
+	if err != nil {
+		return err
+	}
+	remote, ok := cfg.Remotes[name]
+	if !ok {
+		return fmt.Errorf("remote '%s' not found", name)
+	}
+	remote.URLs = []string{url}
+	return g.repository.SetConfig(cfg)
+}
@@ -58,6 +58,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	pkgssh "github.com/fluxcd/pkg/ssh"
 	pkgkh "github.com/fluxcd/pkg/ssh/knownhosts"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -213,7 +214,12 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 		_ = t.Close()
 	}
 
-	err = t.createConn(addr, sshConfig)
+	jumpHosts, err := createJumpHosts(opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.createConn(addr, sshConfig, jumpHosts)
 	if err != nil {
 		return nil, err
 	}
@@ -295,12 +301,19 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 	return t.currentStream, nil
 }
 
-func (t *sshSmartSubtransport) createConn(addr string, sshConfig *ssh.ClientConfig) error {
+func (t *sshSmartSubtransport) createConn(addr string, sshConfig *ssh.ClientConfig, jumpHosts []pkgssh.JumpHost) error {
 	ctx, cancel := context.WithTimeout(context.TODO(), sshConnectionTimeOut)
 	defer cancel()
 
-	t.logger.V(traceLevel).Info("dial connection")
-	conn, err := proxy.Dial(ctx, "tcp", addr)
+	var conn net.Conn
+	var err error
+	if len(jumpHosts) > 0 {
+		t.logger.V(traceLevel).Info("dial connection through jump hosts", "jumpHosts", len(jumpHosts))
+		conn, err = pkgssh.DialThroughJumpHosts(ctx, proxy.Dial, addr, jumpHosts)
+	} else {
+		t.logger.V(traceLevel).Info("dial connection")
+		conn, err = proxy.Dial(ctx, "tcp", addr)
+	}
 	if err != nil {
 		return err
 	}
@@ -376,20 +389,83 @@ func createClientConfig(authOpts *git.AuthOptions) (*ssh.ClientConfig, error) {
 		return nil, fmt.Errorf("cannot create ssh client config from nil ssh auth options")
 	}
 
-	var signer ssh.Signer
-	var err error
-	if authOpts.Password != "" {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(authOpts.Identity, []byte(authOpts.Password))
-	} else {
-		signer, err = ssh.ParsePrivateKey(authOpts.Identity)
+	var identity ssh.Signer
+	if len(authOpts.Identity) > 0 || !authOpts.SSHAgent {
+		var err error
+		identity, err = newSigner(authOpts.Identity, authOpts.Password, authOpts.Certificate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	auth := ssh.PublicKeys(identity)
+	if authOpts.SSHAgent {
+		auth = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return agentSigners(identity)
+		})
 	}
+	return newClientConfig(authOpts.Username, auth), nil
+}
+
+// agentSigners returns the signers of the SSH agent followed by the given
+// identity signer, if not nil. The identity signer is returned on its own
+// if the SSH agent can not be reached.
+func agentSigners(identity ssh.Signer) ([]ssh.Signer, error) {
+	signers, err := pkgssh.AgentSigners(pkgssh.AgentSocket())
+	if err != nil {
+		if identity == nil {
+			return nil, err
+		}
+		return []ssh.Signer{identity}, nil
+	}
+	if identity != nil {
+		signers = append(signers, identity)
+	}
+	return signers, nil
+}
+
+// createJumpHosts constructs the pkgssh.JumpHost chain for the jump hosts
+// of the given git.AuthOptions.
+func createJumpHosts(authOpts *git.AuthOptions) ([]pkgssh.JumpHost, error) {
+	if authOpts == nil {
+		return nil, nil
+	}
+	var jumpHosts []pkgssh.JumpHost
+	for _, j := range authOpts.JumpHosts {
+		signer, err := newSigner(j.Identity, j.Password, j.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("jump host '%s': %w", j.Host, err)
+		}
+		cfg := newClientConfig(j.Username, ssh.PublicKeys(signer))
+		knownHosts := j.KnownHosts
+		cfg.HostKeyCallback = func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+			keyHash := sha256.Sum256(key.Marshal())
+			return checkKnownHost(hostname, knownHosts, keyHash[:])
+		}
+		jumpHosts = append(jumpHosts, pkgssh.JumpHost{Addr: j.Addr(), Config: cfg})
+	}
+	return jumpHosts, nil
+}
+
+// newSigner returns the ssh.Signer for the given private key, decrypted
+// with the password if encrypted, and the optional certificate.
+func newSigner(identity []byte, password string, certificate []byte) (ssh.Signer, error) {
+	signer, err := pkgssh.ParsePrivateKey(identity, []byte(password))
 	if err != nil {
 		return nil, err
 	}
+	if len(certificate) > 0 {
+		return pkgssh.NewCertSigner(signer, certificate)
+	}
+	return signer, nil
+}
 
+// newClientConfig returns the ssh.ClientConfig authenticating as the given
+// user with the given auth method.
+func newClientConfig(user string, auth ssh.AuthMethod) *ssh.ClientConfig {
 	cfg := &ssh.ClientConfig{
-		User:    authOpts.Username,
-		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		User:    user,
+		Auth:    []ssh.AuthMethod{auth},
 		Timeout: sshConnectionTimeOut,
 	}
 
@@ -400,7 +476,7 @@ func createClientConfig(authOpts *git.AuthOptions) (*ssh.ClientConfig, error) {
 		cfg.HostKeyAlgorithms = git.HostKeyAlgos
 	}
 
-	return cfg, nil
+	return cfg
 }
 
 // checkKnownHost checks whether the host being connected to is
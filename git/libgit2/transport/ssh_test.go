@@ -27,6 +27,7 @@ import (
 
 	git2go "github.com/libgit2/git2go/v33"
 	. "github.com/onsi/gomega"
+	cryptossh "golang.org/x/crypto/ssh"
 
 	"github.com/fluxcd/pkg/git"
 	"github.com/fluxcd/pkg/git/libgit2/internal/test"
@@ -57,6 +58,22 @@ func TestSSHAction_clientConfig(t *testing.T) {
 	if err != nil {
 		t.Fatalf("could not generate keypair: %s", err)
 	}
+	ca, err := ssh.GenerateKeyPair(ssh.ED25519)
+	if err != nil {
+		t.Fatalf("could not generate CA keypair: %s", err)
+	}
+	cert, err := ssh.SignUserCertificate(ca, kp.PublicKey, ssh.CertificateOptions{})
+	if err != nil {
+		t.Fatalf("could not sign user certificate: %s", err)
+	}
+	encryptedKp, err := ssh.GenerateKeyPair(ssh.ED25519, ssh.WithPassphrase([]byte("foobar")))
+	if err != nil {
+		t.Fatalf("could not generate encrypted keypair: %s", err)
+	}
+	otherKp, err := ssh.GenerateKeyPair(ssh.ED25519)
+	if err != nil {
+		t.Fatalf("could not generate keypair: %s", err)
+	}
 	tests := []struct {
 		name             string
 		authOpts         *git.AuthOptions
@@ -78,6 +95,71 @@ func TestSSHAction_clientConfig(t *testing.T) {
 			expectedUsername: "user",
 			expectedAuthLen:  1,
 		},
+		{
+			name: "encrypted identity with passphrase returns a valid SSHClientConfig",
+			authOpts: &git.AuthOptions{
+				Identity: encryptedKp.PrivateKey,
+				Password: "foobar",
+				Username: "user",
+			},
+			expectedUsername: "user",
+			expectedAuthLen:  1,
+		},
+		{
+			name: "unencrypted identity with password returns a valid SSHClientConfig",
+			authOpts: &git.AuthOptions{
+				Identity: kp.PrivateKey,
+				Password: "foobar",
+				Username: "user",
+			},
+			expectedUsername: "user",
+			expectedAuthLen:  1,
+		},
+		{
+			name: "encrypted identity without passphrase returns an error",
+			authOpts: &git.AuthOptions{
+				Identity: encryptedKp.PrivateKey,
+				Username: "user",
+			},
+			expectErr: "bcrypt_pbkdf: empty password",
+		},
+		{
+			name: "valid SSHTransportOptions with certificate returns a valid SSHClientConfig",
+			authOpts: &git.AuthOptions{
+				Identity:    kp.PrivateKey,
+				Certificate: cert,
+				Username:    "user",
+			},
+			expectedUsername: "user",
+			expectedAuthLen:  1,
+		},
+		{
+			name: "certificate not matching identity returns an error",
+			authOpts: &git.AuthOptions{
+				Identity:    otherKp.PrivateKey,
+				Certificate: cert,
+				Username:    "user",
+			},
+			expectErr: "failed to create certificate signer: ssh: signer and cert have different public key",
+		},
+		{
+			name: "SSH agent without identity returns a valid SSHClientConfig",
+			authOpts: &git.AuthOptions{
+				SSHAgent: true,
+				Username: "user",
+			},
+			expectedUsername: "user",
+			expectedAuthLen:  1,
+		},
+		{
+			name: "SSH agent with invalid identity returns an error",
+			authOpts: &git.AuthOptions{
+				SSHAgent: true,
+				Identity: encryptedKp.PrivateKey,
+				Username: "user",
+			},
+			expectErr: "bcrypt_pbkdf: empty password",
+		},
 	}
 
 	for _, tt := range tests {
@@ -155,6 +237,132 @@ func TestSSHManagedTransport_E2E(t *testing.T) {
 	g.Expect(err).ToNot(HaveOccurred())
 }
 
+func TestSSHManagedTransport_JumpHosts(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	server.KeyDir(filepath.Join(server.Root(), "keys"))
+
+	err = server.ListenSSH()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	go func() {
+		server.StartSSH()
+	}()
+	defer server.StopSSH()
+	InitManagedTransport()
+
+	kp, err := ssh.NewEd25519Generator().Generate()
+	g.Expect(err).ToNot(HaveOccurred())
+	jumpKP, err := ssh.NewEd25519Generator().Generate()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	jumpServer, err := gittestserver.NewJumpServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(jumpServer.AuthorizedKeys(jumpKP.PublicKey)).To(Succeed())
+	g.Expect(jumpServer.Start()).To(Succeed())
+	defer jumpServer.Stop()
+
+	repoPath := "test.git"
+	err = server.InitRepo("../../testdata/git/repo", git.DefaultBranch, repoPath)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	u, err := url.Parse(server.SSHAddress())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(u.Host).ToNot(BeEmpty())
+	knownhosts, err := ssh.ScanHostKey(u.Host, 5*time.Second, git.HostKeyAlgos, false)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	transportOptsURL := "ssh://git@fake-url-jump"
+	sshAddress := server.SSHAddress() + "/" + repoPath
+	AddTransportOptions(transportOptsURL, TransportOptions{
+		TargetURL: sshAddress,
+		AuthOpts: &git.AuthOptions{
+			Username:   "user",
+			Identity:   kp.PrivateKey,
+			KnownHosts: knownhosts,
+			JumpHosts: []git.JumpHost{{
+				Host:       jumpServer.Address(),
+				Username:   "jump",
+				Identity:   jumpKP.PrivateKey,
+				KnownHosts: jumpServer.KnownHosts(),
+			}},
+		},
+	})
+
+	tmpDir := t.TempDir()
+	repo, err := git2go.Clone(transportOptsURL, tmpDir, &git2go.CloneOptions{
+		CheckoutOptions: git2go.CheckoutOptions{
+			Strategy: git2go.CheckoutForce,
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	g.Expect(jumpServer.ForwardedAddresses()).To(ContainElement(u.Host))
+}
+
+func Test_agentSigners(t *testing.T) {
+	g := NewWithT(t)
+
+	agentKP, err := ssh.GenerateKeyPair(ssh.ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	identityKP, err := ssh.GenerateKeyPair(ssh.ECDSA_P256)
+	g.Expect(err).ToNot(HaveOccurred())
+	identity, err := newSigner(identityKP.PrivateKey, "", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	agent, err := gittestserver.NewSSHAgent()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer agent.Stop()
+	g.Expect(agent.AddKey(agentKP.PrivateKey, nil)).To(Succeed())
+
+	t.Setenv(ssh.AgentSocketEnvVar, agent.Socket())
+	signers, err := agentSigners(identity)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signers).To(HaveLen(2))
+	g.Expect(signers[0].PublicKey().Type()).To(Equal("ssh-ed25519"))
+	g.Expect(signers[1]).To(Equal(identity))
+
+	t.Setenv(ssh.AgentSocketEnvVar, "")
+	signers, err = agentSigners(identity)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signers).To(Equal([]cryptossh.Signer{identity}))
+
+	_, err = agentSigners(nil)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("SSH_AUTH_SOCK is not set"))
+}
+
+func Test_createJumpHosts(t *testing.T) {
+	g := NewWithT(t)
+
+	kp, err := ssh.NewEd25519Generator().Generate()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	jumpHosts, err := createJumpHosts(&git.AuthOptions{
+		JumpHosts: []git.JumpHost{
+			{Host: "bastion.example.com", Username: "jump", Identity: kp.PrivateKey, KnownHosts: []byte(knownHostsFixture)},
+			{Host: "internal.example.com:2222", Username: "jump", Identity: kp.PrivateKey, KnownHosts: []byte(knownHostsFixture)},
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(jumpHosts).To(HaveLen(2))
+	g.Expect(jumpHosts[0].Addr).To(Equal("bastion.example.com:22"))
+	g.Expect(jumpHosts[1].Addr).To(Equal("internal.example.com:2222"))
+	g.Expect(jumpHosts[0].Config.User).To(Equal("jump"))
+	g.Expect(jumpHosts[0].Config.HostKeyCallback).ToNot(BeNil())
+
+	_, err = createJumpHosts(&git.AuthOptions{
+		JumpHosts: []git.JumpHost{{Host: "bastion.example.com", Identity: []byte("invalid")}},
+	})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("jump host 'bastion.example.com'"))
+}
+
 func Test_checkKnownHost(t *testing.T) {
 	tests := []struct {
 		name         string
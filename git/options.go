@@ -19,6 +19,7 @@ package git
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
@@ -120,6 +121,50 @@ type AuthOptions struct {
 	Identity   []byte
 	KnownHosts []byte
 	CAFile     []byte
+	// Certificate is an optional OpenSSH user certificate, in
+	// authorized_keys format, signed for the public key of the Identity.
+	Certificate []byte
+	// SSHAgent enables authentication with the keys (and certificates)
+	// held by the SSH agent listening on the socket set with the
+	// SSH_AUTH_SOCK environment variable. The Identity, if set, is tried
+	// after the agent keys, and used on its own if the agent can not be
+	// reached.
+	SSHAgent bool
+	// JumpHosts is an optional chain of SSH jump hosts through which the
+	// SSH connection to the Host is tunneled, in the order they are
+	// connected to.
+	// The jump hosts are not read from the secret data by NewAuthOptions,
+	// and have to be set by the caller. They only authenticate with their
+	// own Identity, the SSH agent is not used for them even if SSHAgent is
+	// set.
+	JumpHosts []JumpHost
+}
+
+// JumpHost holds the connection details of an SSH jump host (bastion).
+type JumpHost struct {
+	// Host is the address of the jump host, in the form "host[:port]".
+	// The port defaults to 22.
+	Host string
+	// Username is the user to authenticate as with the jump host.
+	Username string
+	// Identity is the private key to authenticate with the jump host.
+	Identity []byte
+	// Password is the passphrase of the Identity, if encrypted.
+	Password string
+	// Certificate is an optional OpenSSH user certificate, in
+	// authorized_keys format, signed for the public key of the Identity.
+	Certificate []byte
+	// KnownHosts holds the known_hosts entries used to verify the host
+	// key of the jump host.
+	KnownHosts []byte
+}
+
+// Addr returns the address of the jump host, in the form "host:port".
+func (j JumpHost) Addr() string {
+	if _, _, err := net.SplitHostPort(j.Host); err == nil {
+		return j.Host
+	}
+	return net.JoinHostPort(j.Host, "22")
 }
 
 // KexAlgos hosts the key exchange algorithms to be used for SSH connections.
@@ -141,12 +186,26 @@ func (o AuthOptions) Validate() error {
 		if o.Host == "" {
 			return fmt.Errorf("invalid '%s' auth option: 'host' is required", o.Transport)
 		}
-		if len(o.Identity) == 0 {
+		if len(o.Identity) == 0 && !o.SSHAgent {
 			return fmt.Errorf("invalid '%s' auth option: 'identity' is required", o.Transport)
 		}
 		if len(o.KnownHosts) == 0 {
 			return fmt.Errorf("invalid '%s' auth option: 'known_hosts' is required", o.Transport)
 		}
+		for i, j := range o.JumpHosts {
+			if j.Host == "" {
+				return fmt.Errorf("invalid '%s' auth option: jump host %d: 'host' is required", o.Transport, i)
+			}
+			if j.Username == "" {
+				return fmt.Errorf("invalid '%s' auth option: jump host '%s': 'username' is required", o.Transport, j.Host)
+			}
+			if len(j.Identity) == 0 {
+				return fmt.Errorf("invalid '%s' auth option: jump host '%s': 'identity' is required", o.Transport, j.Host)
+			}
+			if len(j.KnownHosts) == 0 {
+				return fmt.Errorf("invalid '%s' auth option: jump host '%s': 'known_hosts' is required", o.Transport, j.Host)
+			}
+		}
 	case "":
 		return fmt.Errorf("no transport type set")
 	default:
@@ -166,6 +225,7 @@ func NewAuthOptions(u url.URL, data map[string][]byte) (*AuthOptions, error) {
 		opts.CAFile = data["caFile"]
 		opts.Identity = data["identity"]
 		opts.KnownHosts = data["known_hosts"]
+		opts.Certificate = data["identity-cert.pub"]
 	}
 
 	if opts.Username == "" {
@@ -178,6 +238,11 @@ func NewAuthOptions(u url.URL, data map[string][]byte) (*AuthOptions, error) {
 	if opts.Username == "" && opts.Transport == SSH {
 		opts.Username = DefaultPublicKeyAuthUser
 	}
+	// The passphrase of an encrypted SSH identity can be provided as
+	// either 'password' or 'passphrase'.
+	if opts.Password == "" && opts.Transport == SSH {
+		opts.Password = string(data["passphrase"])
+	}
 	if opts.Password == "" {
 		opts.Password, _ = u.User.Password()
 	}
@@ -145,6 +145,56 @@ func TestAuthOptions_Validate(t *testing.T) {
 				KnownHosts: []byte(knownHostsFixture),
 			},
 		},
+		{
+			name: "Valid SSH transport with SSH agent",
+			opts: AuthOptions{
+				Host:       "github.com:22",
+				Transport:  SSH,
+				SSHAgent:   true,
+				KnownHosts: []byte(knownHostsFixture),
+			},
+		},
+		{
+			name: "SSH jump host requires username",
+			opts: AuthOptions{
+				Host:       "github.com:22",
+				Transport:  SSH,
+				Identity:   []byte(privateKeyFixture),
+				KnownHosts: []byte(knownHostsFixture),
+				JumpHosts:  []JumpHost{{Host: "bastion.example.com"}},
+			},
+			wantErr: "invalid 'ssh' auth option: jump host 'bastion.example.com': 'username' is required",
+		},
+		{
+			name: "SSH jump host requires known_hosts",
+			opts: AuthOptions{
+				Host:       "github.com:22",
+				Transport:  SSH,
+				Identity:   []byte(privateKeyFixture),
+				KnownHosts: []byte(knownHostsFixture),
+				JumpHosts: []JumpHost{{
+					Host:     "bastion.example.com",
+					Username: "jump",
+					Identity: []byte(privateKeyFixture),
+				}},
+			},
+			wantErr: "invalid 'ssh' auth option: jump host 'bastion.example.com': 'known_hosts' is required",
+		},
+		{
+			name: "Valid SSH transport with jump hosts",
+			opts: AuthOptions{
+				Host:       "github.com:22",
+				Transport:  SSH,
+				Identity:   []byte(privateKeyFixture),
+				KnownHosts: []byte(knownHostsFixture),
+				JumpHosts: []JumpHost{{
+					Host:       "bastion.example.com",
+					Username:   "jump",
+					Identity:   []byte(privateKeyFixture),
+					KnownHosts: []byte(knownHostsFixture),
+				}},
+			},
+		},
 		{
 			name:    "No transport",
 			opts:    AuthOptions{},
@@ -172,6 +222,14 @@ func TestAuthOptions_Validate(t *testing.T) {
 	}
 }
 
+func TestJumpHost_Addr(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(JumpHost{Host: "bastion.example.com"}.Addr()).To(Equal("bastion.example.com:22"))
+	g.Expect(JumpHost{Host: "bastion.example.com:2222"}.Addr()).To(Equal("bastion.example.com:2222"))
+	g.Expect(JumpHost{Host: "::1"}.Addr()).To(Equal("[::1]:22"))
+}
+
 func TestAuthOptionsFromData(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -184,11 +242,12 @@ func TestAuthOptionsFromData(t *testing.T) {
 			name: "Sets values from Secret",
 			URL:  "https://git@example.com",
 			data: map[string][]byte{
-				"username":    []byte("example"), // This takes precedence over the one from the URL
-				"password":    []byte("secret"),
-				"identity":    []byte(privateKeyFixture),
-				"known_hosts": []byte(knownHostsFixture),
-				"caFile":      []byte("mock"),
+				"username":          []byte("example"), // This takes precedence over the one from the URL
+				"password":          []byte("secret"),
+				"identity":          []byte(privateKeyFixture),
+				"known_hosts":       []byte(knownHostsFixture),
+				"caFile":            []byte("mock"),
+				"identity-cert.pub": []byte("cert"),
 			},
 
 			wantFunc: func(g *WithT, opts *AuthOptions) {
@@ -197,6 +256,7 @@ func TestAuthOptionsFromData(t *testing.T) {
 				g.Expect(opts.Identity).To(BeEquivalentTo(privateKeyFixture))
 				g.Expect(opts.KnownHosts).To(BeEquivalentTo(knownHostsFixture))
 				g.Expect(opts.CAFile).To(BeEquivalentTo("mock"))
+				g.Expect(opts.Certificate).To(BeEquivalentTo("cert"))
 			},
 		},
 		{
@@ -210,6 +270,18 @@ func TestAuthOptionsFromData(t *testing.T) {
 				g.Expect(opts.Username).To(Equal(DefaultPublicKeyAuthUser))
 			},
 		},
+		{
+			name: "Sets passphrase for SSH",
+			URL:  "ssh://example.com",
+			data: map[string][]byte{
+				"identity":    []byte(privateKeyPassphraseFixture),
+				"known_hosts": []byte(knownHostsFixture),
+				"passphrase":  []byte("foobar"),
+			},
+			wantFunc: func(g *WithT, opts *AuthOptions) {
+				g.Expect(opts.Password).To(Equal("foobar"))
+			},
+		},
 		{
 			name: "Sets transport from URL",
 			URL:  "http://git@example.com",
@@ -0,0 +1,101 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gittestserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSHAgent is an in-process SSH agent, served on a Unix socket.
+type SSHAgent struct {
+	keyring  agent.Agent
+	dir      string
+	listener net.Listener
+}
+
+// NewSSHAgent returns a started SSHAgent without any keys, listening on a
+// socket in a new temporary directory.
+func NewSSHAgent() (*SSHAgent, error) {
+	dir, err := os.MkdirTemp("", "ssh-agent-")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	a := &SSHAgent{
+		keyring:  agent.NewKeyring(),
+		dir:      dir,
+		listener: listener,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(a.keyring, conn)
+			}()
+		}
+	}()
+	return a, nil
+}
+
+// AddKey adds the given PEM encoded private key to the agent, together
+// with the optional OpenSSH certificate in authorized_keys format.
+func (a *SSHAgent) AddKey(privateKey, certificate []byte) error {
+	pk, err := ssh.ParseRawPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	key := agent.AddedKey{PrivateKey: pk}
+	if len(certificate) > 0 {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(certificate)
+		if err != nil {
+			return err
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok {
+			return fmt.Errorf("public key of type '%s' is not a certificate", pub.Type())
+		}
+		key.Certificate = cert
+	}
+	return a.keyring.Add(key)
+}
+
+// Socket returns the path of the socket of the agent, to be set as
+// SSH_AUTH_SOCK.
+func (a *SSHAgent) Socket() string {
+	return a.listener.Addr().String()
+}
+
+// Stop stops the agent and removes its socket.
+func (a *SSHAgent) Stop() error {
+	err := a.listener.Close()
+	os.RemoveAll(a.dir)
+	return err
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gittestserver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// JumpServer is an SSH server which only allows TCP/IP forwarding, to be
+// used as jump host (bastion) in front of a GitServer or another
+// JumpServer.
+type JumpServer struct {
+	config         *ssh.ServerConfig
+	hostKey        ssh.Signer
+	authorizedKeys []ssh.PublicKey
+	listener       net.Listener
+
+	mu        sync.Mutex
+	forwarded []string
+}
+
+// NewJumpServer returns a JumpServer with a generated ed25519 host key.
+// Until AuthorizedKeys is called, all public keys are accepted.
+func NewJumpServer() (*JumpServer, error) {
+	_, pk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := ssh.NewSignerFromKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	s := &JumpServer{hostKey: hostKey}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.checkPublicKey,
+	}
+	s.config.AddHostKey(hostKey)
+	return s, nil
+}
+
+// AuthorizedKeys restricts the public keys accepted by the server to the
+// given keys, in authorized_keys format.
+func (s *JumpServer) AuthorizedKeys(keys ...[]byte) error {
+	for _, k := range keys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey(k)
+		if err != nil {
+			return err
+		}
+		s.authorizedKeys = append(s.authorizedKeys, key)
+	}
+	return nil
+}
+
+// Start starts the server on a random local port, serving connections in
+// the background.
+func (s *JumpServer) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConn(conn)
+		}
+	}()
+	return nil
+}
+
+// Stop stops the server from accepting new connections.
+func (s *JumpServer) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Address returns the address of the server, in the form "host:port".
+func (s *JumpServer) Address() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// KnownHosts returns the known_hosts entry for the host key of the server.
+func (s *JumpServer) KnownHosts() []byte {
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Address())}, s.hostKey.PublicKey())
+	return []byte(line + "\n")
+}
+
+// ForwardedAddresses returns the addresses of the connections forwarded by
+// the server, in the order they were requested.
+func (s *JumpServer) ForwardedAddresses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwarded...)
+}
+
+func (s *JumpServer) checkPublicKey(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if len(s.authorizedKeys) == 0 {
+		return nil, nil
+	}
+	for _, k := range s.authorizedKeys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("unauthorized public key")
+}
+
+func (s *JumpServer) handleConn(conn net.Conn) {
+	sConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "direct-tcpip" {
+			newChan.Reject(ssh.UnknownChannelType, "only TCP/IP forwarding is allowed")
+			continue
+		}
+		go s.forward(newChan)
+	}
+}
+
+// forward handles a "direct-tcpip" channel request, as defined in RFC 4254
+// section 7.2.
+func (s *JumpServer) forward(newChan ssh.NewChannel) {
+	var payload struct {
+		Addr       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+		newChan.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+	addr := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer target.Close()
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, addr)
+	s.mu.Unlock()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, ch)
+		if c, ok := target.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(ch, target)
+		ch.CloseWrite()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
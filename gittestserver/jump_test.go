@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gittestserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestJumpServer(t *testing.T) {
+	// Echo server to forward connections to.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	_, pk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := NewJumpServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.AuthorizedKeys(ssh.MarshalAuthorizedKey(signer.PublicKey())); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	_, _, hostKey, _, _, err := ssh.ParseKnownHosts(srv.KnownHosts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeyCallback := ssh.FixedHostKey(hostKey)
+
+	// Unauthorized keys are rejected.
+	_, otherPK, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(otherPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssh.Dial("tcp", srv.Address(), &ssh.ClientConfig{
+		User:            "jump",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(otherSigner)},
+		HostKeyCallback: hostKeyCallback,
+	}); err == nil {
+		t.Fatal("expected unauthorized key to be rejected")
+	}
+
+	client, err := ssh.Dial("tcp", srv.Address(), &ssh.ClientConfig{
+		User:            "jump",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := client.Dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected echoed 'ping', got '%s'", buf)
+	}
+
+	if got := srv.ForwardedAddresses(); len(got) != 1 || got[0] != target.Addr().String() {
+		t.Errorf("unexpected forwarded addresses: %v", got)
+	}
+	if want := knownhosts.Normalize(srv.Address()); string(srv.KnownHosts()[:len(want)]) != want {
+		t.Errorf("expected known_hosts entry for '%s', got '%s'", want, srv.KnownHosts())
+	}
+}
@@ -0,0 +1,498 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gittestserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/gitkit"
+)
+
+// zeroHash is the hash git uses to denote a non-existing ref, e.g. the old
+// hash of a newly created branch.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// PushEvent describes a single ref update accepted by the git server.
+type PushEvent struct {
+	// Repository is the path of the repository relative to the server
+	// root, e.g. "org/repo.git".
+	Repository string
+	// Ref is the full name of the updated ref, e.g. "refs/heads/main".
+	Ref string
+	// OldHash is the hash the ref pointed to before the push. It is the
+	// zero hash if the ref was created.
+	OldHash string
+	// NewHash is the hash the ref points to after the push. It is the
+	// zero hash if the ref was deleted.
+	NewHash string
+	// Pusher is the username used to authenticate the push, if any.
+	Pusher string
+	// Time is the time at which the push was recorded.
+	Time time.Time
+}
+
+// Created returns true if the event describes the creation of a ref.
+func (e PushEvent) Created() bool {
+	return e.OldHash == zeroHash
+}
+
+// Deleted returns true if the event describes the deletion of a ref.
+func (e PushEvent) Deleted() bool {
+	return e.NewHash == zeroHash
+}
+
+// WebhookFormat is the payload format used when emitting push webhooks.
+type WebhookFormat string
+
+const (
+	// GitHubWebhook emits GitHub compatible push payloads, signed with
+	// HMAC-SHA1 and HMAC-SHA256 in the X-Hub-Signature and
+	// X-Hub-Signature-256 headers.
+	GitHubWebhook WebhookFormat = "github"
+	// GitLabWebhook emits GitLab compatible push payloads, with the secret
+	// set as the X-Gitlab-Token header.
+	GitLabWebhook WebhookFormat = "gitlab"
+)
+
+// webhook holds the configuration of a push webhook receiver.
+type webhook struct {
+	url    string
+	format WebhookFormat
+	secret []byte
+}
+
+// pushHook is the reference-transaction hook script reporting the ref
+// updates committed by a push to the push recorder listening at the given
+// host and port, and waiting for them to be recorded. Unlike post-receive,
+// the hook runs before the push is reported as completed to the client.
+// The pusher is the key ID set by the SSH server.
+const pushHook = `#!/bin/bash
+[ "$1" = committed ] || { cat >/dev/null; exit 0; }
+exec 3<>/dev/tcp/%s/%s || exit 0
+{ echo "$PWD"; echo "$GITKIT_KEY"; cat; echo; } >&3
+read -r _ <&3
+`
+
+// pushHookInstaller is the pre-receive hook script installing the given
+// reference-transaction hook, for the repositories created on push when
+// AutoCreate is enabled.
+const pushHookInstaller = `#!/bin/bash
+cat >/dev/null
+cat >hooks/reference-transaction <<'EOF'
+%sEOF
+chmod +x hooks/reference-transaction
+`
+
+// RecordPushes enables the recording of push events for pushes made over
+// HTTP and SSH. The recorded events can be retrieved with PushEvents. Use
+// before calling StartHTTP, StartHTTPS or ListenSSH.
+//
+// The pushes are reported by a reference-transaction hook installed along
+// with the hook set by InstallUpdateHook, which requires bash and git 2.28
+// or later on the server. With AutoCreate, the hook is installed in the
+// created repositories by gitkit, which requires the directories at the
+// root to be repositories, as for InstallUpdateHook.
+func (s *GitServer) RecordPushes() *GitServer {
+	s.recordPushes = true
+	return s
+}
+
+// AddWebhook configures the git server to POST a push payload of the given
+// format to the given URL for every ref updated by a push made over HTTP or
+// SSH. If secret is not empty, the payload is signed (GitHub) or the secret
+// is sent as token (GitLab). The webhooks are sent once the push completed.
+// Adding a webhook enables the recording of push events. Use before calling
+// StartHTTP, StartHTTPS or ListenSSH.
+func (s *GitServer) AddWebhook(url string, format WebhookFormat, secret []byte) *GitServer {
+	s.recordPushes = true
+	s.webhooks = append(s.webhooks, webhook{url: url, format: format, secret: secret})
+	return s
+}
+
+// PushEvents returns a copy of the push events recorded so far, in the
+// order they were received.
+func (s *GitServer) PushEvents() []PushEvent {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	events := make([]PushEvent, len(s.pushEvents))
+	copy(events, s.pushEvents)
+	return events
+}
+
+// ResetPushEvents discards all the push events recorded so far.
+func (s *GitServer) ResetPushEvents() {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	s.pushEvents = nil
+}
+
+// WebhookErrors returns the errors that occurred while delivering webhooks.
+func (s *GitServer) WebhookErrors() []error {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	errs := make([]error, len(s.webhookErrors))
+	copy(errs, s.webhookErrors)
+	return errs
+}
+
+// startPushRecorder starts the listener of the push recorder for the given
+// server, if push recording is enabled, and configures the hook reporting
+// the pushes to it. The listener is shared by the HTTP and
+// SSH servers.
+func (s *GitServer) startPushRecorder(server string) error {
+	if !s.recordPushes {
+		return nil
+	}
+
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	if s.pushRecorderUsers == nil {
+		s.pushRecorderUsers = make(map[string]bool)
+	}
+	s.pushRecorderUsers[server] = true
+	if s.pushListener != nil {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.pushListener = l
+	if s.config.Hooks == nil {
+		s.config.Hooks = &gitkit.HookScripts{}
+	}
+	s.pushHook = fmt.Sprintf(pushHook, host, port)
+	if s.config.AutoCreate {
+		s.config.Hooks.PreReceive = fmt.Sprintf(pushHookInstaller, s.pushHook)
+		s.config.AutoHooks = true
+	}
+	go s.servePushRecorder(l)
+	return nil
+}
+
+// stopPushRecorder stops the listener of the push recorder once none of
+// the HTTP and SSH servers use it.
+func (s *GitServer) stopPushRecorder(server string) {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	delete(s.pushRecorderUsers, server)
+	if len(s.pushRecorderUsers) == 0 && s.pushListener != nil {
+		s.pushListener.Close()
+		s.pushListener = nil
+	}
+}
+
+// servePushRecorder records the pushes reported by the hooks
+// connecting to the given listener, until it is closed.
+func (s *GitServer) servePushRecorder(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.recordPush(conn)
+	}
+}
+
+// recordPush records the ref updates reported by a hook on
+// the given connection, acknowledges them so that the push completes, and
+// emits them to the configured webhooks.
+func (s *GitServer) recordPush(conn net.Conn) {
+	defer conn.Close()
+
+	// The repository directory and the pusher are followed by the ref
+	// updates, terminated by an empty line.
+	scanner := bufio.NewScanner(conn)
+	var lines []string
+	for scanner.Scan() && (len(lines) < 2 || scanner.Text() != "") {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 2 {
+		return
+	}
+
+	repoName, err := s.repositoryName(lines[0])
+	if err != nil {
+		return
+	}
+	pusher := lines[1]
+	if pusher == "" && s.config.Auth {
+		// HTTP pushes are authenticated with the only configured user.
+		pusher = s.username
+	}
+	now := time.Now()
+	var events []PushEvent
+	for _, line := range lines[2:] {
+		// Only the branch and tag updates are recorded, not the updates of
+		// HEAD when a branch is born.
+		fields := strings.Fields(line)
+		if len(fields) != 3 || len(fields[0]) != len(zeroHash) || len(fields[1]) != len(zeroHash) ||
+			!(strings.HasPrefix(fields[2], "refs/heads/") || strings.HasPrefix(fields[2], "refs/tags/")) {
+			continue
+		}
+		events = append(events, PushEvent{
+			Repository: repoName, Ref: fields[2], OldHash: fields[0], NewHash: fields[1], Pusher: pusher, Time: now,
+		})
+	}
+
+	s.pushMu.Lock()
+	s.pushEvents = append(s.pushEvents, events...)
+	s.pushMu.Unlock()
+	fmt.Fprintln(conn, "ok")
+	conn.Close()
+
+	for _, hook := range s.webhooks {
+		for _, event := range events {
+			if err := s.sendWebhook(hook, event); err != nil {
+				s.pushMu.Lock()
+				s.webhookErrors = append(s.webhookErrors, err)
+				s.pushMu.Unlock()
+			}
+		}
+	}
+}
+
+// repositoryName returns the path of the repository at the given directory
+// relative to the server root.
+func (s *GitServer) repositoryName(dir string) (string, error) {
+	root, err := filepath.EvalSymlinks(s.Root())
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// setupHooks installs the configured hook scripts in the repositories
+// under the server root, and creates the root if it does not exist.
+func (s *GitServer) setupHooks() error {
+	if err := os.MkdirAll(s.Root(), 0o755); err != nil {
+		return err
+	}
+	if s.config.Hooks == nil {
+		return nil
+	}
+	return filepath.WalkDir(s.Root(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(path, "objects")); err != nil {
+			return nil
+		}
+		if err := s.installHooks(path); err != nil {
+			return err
+		}
+		return filepath.SkipDir
+	})
+}
+
+// installHooks writes the configured hook scripts and the push hook to the
+// hooks directory of the bare repository at the given path.
+func (s *GitServer) installHooks(repoPath string) error {
+	if s.config.Hooks == nil {
+		return nil
+	}
+	hooksDir := filepath.Join(repoPath, "hooks")
+	for name, script := range map[string]string{
+		"pre-receive":           s.config.Hooks.PreReceive,
+		"update":                s.config.Hooks.Update,
+		"post-receive":          s.config.Hooks.PostReceive,
+		"reference-transaction": s.pushHook,
+	} {
+		if script == "" {
+			continue
+		}
+		if err := os.MkdirAll(hooksDir, 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(hooksDir, name), []byte(script), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendWebhook POSTs the payload for the given event to the webhook.
+func (s *GitServer) sendWebhook(hook webhook, event PushEvent) error {
+	var (
+		payload interface{}
+		headers = http.Header{}
+	)
+	address := s.HTTPAddress()
+	if address == "" {
+		address = s.SSHAddress()
+	}
+	cloneURL := address + "/" + event.Repository
+	switch hook.format {
+	case GitHubWebhook:
+		payload = newGitHubPushPayload(event, cloneURL)
+		headers.Set("X-GitHub-Event", "push")
+	case GitLabWebhook:
+		payload = newGitLabPushPayload(event, cloneURL)
+		headers.Set("X-Gitlab-Event", "Push Hook")
+		if len(hook.secret) > 0 {
+			headers.Set("X-Gitlab-Token", string(hook.secret))
+		}
+	default:
+		return fmt.Errorf("unsupported webhook format '%s'", hook.format)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if hook.format == GitHubWebhook && len(hook.secret) > 0 {
+		headers.Set("X-Hub-Signature", "sha1="+signPayload(sha1.New, hook.secret, body))
+		headers.Set("X-Hub-Signature-256", "sha256="+signPayload(sha256.New, hook.secret, body))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = headers
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook to '%s': %w", hook.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook '%s' responded with status code: %d", hook.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the hex encoded HMAC-SHA256 of the payload
+// using the given secret, as sent in the GitHub X-Hub-Signature-256 header
+// without the "sha256=" prefix.
+func SignWebhookPayload(secret, payload []byte) string {
+	return signPayload(sha256.New, secret, payload)
+}
+
+func signPayload(h func() hash.Hash, secret, payload []byte) string {
+	mac := hmac.New(h, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type gitHubPushPayload struct {
+	Ref        string           `json:"ref"`
+	Before     string           `json:"before"`
+	After      string           `json:"after"`
+	Created    bool             `json:"created"`
+	Deleted    bool             `json:"deleted"`
+	Repository gitHubRepository `json:"repository"`
+	Pusher     gitHubPusher     `json:"pusher"`
+}
+
+type gitHubRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	CloneURL string `json:"clone_url"`
+}
+
+type gitHubPusher struct {
+	Name string `json:"name"`
+}
+
+func newGitHubPushPayload(event PushEvent, cloneURL string) gitHubPushPayload {
+	return gitHubPushPayload{
+		Ref:     event.Ref,
+		Before:  event.OldHash,
+		After:   event.NewHash,
+		Created: event.Created(),
+		Deleted: event.Deleted(),
+		Repository: gitHubRepository{
+			Name:     path.Base(event.Repository),
+			FullName: event.Repository,
+			CloneURL: cloneURL,
+		},
+		Pusher: gitHubPusher{Name: event.Pusher},
+	}
+}
+
+type gitLabPushPayload struct {
+	ObjectKind   string           `json:"object_kind"`
+	EventName    string           `json:"event_name"`
+	Ref          string           `json:"ref"`
+	Before       string           `json:"before"`
+	After        string           `json:"after"`
+	UserUsername string           `json:"user_username"`
+	Project      gitLabProject    `json:"project"`
+	Repository   gitLabRepository `json:"repository"`
+}
+
+type gitLabProject struct {
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	GitHTTPURL        string `json:"git_http_url"`
+}
+
+type gitLabRepository struct {
+	Name       string `json:"name"`
+	GitHTTPURL string `json:"git_http_url"`
+}
+
+func newGitLabPushPayload(event PushEvent, cloneURL string) gitLabPushPayload {
+	name := path.Base(event.Repository)
+	return gitLabPushPayload{
+		ObjectKind:   "push",
+		EventName:    "push",
+		Ref:          event.Ref,
+		Before:       event.OldHash,
+		After:        event.NewHash,
+		UserUsername: event.Pusher,
+		Project: gitLabProject{
+			Name:              name,
+			PathWithNamespace: event.Repository,
+			GitHTTPURL:        cloneURL,
+		},
+		Repository: gitLabRepository{
+			Name:       name,
+			GitHTTPURL: cloneURL,
+		},
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -94,6 +95,15 @@ type GitServer struct {
 	// Set these to configure HTTP auth
 	username, password string
 	httpMiddlewares    []HTTPMiddleware
+	// Set these to record pushes and emit webhooks
+	recordPushes      bool
+	webhooks          []webhook
+	pushMu            sync.Mutex
+	pushEvents        []PushEvent
+	webhookErrors     []error
+	pushListener      net.Listener
+	pushRecorderUsers map[string]bool
+	pushHook          string
 }
 
 // AddHTTPMiddlewares adds http middlewares to the git server.
@@ -118,12 +128,12 @@ func (s *GitServer) KeyDir(dir string) *GitServer {
 // InstallUpdateHook installs a hook script that will run running
 // _before_ a push is accepted, as described at
 //
-//    https://git-scm.com/book/en/v2/Customizing-Git-Git-Hooks
+//	https://git-scm.com/book/en/v2/Customizing-Git-Git-Hooks
 //
 // The provided string is written as an executable script to the hooks
 // directory; start with a hashbang to make sure it'll run, e.g.,
 //
-//     #!/bin/bash
+//	#!/bin/bash
 func (s *GitServer) InstallUpdateHook(script string) *GitServer {
 	if s.config.Hooks == nil {
 		s.config.Hooks = &gitkit.HookScripts{}
@@ -148,13 +158,16 @@ func (s *GitServer) Auth(username, password string) *GitServer {
 // StartHTTP starts a new HTTP git server with the current configuration.
 func (s *GitServer) StartHTTP() error {
 	s.StopHTTP()
+	if err := s.startPushRecorder("http"); err != nil {
+		return err
+	}
 	service := gitkit.New(s.config)
 	if s.config.Auth {
 		service.AuthFunc = func(cred gitkit.Credential, _ *gitkit.Request) (bool, error) {
 			return cred.Username == s.username && cred.Password == s.password, nil
 		}
 	}
-	if err := service.Setup(); err != nil {
+	if err := s.setupHooks(); err != nil {
 		return err
 	}
 	handler := buildHTTPHandler(service, s.httpMiddlewares...)
@@ -165,13 +178,16 @@ func (s *GitServer) StartHTTP() error {
 // StartHTTPS starts the TLS HTTPServer with the given TLS configuration.
 func (s *GitServer) StartHTTPS(cert, key, ca []byte, serverName string) error {
 	s.StopHTTP()
+	if err := s.startPushRecorder("http"); err != nil {
+		return err
+	}
 	service := gitkit.New(s.config)
 	if s.config.Auth {
 		service.AuthFunc = func(cred gitkit.Credential, _ *gitkit.Request) (bool, error) {
 			return cred.Username == s.username && cred.Password == s.password, nil
 		}
 	}
-	if err := service.Setup(); err != nil {
+	if err := s.setupHooks(); err != nil {
 		return err
 	}
 	handler := buildHTTPHandler(service, s.httpMiddlewares...)
@@ -201,7 +217,7 @@ func (s *GitServer) StopHTTP() {
 	if s.httpServer != nil {
 		s.httpServer.Close()
 	}
-	return
+	s.stopPushRecorder("http")
 }
 
 // PublicKeyLookupFunc sets the function to be used for SSH authentication.
@@ -227,6 +243,12 @@ func (s *GitServer) ListenSSH() error {
 	if sshServer == nil {
 		m.Lock()
 		defer m.Unlock()
+		if err := s.startPushRecorder("ssh"); err != nil {
+			return err
+		}
+		if err := s.setupHooks(); err != nil {
+			return err
+		}
 		s.sshServer = gitkit.NewSSH(s.config)
 
 		if s.sshServerConfig != nil {
@@ -262,6 +284,7 @@ func (s *GitServer) StopSSH() error {
 	sshServer := s.sshServer
 	m.RUnlock()
 
+	s.stopPushRecorder("ssh")
 	if sshServer != nil {
 		return sshServer.Stop()
 	}
@@ -378,9 +401,15 @@ func (s *GitServer) InitRepo(fixture, branch, repoPath string) error {
 		}
 	}
 
-	return repo.Push(&gogit.PushOptions{
+	if err := repo.Push(&gogit.PushOptions{
 		RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"},
-	})
+	}); err != nil {
+		return err
+	}
+
+	// The hooks are installed once initialized, so that the fixture is not
+	// recorded as a push.
+	return s.installHooks(localRepo)
 }
 
 func commitFromFixture(repo *gogit.Repository, fixture string) error {
@@ -1,8 +1,13 @@
 package gittestserver
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,7 +15,10 @@ import (
 	"time"
 
 	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	gogitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestCreateSSHServer(t *testing.T) {
@@ -236,3 +244,219 @@ func TestGitServer_AddHTTPMiddlewares(t *testing.T) {
 		t.Errorf("expected error status code 500, got: %v", err)
 	}
 }
+
+func TestGitServer_RecordPushes(t *testing.T) {
+	repoPath := "bar/test-reponame"
+	secret := []byte("webhook-secret")
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	hooks := make(chan received, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		hooks <- received{header: r.Header, body: body}
+	}))
+	defer receiver.Close()
+
+	srv, err := NewTempGitServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srv.Root())
+	srv.Auth("test-user", "test-pswd").
+		AddWebhook(receiver.URL, GitHubWebhook, secret).
+		AddWebhook(receiver.URL, GitLabWebhook, secret)
+	if err = srv.StartHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.StopHTTP()
+
+	if err = srv.InitRepo("testdata/git/repo1", "master", repoPath); err != nil {
+		t.Fatalf("failed to initialize repo: %v", err)
+	}
+	if events := srv.PushEvents(); len(events) != 0 {
+		t.Fatalf("expected no push events for the repo initialization, got: %v", events)
+	}
+
+	cloneDir := t.TempDir()
+	repo, err := gogit.PlainClone(cloneDir, false, &gogit.CloneOptions{
+		URL: srv.HTTPAddressWithCredentials() + "/" + repoPath,
+	})
+	if err != nil {
+		t.Fatalf("failed to clone repo: %v", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Push(&gogit.PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/feature"},
+	}); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+
+	events := srv.PushEvents()
+	if len(events) != 1 {
+		t.Fatalf("expected exactly one push event, got: %v", events)
+	}
+	event := events[0]
+	if event.Repository != repoPath || event.Ref != "refs/heads/feature" || event.Pusher != "test-user" {
+		t.Errorf("unexpected push event: %+v", event)
+	}
+	if !event.Created() || event.NewHash != head.Hash().String() {
+		t.Errorf("expected creation of ref at %s, got: %+v", head.Hash(), event)
+	}
+
+	for i := 0; i < 2; i++ {
+		var hook received
+		select {
+		case hook = <-hooks:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+		}
+		switch {
+		case hook.header.Get("X-GitHub-Event") == "push":
+			want := "sha256=" + SignWebhookPayload(secret, hook.body)
+			if got := hook.header.Get("X-Hub-Signature-256"); got != want {
+				t.Errorf("unexpected signature %q, want: %q", got, want)
+			}
+			var payload gitHubPushPayload
+			if err := json.Unmarshal(hook.body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Ref != event.Ref || payload.After != event.NewHash || !payload.Created {
+				t.Errorf("unexpected GitHub payload: %+v", payload)
+			}
+		case hook.header.Get("X-Gitlab-Event") == "Push Hook":
+			if got := hook.header.Get("X-Gitlab-Token"); got != string(secret) {
+				t.Errorf("unexpected token %q", got)
+			}
+			var payload gitLabPushPayload
+			if err := json.Unmarshal(hook.body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Ref != event.Ref || payload.UserUsername != "test-user" {
+				t.Errorf("unexpected GitLab payload: %+v", payload)
+			}
+		default:
+			t.Errorf("unexpected webhook headers: %v", hook.header)
+		}
+	}
+	if errs := srv.WebhookErrors(); len(errs) != 0 {
+		t.Errorf("unexpected webhook errors: %v", errs)
+	}
+
+	srv.ResetPushEvents()
+	if events := srv.PushEvents(); len(events) != 0 {
+		t.Errorf("expected push events to be reset, got: %v", events)
+	}
+}
+
+func TestGitServer_RecordPushes_SSH(t *testing.T) {
+	repoPath := "test-reponame"
+
+	hooks := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		hooks <- body
+	}))
+	defer receiver.Close()
+
+	srv, err := NewTempGitServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srv.Root())
+	srv.Auth("test-user", "test-pswd").AutoCreate().KeyDir(srv.Root()).
+		AddWebhook(receiver.URL, GitHubWebhook, nil)
+	if err = srv.InitRepo("testdata/git/repo1", "master", repoPath); err != nil {
+		t.Fatalf("failed to initialize repo: %v", err)
+	}
+	if err = srv.ListenSSH(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.StartSSH()
+	defer srv.StopSSH()
+
+	_, pk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &gogitssh.PublicKeys{User: "git", Signer: signer}
+	auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	repo, err := gogit.PlainClone(t.TempDir(), false, &gogit.CloneOptions{
+		URL:  srv.SSHAddress() + "/" + repoPath,
+		Auth: auth,
+	})
+	if err != nil {
+		t.Fatalf("failed to clone repo: %v", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Push to the existing repository and to an automatically created one.
+	if err = repo.Push(&gogit.PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/feature"},
+		Auth:     auth,
+	}); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	if _, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: "new",
+		URLs: []string{srv.SSHAddress() + "/new-reponame"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Push(&gogit.PushOptions{
+		RemoteName: "new",
+		RefSpecs:   []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		Auth:       auth,
+	}); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+
+	events := srv.PushEvents()
+	if len(events) != 2 {
+		t.Fatalf("expected exactly two push events, got: %v", events)
+	}
+	for i, want := range []struct{ repo, ref string }{
+		{repoPath, "refs/heads/feature"},
+		{"new-reponame", "refs/heads/master"},
+	} {
+		event := events[i]
+		if event.Repository != want.repo || event.Ref != want.ref || event.Pusher != "test-user" {
+			t.Errorf("unexpected push event: %+v", event)
+		}
+		if !event.Created() || event.NewHash != head.Hash().String() {
+			t.Errorf("expected creation of ref at %s, got: %+v", head.Hash(), event)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		var body []byte
+		select {
+		case body = <-hooks:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+		}
+		var payload gitHubPushPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.After != head.Hash().String() || !strings.HasPrefix(payload.Repository.CloneURL, srv.SSHAddress()) {
+			t.Errorf("unexpected GitHub payload: %+v", payload)
+		}
+	}
+	if errs := srv.WebhookErrors(); len(errs) != 0 {
+		t.Errorf("unexpected webhook errors: %v", errs)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -65,11 +66,15 @@ func NewClient() *Client {
 // https://docs.aws.amazon.com/sdk-for-go/api/aws/session/ as a
 // starting point).
 func (c *Client) getLoginAuth(accountId, awsEcrRegion string) (authn.AuthConfig, error) {
-	// No caching of tokens is attempted; the quota for getting an
-	// auth token is high enough that getting a token every time you
-	// scan an image is viable for O(500) images per region. See
-	// https://docs.aws.amazon.com/general/latest/gr/ecr.html.
+	authConfig, _, err := c.getLoginAuthWithExpiry(accountId, awsEcrRegion)
+	return authConfig, err
+}
+
+// getLoginAuthWithExpiry is like getLoginAuth, and also returns the expiry
+// time of the token for callers to cache it, see login.Manager.
+func (c *Client) getLoginAuthWithExpiry(accountId, awsEcrRegion string) (authn.AuthConfig, time.Time, error) {
 	var authConfig authn.AuthConfig
+	var expiresAt time.Time
 	accountIDs := []string{accountId}
 
 	// Configure session.
@@ -79,52 +84,62 @@ func (c *Client) getLoginAuth(accountId, awsEcrRegion string) (authn.AuthConfig,
 		RegistryIds: aws.StringSlice(accountIDs),
 	})
 	if err != nil {
-		return authConfig, err
+		return authConfig, expiresAt, err
 	}
 
 	// Validate the authorization data.
 	if len(ecrToken.AuthorizationData) == 0 {
-		return authConfig, errors.New("no authorization data")
+		return authConfig, expiresAt, errors.New("no authorization data")
 	}
 	if ecrToken.AuthorizationData[0].AuthorizationToken == nil {
-		return authConfig, fmt.Errorf("no authorization token")
+		return authConfig, expiresAt, fmt.Errorf("no authorization token")
 	}
 	token, err := base64.StdEncoding.DecodeString(*ecrToken.AuthorizationData[0].AuthorizationToken)
 	if err != nil {
-		return authConfig, err
+		return authConfig, expiresAt, err
 	}
 
 	tokenSplit := strings.Split(string(token), ":")
 	// Validate the tokens.
 	if len(tokenSplit) != 2 {
-		return authConfig, fmt.Errorf("invalid authorization token, expected the token to have two parts separated by ':', got %d parts", len(tokenSplit))
+		return authConfig, expiresAt, fmt.Errorf("invalid authorization token, expected the token to have two parts separated by ':', got %d parts", len(tokenSplit))
 	}
 	authConfig = authn.AuthConfig{
 		Username: tokenSplit[0],
 		Password: tokenSplit[1],
 	}
-	return authConfig, nil
+	if ecrToken.AuthorizationData[0].ExpiresAt != nil {
+		expiresAt = *ecrToken.AuthorizationData[0].ExpiresAt
+	}
+	return authConfig, expiresAt, nil
 }
 
 // Login attempts to get the authentication material for ECR. It extracts
 // the account and region information from the image URI. The caller can ensure
 // that the passed image is a valid ECR image using ParseImage().
 func (c *Client) Login(ctx context.Context, autoLogin bool, image string) (authn.Authenticator, error) {
+	auth, _, err := c.LoginWithExpiry(ctx, autoLogin, image)
+	return auth, err
+}
+
+// LoginWithExpiry is like Login, and also returns the expiry time of the
+// authentication material.
+func (c *Client) LoginWithExpiry(ctx context.Context, autoLogin bool, image string) (authn.Authenticator, time.Time, error) {
 	if autoLogin {
 		ctrl.LoggerFrom(ctx).Info("logging in to AWS ECR for " + image)
 		accountId, awsEcrRegion, ok := ParseImage(image)
 		if !ok {
-			return nil, errors.New("failed to parse AWS ECR image, invalid ECR image")
+			return nil, time.Time{}, errors.New("failed to parse AWS ECR image, invalid ECR image")
 		}
 
-		authConfig, err := c.getLoginAuth(accountId, awsEcrRegion)
+		authConfig, expiresAt, err := c.getLoginAuthWithExpiry(accountId, awsEcrRegion)
 		if err != nil {
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
 		auth := authn.FromConfig(authConfig)
-		return auth, nil
+		return auth, expiresAt, nil
 	}
 	ctrl.LoggerFrom(ctx).Info("ECR authentication is not enabled. To enable, set the controller flag --aws-autologin-for-ecr")
-	return nil, fmt.Errorf("ECR authentication failed: %w", oci.ErrUnconfiguredProvider)
+	return nil, time.Time{}, fmt.Errorf("ECR authentication failed: %w", oci.ErrUnconfiguredProvider)
 }
@@ -18,8 +18,12 @@ package azure
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	_ "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
@@ -38,6 +42,7 @@ import (
 type Client struct {
 	credential azcore.TokenCredential
 	scheme     string
+	transport  http.RoundTripper
 }
 
 // NewClient creates a new ACR client with default configurations.
@@ -57,10 +62,25 @@ func (c *Client) WithScheme(scheme string) *Client {
 	return c
 }
 
+// WithTransport sets the transport of the http requests that the client
+// makes to the registry.
+func (c *Client) WithTransport(transport http.RoundTripper) *Client {
+	c.transport = transport
+	return c
+}
+
 // getLoginAuth returns authentication for ACR. The details needed for authentication
 // are gotten from environment variable so there is not need to mount a host path.
 func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.AuthConfig, error) {
+	authConfig, _, err := c.getLoginAuthWithExpiry(ctx, ref)
+	return authConfig, err
+}
+
+// getLoginAuthWithExpiry is like getLoginAuth, and also returns the expiry time
+// of the ACR token, or of the ARM token if the ACR token expiry is unknown.
+func (c *Client) getLoginAuthWithExpiry(ctx context.Context, ref name.Reference) (authn.AuthConfig, time.Time, error) {
 	var authConfig authn.AuthConfig
+	var expiresAt time.Time
 
 	// Use default credentials if no token credential is provided.
 	// NOTE: NewDefaultAzureCredential() performs a lot of environment lookup
@@ -68,7 +88,7 @@ func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.Au
 	if c.credential == nil {
 		cred, err := azidentity.NewDefaultAzureCredential(nil)
 		if err != nil {
-			return authConfig, err
+			return authConfig, expiresAt, err
 		}
 		c.credential = cred
 	}
@@ -79,15 +99,23 @@ func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.Au
 		Scopes: []string{cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint + "/" + ".default"},
 	})
 	if err != nil {
-		return authConfig, err
+		return authConfig, expiresAt, err
 	}
 
 	// Obtain ACR access token using exchanger.
 	endpoint := fmt.Sprintf("%s://%s", c.scheme, ref.Context().RegistryStr())
 	ex := newExchanger(endpoint)
+	if c.transport != nil {
+		ex.client = &http.Client{Transport: c.transport}
+	}
 	accessToken, err := ex.ExchangeACRAccessToken(string(armToken.Token))
 	if err != nil {
-		return authConfig, fmt.Errorf("error exchanging token: %w", err)
+		return authConfig, expiresAt, fmt.Errorf("error exchanging token: %w", err)
+	}
+
+	expiresAt = armToken.ExpiresOn
+	if exp, ok := tokenExpiry(accessToken); ok {
+		expiresAt = exp
 	}
 
 	return authn.AuthConfig{
@@ -95,7 +123,7 @@ func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.Au
 		// See documentation: https://docs.microsoft.com/en-us/azure/container-registry/container-registry-authentication?tabs=azure-cli#az-acr-login-with---expose-token
 		Username: "00000000-0000-0000-0000-000000000000",
 		Password: accessToken,
-	}, nil
+	}, expiresAt, nil
 }
 
 // ValidHost returns if a given host is a Azure container registry.
@@ -112,17 +140,43 @@ func ValidHost(host string) bool {
 // Login attempts to get the authentication material for ACR. The caller can
 // ensure that the passed image is a valid ACR image using ValidHost().
 func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, error) {
+	auth, _, err := c.LoginWithExpiry(ctx, autoLogin, image, ref)
+	return auth, err
+}
+
+// LoginWithExpiry is like Login, and also returns the expiry time of the
+// authentication material.
+func (c *Client) LoginWithExpiry(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, time.Time, error) {
 	if autoLogin {
 		ctrl.LoggerFrom(ctx).Info("logging in to Azure ACR for " + image)
-		authConfig, err := c.getLoginAuth(ctx, ref)
+		authConfig, expiresAt, err := c.getLoginAuthWithExpiry(ctx, ref)
 		if err != nil {
 			ctrl.LoggerFrom(ctx).Info("error logging into ACR " + err.Error())
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
 		auth := authn.FromConfig(authConfig)
-		return auth, nil
+		return auth, expiresAt, nil
 	}
 	ctrl.LoggerFrom(ctx).Info("ACR authentication is not enabled. To enable, set the controller flag --azure-autologin-for-acr")
-	return nil, fmt.Errorf("ACR authentication failed: %w", oci.ErrUnconfiguredProvider)
+	return nil, time.Time{}, fmt.Errorf("ACR authentication failed: %w", oci.ErrUnconfiguredProvider)
+}
+
+// tokenExpiry returns the expiry time of the given JWT, from its exp claim.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
 }
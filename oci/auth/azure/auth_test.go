@@ -18,12 +18,15 @@ package azure
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -176,3 +179,51 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginWithExpiry(t *testing.T) {
+	armExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	acrExpiry := time.Now().Add(3 * time.Hour).Truncate(time.Second)
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %d}`, acrExpiry.Unix())))
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		want         time.Time
+	}{
+		{
+			name:         "ACR token expiry",
+			refreshToken: "header." + claims + ".signature",
+			want:         acrExpiry,
+		},
+		{
+			name:         "ARM token expiry",
+			refreshToken: "bbbbb",
+			want:         armExpiry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(fmt.Sprintf(`{"refresh_token": %q}`, tt.refreshToken)))
+			}))
+			t.Cleanup(srv.Close)
+
+			u, err := url.Parse(srv.URL)
+			g.Expect(err).ToNot(HaveOccurred())
+			image := path.Join(u.Host, "foo/bar:v1")
+			ref, err := name.ParseReference(image)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			ac := NewClient().
+				WithTokenCredential(&FakeTokenCredential{Token: "foo", ExpiresOn: armExpiry}).
+				WithScheme("http")
+
+			_, expiresAt, err := ac.LoginWithExpiry(context.TODO(), true, image, ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(expiresAt).To(BeTemporally("==", tt.want))
+		})
+	}
+}
@@ -66,6 +66,7 @@ type acrError struct {
 
 type exchanger struct {
 	endpoint string
+	client   *http.Client
 }
 
 // newExchanger returns an Azure Exchanger for Azure Container Registry with
@@ -73,6 +74,7 @@ type exchanger struct {
 func newExchanger(endpoint string) *exchanger {
 	return &exchanger{
 		endpoint: endpoint,
+		client:   http.DefaultClient,
 	}
 }
 
@@ -91,7 +93,7 @@ func (e *exchanger) ExchangeACRAccessToken(armToken string) (string, error) {
 	parameters.Add("service", exchangeURL.Hostname())
 	parameters.Add("access_token", armToken)
 
-	resp, err := http.PostForm(exchangeURL.String(), parameters)
+	resp, err := e.client.PostForm(exchangeURL.String(), parameters)
 	if err != nil {
 		return "", fmt.Errorf("failed to send token exchange request: %w", err)
 	}
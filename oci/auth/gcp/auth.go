@@ -23,6 +23,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -67,11 +68,19 @@ func (c *Client) WithTokenURL(url string) *Client {
 // the case if it is hosted on GCP. It works with both service account and
 // workload identity enabled clusters.
 func (c *Client) getLoginAuth(ctx context.Context) (authn.AuthConfig, error) {
+	authConfig, _, err := c.getLoginAuthWithExpiry(ctx)
+	return authConfig, err
+}
+
+// getLoginAuthWithExpiry is like getLoginAuth, and also returns the expiry
+// time of the token.
+func (c *Client) getLoginAuthWithExpiry(ctx context.Context) (authn.AuthConfig, time.Time, error) {
 	var authConfig authn.AuthConfig
+	var expiresAt time.Time
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.tokenURL, nil)
 	if err != nil {
-		return authConfig, err
+		return authConfig, expiresAt, err
 	}
 
 	request.Header.Add("Metadata-Flavor", "Google")
@@ -79,42 +88,53 @@ func (c *Client) getLoginAuth(ctx context.Context) (authn.AuthConfig, error) {
 	client := &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
-		return authConfig, err
+		return authConfig, expiresAt, err
 	}
 	defer response.Body.Close()
 	defer io.Copy(io.Discard, response.Body)
 
 	if response.StatusCode != http.StatusOK {
-		return authConfig, fmt.Errorf("unexpected status from metadata service: %s", response.Status)
+		return authConfig, expiresAt, fmt.Errorf("unexpected status from metadata service: %s", response.Status)
 	}
 
+	requestTime := time.Now()
 	var accessToken gceToken
 	decoder := json.NewDecoder(response.Body)
 	if err := decoder.Decode(&accessToken); err != nil {
-		return authConfig, err
+		return authConfig, expiresAt, err
 	}
 
 	authConfig = authn.AuthConfig{
 		Username: "oauth2accesstoken",
 		Password: accessToken.AccessToken,
 	}
-	return authConfig, nil
+	if accessToken.ExpiresIn > 0 {
+		expiresAt = requestTime.Add(time.Duration(accessToken.ExpiresIn) * time.Second)
+	}
+	return authConfig, expiresAt, nil
 }
 
 // Login attempts to get the authentication material for GCR. The caller can
 // ensure that the passed image is a valid GCR image using ValidHost().
 func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, error) {
+	auth, _, err := c.LoginWithExpiry(ctx, autoLogin, image, ref)
+	return auth, err
+}
+
+// LoginWithExpiry is like Login, and also returns the expiry time of the
+// authentication material.
+func (c *Client) LoginWithExpiry(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, time.Time, error) {
 	if autoLogin {
 		ctrl.LoggerFrom(ctx).Info("logging in to GCP GCR for " + image)
-		authConfig, err := c.getLoginAuth(ctx)
+		authConfig, expiresAt, err := c.getLoginAuthWithExpiry(ctx)
 		if err != nil {
 			ctrl.LoggerFrom(ctx).Info("error logging into GCP " + err.Error())
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
 		auth := authn.FromConfig(authConfig)
-		return auth, nil
+		return auth, expiresAt, nil
 	}
 	ctrl.LoggerFrom(ctx).Info("GCR authentication is not enabled. To enable, set the controller flag --gcp-autologin-for-gcr")
-	return nil, fmt.Errorf("GCR authentication failed: %w", oci.ErrUnconfiguredProvider)
+	return nil, time.Time{}, fmt.Errorf("GCR authentication failed: %w", oci.ErrUnconfiguredProvider)
 }
@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package login
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// DefaultTokenRefreshWindow is the default duration before the expiry of
+// cached tokens after which they are refreshed.
+const DefaultTokenRefreshWindow = 5 * time.Minute
+
+// tokenCacheEntry is an authenticator cached until its refresh time.
+type tokenCacheEntry struct {
+	auth      authn.Authenticator
+	refreshAt time.Time
+}
+
+// tokenCache caches the authenticators returned by the registry providers,
+// keyed by provider and registry host.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenCacheEntry
+	// refreshWindow is the duration before the expiry of entries after
+	// which they are considered stale, clamped to half of their lifetime.
+	refreshWindow time.Duration
+	// now returns the current time, overridden in tests.
+	now func() time.Time
+}
+
+func newTokenCache(refreshWindow time.Duration) *tokenCache {
+	return &tokenCache{
+		entries:       make(map[string]tokenCacheEntry),
+		refreshWindow: refreshWindow,
+		now:           time.Now,
+	}
+}
+
+// get returns the authenticator cached for the given key, unless it
+// expires within the refresh window.
+func (c *tokenCache) get(key string) (authn.Authenticator, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.refreshAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.auth, true
+}
+
+// set caches the authenticator for the given key until the refresh window
+// before the given expiry time. Authenticators with an unknown expiry time
+// or already expired are not cached.
+func (c *tokenCache) set(key string, auth authn.Authenticator, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lifetime := expiresAt.Sub(c.now())
+	if lifetime <= 0 {
+		return
+	}
+	window := c.refreshWindow
+	if window > lifetime/2 {
+		window = lifetime / 2
+	}
+	c.entries[key] = tokenCacheEntry{auth: auth, refreshAt: expiresAt.Add(-window)}
+}
+
+// delete removes the authenticator cached for the given key.
+func (c *tokenCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
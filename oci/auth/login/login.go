@@ -18,14 +18,21 @@ package login
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/fluxcd/pkg/oci"
 	"github.com/fluxcd/pkg/oci/auth/aws"
 	"github.com/fluxcd/pkg/oci/auth/azure"
 	"github.com/fluxcd/pkg/oci/auth/gcp"
+	"github.com/fluxcd/pkg/oci/auth/oidc"
 )
 
 // ImageRegistryProvider analyzes the provided image and returns the identified
@@ -55,23 +62,63 @@ type ProviderOptions struct {
 	// AzureAutoLogin enables automatic attempt to get credentials for images in
 	// ACR.
 	AzureAutoLogin bool
+	// OIDCAutoLogin enables automatic attempt to get credentials for images
+	// in registries not handled by the other providers, by exchanging the
+	// workload identity token at the token endpoint of the OIDC client.
+	OIDCAutoLogin bool
 }
 
-// Manager is a login manager for various registry providers.
+// Manager is a login manager for various registry providers. The tokens
+// returned by the providers are cached per registry until they expire.
 type Manager struct {
 	ecr *aws.Client
 	gcr *gcp.Client
 	acr *azure.Client
+	// oidc is the OIDC client, nil unless configured with WithOIDCClient
+	// since the token endpoint is specific to the registry.
+	oidc *oidc.Client
+
+	cache        *tokenCache
+	cacheCounter *prometheus.CounterVec
+	// logins deduplicates the concurrent provider logins to a registry.
+	logins singleflight.Group
 }
 
 // NewManager initializes a Manager with default registry clients
 // configurations.
 func NewManager() *Manager {
 	return &Manager{
-		ecr: aws.NewClient(),
-		gcr: gcp.NewClient(),
-		acr: azure.NewClient(),
+		ecr:   aws.NewClient(),
+		gcr:   gcp.NewClient(),
+		acr:   azure.NewClient(),
+		cache: newTokenCache(DefaultTokenRefreshWindow),
+		cacheCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotk_oci_auth_token_cache_requests_total",
+				Help: "The number of registry provider token lookups in the cache of the OCI login manager.",
+			},
+			[]string{"provider", "result"},
+		),
+	}
+}
+
+// WithTokenRefreshWindow sets the duration before the expiry of cached
+// tokens after which they are refreshed, DefaultTokenRefreshWindow by
+// default. The window is clamped to half of the lifetime of each token, so
+// that short-lived tokens are still cached, and negative windows are
+// ignored.
+func (m *Manager) WithTokenRefreshWindow(d time.Duration) *Manager {
+	if d < 0 {
+		d = 0
 	}
+	m.cache.refreshWindow = d
+	return m
+}
+
+// Collectors returns the Prometheus collectors of the token cache metrics,
+// which can be used to register them in a metrics registry.
+func (m *Manager) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.cacheCounter}
 }
 
 // WithECRClient allows overriding the default ECR client.
@@ -92,16 +139,139 @@ func (m *Manager) WithACRClient(c *azure.Client) *Manager {
 	return m
 }
 
+// WithOIDCClient sets the OIDC client used to log into the registries
+// accepting OIDC federated tokens.
+func (m *Manager) WithOIDCClient(c *oidc.Client) *Manager {
+	m.oidc = c
+	return m
+}
+
+// provider returns the registry provider of the given image, or the OIDC
+// provider for generic registries if it is enabled and handles the registry.
+func (m *Manager) provider(image string, ref name.Reference, opts ProviderOptions) oci.Provider {
+	provider := ImageRegistryProvider(image, ref)
+	if provider == oci.ProviderGeneric && opts.OIDCAutoLogin && m.oidc != nil &&
+		m.oidc.ValidHost(ref.Context().RegistryStr()) {
+		return oci.ProviderOIDC
+	}
+	return provider
+}
+
 // Login performs authentication against a registry and returns the
 // authentication material. For generic registry provider, it is no-op.
+// The authentication material is returned from the cache if it does not
+// expire within the refresh window, and the concurrent logins to the same
+// registry share a single provider login.
 func (m *Manager) Login(ctx context.Context, image string, ref name.Reference, opts ProviderOptions) (authn.Authenticator, error) {
-	switch ImageRegistryProvider(image, ref) {
+	provider := m.provider(image, ref, opts)
+	if provider == oci.ProviderGeneric {
+		return nil, nil
+	}
+
+	// Cached tokens are only returned if the login to the provider is enabled.
+	key := tokenCacheKey(provider, ref)
+	if autoLoginEnabled(provider, opts) {
+		if auth, ok := m.cache.get(key); ok {
+			m.cacheCounter.WithLabelValues(providerName(provider), "hit").Inc()
+			return auth, nil
+		}
+		m.cacheCounter.WithLabelValues(providerName(provider), "miss").Inc()
+	}
+
+	if !autoLoginEnabled(provider, opts) {
+		auth, _, err := m.loginWithExpiry(ctx, provider, image, ref, opts)
+		return auth, err
+	}
+
+	// The provider login is shared with the concurrent callers, and done with
+	// the context of the first one.
+	v, err, _ := m.logins.Do(key, func() (interface{}, error) {
+		auth, expiresAt, err := m.loginWithExpiry(ctx, provider, image, ref, opts)
+		if err != nil {
+			return nil, err
+		}
+		m.cache.set(key, auth, expiresAt)
+		return auth, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	auth, _ := v.(authn.Authenticator)
+	return auth, nil
+}
+
+// loginWithExpiry logs into the given provider and returns the
+// authentication material with its expiry time.
+func (m *Manager) loginWithExpiry(ctx context.Context, provider oci.Provider, image string, ref name.Reference, opts ProviderOptions) (authn.Authenticator, time.Time, error) {
+	switch provider {
+	case oci.ProviderAWS:
+		return m.ecr.LoginWithExpiry(ctx, opts.AwsAutoLogin, image)
+	case oci.ProviderGCP:
+		return m.gcr.LoginWithExpiry(ctx, opts.GcpAutoLogin, image, ref)
+	case oci.ProviderAzure:
+		return m.acr.LoginWithExpiry(ctx, opts.AzureAutoLogin, image, ref)
+	case oci.ProviderOIDC:
+		return m.oidc.LoginWithExpiry(ctx, opts.OIDCAutoLogin, image, ref)
+	}
+	return nil, time.Time{}, nil
+}
+
+// Invalidate removes the cached authentication material of the registry
+// of the given reference, for example after the registry rejected it.
+func (m *Manager) Invalidate(image string, ref name.Reference) {
+	provider := ImageRegistryProvider(image, ref)
+	if provider == oci.ProviderGeneric {
+		// Tokens are only cached for generic registries by the OIDC provider.
+		provider = oci.ProviderOIDC
+	}
+	m.cache.delete(tokenCacheKey(provider, ref))
+}
+
+// InvalidateIfUnauthorized invalidates the cached authentication material
+// of the registry of the given reference if the given error is an HTTP 401
+// error returned by the registry, and reports whether it did.
+func (m *Manager) InvalidateIfUnauthorized(err error, image string, ref name.Reference) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) || terr.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	m.Invalidate(image, ref)
+	return true
+}
+
+// autoLoginEnabled returns true if the login to the given provider is
+// enabled in the options.
+func autoLoginEnabled(provider oci.Provider, opts ProviderOptions) bool {
+	switch provider {
+	case oci.ProviderAWS:
+		return opts.AwsAutoLogin
+	case oci.ProviderGCP:
+		return opts.GcpAutoLogin
+	case oci.ProviderAzure:
+		return opts.AzureAutoLogin
+	case oci.ProviderOIDC:
+		return opts.OIDCAutoLogin
+	}
+	return false
+}
+
+// tokenCacheKey returns the key of the token cache for the given provider
+// and reference. The registry host identifies the ECR account and region.
+func tokenCacheKey(provider oci.Provider, ref name.Reference) string {
+	return providerName(provider) + "/" + ref.Context().RegistryStr()
+}
+
+// providerName returns the name of the given provider, used in metrics.
+func providerName(provider oci.Provider) string {
+	switch provider {
 	case oci.ProviderAWS:
-		return m.ecr.Login(ctx, opts.AwsAutoLogin, image)
+		return "aws"
 	case oci.ProviderGCP:
-		return m.gcr.Login(ctx, opts.GcpAutoLogin, image, ref)
+		return "gcp"
 	case oci.ProviderAzure:
-		return m.acr.Login(ctx, opts.AzureAutoLogin, image, ref)
+		return "azure"
+	case oci.ProviderOIDC:
+		return "oidc"
 	}
-	return nil, nil
+	return "generic"
 }
@@ -18,18 +18,29 @@ package login
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/fluxcd/pkg/oci"
 	"github.com/fluxcd/pkg/oci/auth/aws"
 	"github.com/fluxcd/pkg/oci/auth/azure"
 	"github.com/fluxcd/pkg/oci/auth/gcp"
+	"github.com/fluxcd/pkg/oci/auth/oidc"
 )
 
 func TestImageRegistryProvider(t *testing.T) {
@@ -55,6 +66,42 @@ func TestImageRegistryProvider(t *testing.T) {
 	}
 }
 
+func TestManager_provider(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		image string
+		want  oci.Provider
+	}{
+		{"configured host", []string{"ghcr.io"}, "ghcr.io/foo/bar:v1", oci.ProviderOIDC},
+		{"other host", []string{"ghcr.io"}, "attacker.example.com/foo/bar:v1", oci.ProviderGeneric},
+		{"no hosts", nil, "ghcr.io/foo/bar:v1", oci.ProviderGeneric},
+		{"cloud provider", []string{"gcr.io"}, "gcr.io/foo/bar:v1", oci.ProviderGCP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ref, err := name.ParseReference(tt.image)
+			g.Expect(err).ToNot(HaveOccurred())
+			mgr := NewManager().WithOIDCClient(newTestOIDCClient(t, "http://localhost").WithHosts(tt.hosts...))
+			g.Expect(mgr.provider(tt.image, ref, ProviderOptions{OIDCAutoLogin: true})).To(Equal(tt.want))
+		})
+	}
+}
+
+// newTestOIDCClient returns an OIDC client exchanging a test service
+// account token at the given token endpoint.
+func newTestOIDCClient(t *testing.T, tokenURL string) *oidc.Client {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("sa-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return oidc.NewClient().WithTokenURL(tokenURL).WithTokenFile(tokenFile)
+}
+
 func TestLogin(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -107,6 +154,23 @@ func TestLogin(t *testing.T) {
 			// detail in the azure package.
 			wantErr: true,
 		},
+		{
+			name:         "oidc",
+			responseBody: `{"access_token": "some-token", "expires_in": 300}`,
+			providerOpts: ProviderOptions{OIDCAutoLogin: true},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				mgr.WithOIDCClient(newTestOIDCClient(t, serverURL).WithHosts("ghcr.io"))
+
+				*image = "ghcr.io/foo/bar:v1"
+			},
+		},
+		{
+			name:         "oidc without client",
+			providerOpts: ProviderOptions{OIDCAutoLogin: true},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				*image = "ghcr.io/foo/bar:v1"
+			},
+		},
 		{
 			name:         "generic",
 			providerOpts: ProviderOptions{},
@@ -145,3 +209,160 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestLogin_TokenCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		responseBody string
+		providerOpts ProviderOptions
+		beforeFunc   func(t *testing.T, serverURL string, mgr *Manager) string
+	}{
+		{
+			name:         "ecr",
+			responseBody: fmt.Sprintf(`{"authorizationData": [{"authorizationToken": "c29tZS1rZXk6c29tZS1zZWNyZXQ=", "expiresAt": %d}]}`, time.Now().Add(12*time.Hour).Unix()),
+			providerOpts: ProviderOptions{AwsAutoLogin: true},
+			beforeFunc: func(t *testing.T, serverURL string, mgr *Manager) string {
+				ecrClient := aws.NewClient()
+				ecrClient.Config = ecrClient.WithEndpoint(serverURL).
+					WithCredentials(credentials.NewStaticCredentials("x", "y", "z"))
+				mgr.WithECRClient(ecrClient)
+				return "012345678901.dkr.ecr.us-east-1.amazonaws.com/foo:v1"
+			},
+		},
+		{
+			name:         "gcr",
+			responseBody: `{"access_token": "some-token","expires_in": 43200, "token_type": "foo"}`,
+			providerOpts: ProviderOptions{GcpAutoLogin: true},
+			beforeFunc: func(t *testing.T, serverURL string, mgr *Manager) string {
+				mgr.WithGCRClient(gcp.NewClient().WithTokenURL(serverURL))
+				return "gcr.io/foo/bar:v1"
+			},
+		},
+		{
+			name:         "oidc",
+			responseBody: `{"access_token": "some-token", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "expires_in": 43200}`,
+			providerOpts: ProviderOptions{OIDCAutoLogin: true},
+			beforeFunc: func(t *testing.T, serverURL string, mgr *Manager) string {
+				mgr.WithOIDCClient(newTestOIDCClient(t, serverURL).WithHosts("ghcr.io"))
+				return "ghcr.io/foo/bar:v1"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var requests int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(tt.responseBody))
+			}))
+			t.Cleanup(srv.Close)
+
+			mgr := NewManager().WithTokenRefreshWindow(time.Hour)
+			image := tt.beforeFunc(t, srv.URL, mgr)
+			ref, err := name.ParseReference(image)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			login := func() {
+				t.Helper()
+				auth, err := mgr.Login(context.TODO(), image, ref, tt.providerOpts)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(auth).ToNot(BeNil())
+			}
+
+			// The token is cached.
+			login()
+			login()
+			g.Expect(requests).To(Equal(1))
+			g.Expect(testutil.ToFloat64(mgr.cacheCounter.WithLabelValues(providerName(mgr.provider(image, ref, tt.providerOpts)), "hit"))).To(Equal(float64(1)))
+
+			// The token is refreshed within the refresh window.
+			mgr.cache.now = func() time.Time { return time.Now().Add(11*time.Hour + time.Minute) }
+			login()
+			g.Expect(requests).To(Equal(2))
+			mgr.cache.now = time.Now
+
+			// The token is refreshed after an unauthorized error.
+			g.Expect(mgr.InvalidateIfUnauthorized(errors.New("not found"), image, ref)).To(BeFalse())
+			login()
+			g.Expect(requests).To(Equal(2))
+			g.Expect(mgr.InvalidateIfUnauthorized(&transport.Error{StatusCode: http.StatusUnauthorized}, image, ref)).To(BeTrue())
+			login()
+			g.Expect(requests).To(Equal(3))
+
+			// Cached tokens are not returned if the provider login is disabled.
+			auth, err := mgr.Login(context.TODO(), image, ref, ProviderOptions{})
+			g.Expect(err != nil || auth == nil).To(BeTrue())
+		})
+	}
+}
+
+func TestLogin_Concurrent(t *testing.T) {
+	g := NewWithT(t)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token": "some-token","expires_in": 43200, "token_type": "foo"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	mgr := NewManager().WithGCRClient(gcp.NewClient().WithTokenURL(srv.URL))
+	image := "gcr.io/foo/bar:v1"
+	ref, err := name.ParseReference(image)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The concurrent logins share a single token request.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mgr.Login(context.TODO(), image, ref, ProviderOptions{GcpAutoLogin: true})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+}
+
+func TestTokenCache_RefreshWindow(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		refreshWindow time.Duration
+		lifetime      time.Duration
+		elapsed       time.Duration
+		wantCached    bool
+	}{
+		{"before the refresh window", time.Hour, 12 * time.Hour, 10 * time.Hour, true},
+		{"within the refresh window", time.Hour, 12 * time.Hour, 11*time.Hour + time.Minute, false},
+		{"short-lived token", time.Hour, 10 * time.Minute, 4 * time.Minute, true},
+		{"short-lived token after half its lifetime", time.Hour, 10 * time.Minute, 5 * time.Minute, false},
+		{"expired token", time.Hour, -time.Minute, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cache := newTokenCache(tt.refreshWindow)
+			cache.now = func() time.Time { return now }
+			cache.set("key", authn.Anonymous, now.Add(tt.lifetime))
+
+			cache.now = func() time.Time { return now.Add(tt.elapsed) }
+			_, ok := cache.get("key")
+			g.Expect(ok).To(Equal(tt.wantCached))
+		})
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+const (
+	// DefaultTokenFile is the default path of the projected service account
+	// token exchanged for registry tokens.
+	DefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// DefaultUsername is the default username used with the exchanged
+	// registry tokens.
+	DefaultUsername = "oauth2accesstoken"
+
+	// grantTypeTokenExchange is the RFC 8693 token exchange grant type.
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// tokenTypeJWT is the RFC 8693 token type of the service account token.
+	tokenTypeJWT = "urn:ietf:params:oauth:token-type:jwt"
+
+	// tokenTypeAccessToken is the RFC 8693 token type of the requested
+	// registry token.
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+	// defaultTimeout is the timeout of the requests to the token endpoint
+	// made with the default HTTP client.
+	defaultTimeout = 30 * time.Second
+)
+
+// tokenExchangeResponse is the RFC 8693 token exchange response.
+type tokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+}
+
+// tokenExchangeError is the OAuth 2.0 error response of the token endpoint.
+type tokenExchangeError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Client is an OIDC workload identity client which can exchange a projected
+// service account token for a registry token using an RFC 8693 token
+// exchange, for registries accepting OIDC federated tokens such as Harbor,
+// Quay or GitHub Container Registry.
+type Client struct {
+	tokenURL   string
+	tokenFile  string
+	audience   string
+	scope      string
+	username   string
+	hosts      []string
+	httpClient *http.Client
+}
+
+// NewClient creates a new OIDC client with default configurations. The
+// token endpoint and the registry hosts must be set with WithTokenURL and
+// WithHosts before logging in.
+func NewClient() *Client {
+	return &Client{
+		tokenFile:  DefaultTokenFile,
+		username:   DefaultUsername,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// WithTokenURL sets the URL of the token endpoint used for the token
+// exchange.
+func (c *Client) WithTokenURL(url string) *Client {
+	c.tokenURL = url
+	return c
+}
+
+// WithTokenFile sets the path of the projected service account token.
+func (c *Client) WithTokenFile(path string) *Client {
+	c.tokenFile = path
+	return c
+}
+
+// WithAudience sets the audience of the requested registry token.
+func (c *Client) WithAudience(audience string) *Client {
+	c.audience = audience
+	return c
+}
+
+// WithScope sets the scope of the requested registry token.
+func (c *Client) WithScope(scope string) *Client {
+	c.scope = scope
+	return c
+}
+
+// WithUsername sets the username used with the registry token.
+func (c *Client) WithUsername(username string) *Client {
+	c.username = username
+	return c
+}
+
+// WithHosts sets the registry hosts the client logs into. The client does
+// not log into any registry if no hosts are set, as the exchanged tokens
+// must only be sent to the registries trusting the token endpoint.
+func (c *Client) WithHosts(hosts ...string) *Client {
+	c.hosts = hosts
+	return c
+}
+
+// WithHTTPClient sets the HTTP client used to call the token endpoint.
+func (c *Client) WithHTTPClient(client *http.Client) *Client {
+	c.httpClient = client
+	return c
+}
+
+// ValidHost returns if the client logs into the given registry host.
+func (c *Client) ValidHost(host string) bool {
+	for _, h := range c.hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLoginAuth exchanges the projected service account token for a registry
+// token at the token endpoint, and returns the authentication material with
+// the expiry time of the token.
+func (c *Client) getLoginAuth(ctx context.Context) (authn.AuthConfig, time.Time, error) {
+	var authConfig authn.AuthConfig
+	var expiresAt time.Time
+
+	if c.tokenURL == "" {
+		return authConfig, expiresAt, fmt.Errorf("no OIDC token endpoint configured")
+	}
+
+	// The projected token is read on every exchange, as it is rotated by
+	// the kubelet.
+	subjectToken, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return authConfig, expiresAt, fmt.Errorf("reading service account token failed: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":           {grantTypeTokenExchange},
+		"subject_token":        {strings.TrimSpace(string(subjectToken))},
+		"subject_token_type":   {tokenTypeJWT},
+		"requested_token_type": {tokenTypeAccessToken},
+	}
+	if c.audience != "" {
+		form.Set("audience", c.audience)
+	}
+	if c.scope != "" {
+		form.Set("scope", c.scope)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return authConfig, expiresAt, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	requestTime := time.Now()
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return authConfig, expiresAt, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		var exchangeErr tokenExchangeError
+		if err := json.NewDecoder(response.Body).Decode(&exchangeErr); err == nil && exchangeErr.Error != "" {
+			return authConfig, expiresAt, fmt.Errorf("unexpected status from token endpoint: %s: %s: %s",
+				response.Status, exchangeErr.Error, exchangeErr.ErrorDescription)
+		}
+		return authConfig, expiresAt, fmt.Errorf("unexpected status from token endpoint: %s", response.Status)
+	}
+
+	var token tokenExchangeResponse
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return authConfig, expiresAt, err
+	}
+	if token.AccessToken == "" {
+		return authConfig, expiresAt, fmt.Errorf("no access token in token endpoint response")
+	}
+
+	authConfig = authn.AuthConfig{
+		Username: c.username,
+		Password: token.AccessToken,
+	}
+	if token.ExpiresIn > 0 {
+		expiresAt = requestTime.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return authConfig, expiresAt, nil
+}
+
+// Login attempts to get the authentication material for the registry of the
+// given image by exchanging the service account token. It fails if the
+// registry of the image is not one of the hosts of the client, which the
+// caller can check using ValidHost().
+func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, error) {
+	auth, _, err := c.LoginWithExpiry(ctx, autoLogin, image, ref)
+	return auth, err
+}
+
+// LoginWithExpiry is like Login, and also returns the expiry time of the
+// authentication material.
+func (c *Client) LoginWithExpiry(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, time.Time, error) {
+	if autoLogin {
+		if !c.ValidHost(ref.Context().RegistryStr()) {
+			return nil, time.Time{}, fmt.Errorf("OIDC login to '%s' is not allowed: registry is not one of the configured hosts", ref.Context().RegistryStr())
+		}
+		ctrl.LoggerFrom(ctx).Info("logging in to " + ref.Context().RegistryStr() + " with OIDC token exchange for " + image)
+		authConfig, expiresAt, err := c.getLoginAuth(ctx)
+		if err != nil {
+			ctrl.LoggerFrom(ctx).Info("error logging in with OIDC token exchange " + err.Error())
+			return nil, time.Time{}, err
+		}
+
+		auth := authn.FromConfig(authConfig)
+		return auth, expiresAt, nil
+	}
+	ctrl.LoggerFrom(ctx).Info("OIDC authentication is not enabled. To enable, set the controller flag --oidc-autologin")
+	return nil, time.Time{}, fmt.Errorf("OIDC authentication failed: %w", oci.ErrUnconfiguredProvider)
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+const testImage = "registry.example.com/foo/bar:v1"
+
+// newTokenServer returns a local RFC 8693 token endpoint which exchanges the
+// given subject token for the given response body.
+func newTokenServer(t *testing.T, subjectToken string, statusCode int, responseBody string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodPost ||
+			r.PostForm.Get("grant_type") != grantTypeTokenExchange ||
+			r.PostForm.Get("subject_token_type") != tokenTypeJWT ||
+			r.PostForm.Get("audience") != "registry.example.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_request"}`))
+			return
+		}
+		if r.PostForm.Get("subject_token") != subjectToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_grant", "error_description": "invalid subject token"}`))
+			return
+		}
+		w.WriteHeader(statusCode)
+		w.Write([]byte(responseBody))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetLoginAuth(t *testing.T) {
+	tests := []struct {
+		name           string
+		subjectToken   string
+		statusCode     int
+		responseBody   string
+		wantErr        string
+		wantAuthConfig authn.AuthConfig
+		wantExpiry     bool
+	}{
+		{
+			name:         "success",
+			subjectToken: "sa-token",
+			statusCode:   http.StatusOK,
+			responseBody: `{"access_token": "registry-token", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "token_type": "Bearer", "expires_in": 300}`,
+			wantAuthConfig: authn.AuthConfig{
+				Username: DefaultUsername,
+				Password: "registry-token",
+			},
+			wantExpiry: true,
+		},
+		{
+			name:         "no expiry",
+			subjectToken: "sa-token",
+			statusCode:   http.StatusOK,
+			responseBody: `{"access_token": "registry-token"}`,
+			wantAuthConfig: authn.AuthConfig{
+				Username: DefaultUsername,
+				Password: "registry-token",
+			},
+		},
+		{
+			name:         "invalid subject token",
+			subjectToken: "other-token",
+			wantErr:      "invalid_grant: invalid subject token",
+		},
+		{
+			name:         "server error",
+			subjectToken: "sa-token",
+			statusCode:   http.StatusInternalServerError,
+			wantErr:      "unexpected status from token endpoint",
+		},
+		{
+			name:         "no access token",
+			subjectToken: "sa-token",
+			statusCode:   http.StatusOK,
+			responseBody: `{"token_type": "Bearer"}`,
+			wantErr:      "no access token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			g.Expect(os.WriteFile(tokenFile, []byte(tt.subjectToken+"\n"), 0o600)).To(Succeed())
+			srv := newTokenServer(t, "sa-token", tt.statusCode, tt.responseBody)
+
+			c := NewClient().
+				WithTokenURL(srv.URL).
+				WithTokenFile(tokenFile).
+				WithAudience("registry.example.com")
+			authConfig, expiresAt, err := c.getLoginAuth(context.TODO())
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(authConfig).To(Equal(tt.wantAuthConfig))
+			if tt.wantExpiry {
+				g.Expect(expiresAt).To(BeTemporally("~", time.Now().Add(300*time.Second), 5*time.Second))
+			} else {
+				g.Expect(expiresAt.IsZero()).To(BeTrue())
+			}
+		})
+	}
+}
+
+func TestGetLoginAuth_Misconfigured(t *testing.T) {
+	g := NewWithT(t)
+
+	_, _, err := NewClient().getLoginAuth(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("no OIDC token endpoint")))
+
+	_, _, err = NewClient().WithTokenURL("http://localhost").
+		WithTokenFile(filepath.Join(t.TempDir(), "missing")).getLoginAuth(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("reading service account token failed")))
+}
+
+func TestValidHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		host  string
+		want  bool
+	}{
+		{"no hosts", nil, "ghcr.io", false},
+		{"matching host", []string{"ghcr.io", "quay.io"}, "quay.io", true},
+		{"case insensitive", []string{"GHCR.io"}, "ghcr.io", true},
+		{"other host", []string{"ghcr.io"}, "harbor.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(NewClient().WithHosts(tt.hosts...).ValidHost(tt.host)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name      string
+		autoLogin bool
+		hosts     []string
+		wantErr   string
+	}{
+		{
+			name:      "with auto login",
+			autoLogin: true,
+			hosts:     []string{"registry.example.com"},
+		},
+		{
+			name:      "without auto login",
+			autoLogin: false,
+			hosts:     []string{"registry.example.com"},
+			wantErr:   oci.ErrUnconfiguredProvider.Error(),
+		},
+		{
+			name:      "other host",
+			autoLogin: true,
+			hosts:     []string{"ghcr.io"},
+			wantErr:   "is not one of the configured hosts",
+		},
+		{
+			name:      "no hosts",
+			autoLogin: true,
+			wantErr:   "is not one of the configured hosts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			g.Expect(os.WriteFile(tokenFile, []byte("sa-token"), 0o600)).To(Succeed())
+			srv := newTokenServer(t, "sa-token", http.StatusOK, `{"access_token": "registry-token", "expires_in": 300}`)
+
+			ref, err := name.ParseReference(testImage)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			c := NewClient().
+				WithTokenURL(srv.URL).
+				WithTokenFile(tokenFile).
+				WithAudience("registry.example.com").
+				WithUsername("robot").
+				WithHosts(tt.hosts...)
+			auth, err := c.Login(context.TODO(), tt.autoLogin, testImage, ref)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			authConfig, err := auth.Authorization()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(authConfig.Username).To(Equal("robot"))
+			g.Expect(authConfig.Password).To(Equal("registry-token"))
+		})
+	}
+}
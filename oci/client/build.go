@@ -30,9 +30,30 @@ import (
 	"github.com/fluxcd/pkg/sourceignore"
 )
 
+// BuildOptions holds the options for archiving directories.
+type BuildOptions struct {
+	// IgnorePaths are the paths excluded from the archive, in .gitignore format.
+	IgnorePaths []string
+	// Reproducible normalizes the file headers, so that archiving the same
+	// content always produces the same tarball: the modification times are
+	// set to ModTime and the permissions to 0644, or 0755 for directories
+	// and executable files.
+	Reproducible bool
+	// ModTime is the modification time of the archived files in reproducible
+	// mode, usually the time of the source revision.
+	ModTime time.Time
+}
+
 // Build archives the given directory as a tarball to the given local path.
 // While archiving, any environment specific data (for example, the user and group name) is stripped from file headers.
-func (c *Client) Build(artifactPath, sourceDir string, ignorePaths []string) (err error) {
+func (c *Client) Build(artifactPath, sourceDir string, ignorePaths []string) error {
+	return c.BuildWithOptions(artifactPath, sourceDir, BuildOptions{IgnorePaths: ignorePaths})
+}
+
+// BuildWithOptions archives the given directory as a tarball to the given local path,
+// see BuildOptions.
+func (c *Client) BuildWithOptions(artifactPath, sourceDir string, opts BuildOptions) (err error) {
+	ignorePaths := opts.IgnorePaths
 	if f, err := os.Stat(sourceDir); os.IsNotExist(err) || !f.IsDir() {
 		return fmt.Errorf("invalid source dir path: %s", sourceDir)
 	}
@@ -63,6 +84,8 @@ func (c *Client) Build(artifactPath, sourceDir string, ignorePaths []string) (er
 	mw := io.MultiWriter(tf, sz)
 
 	gw := gzip.NewWriter(mw)
+	// The gzip header holds no file name nor modification time.
+	gw.Header = gzip.Header{OS: gw.Header.OS}
 	tw := tar.NewWriter(gw)
 	if err := filepath.Walk(sourceDir, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
@@ -102,6 +125,11 @@ func (c *Client) Build(artifactPath, sourceDir string, ignorePaths []string) (er
 		header.ModTime = time.Time{}
 		header.AccessTime = time.Time{}
 		header.ChangeTime = time.Time{}
+		if opts.Reproducible {
+			header.Name = filepath.ToSlash(header.Name)
+			header.ModTime = opts.ModTime.UTC().Truncate(time.Second)
+			header.Mode = reproducibleMode(fi.Mode())
+		}
 
 		if err := tw.WriteHeader(header); err != nil {
 			return err
@@ -147,6 +175,14 @@ func (c *Client) Build(artifactPath, sourceDir string, ignorePaths []string) (er
 	return fs.RenameWithFallback(tmpName, artifactPath)
 }
 
+// reproducibleMode returns the normalized permissions of files with the given mode.
+func reproducibleMode(mode os.FileMode) int64 {
+	if mode.IsDir() || mode&0o111 != 0 {
+		return 0o755
+	}
+	return 0o644
+}
+
 type writeCounter struct {
 	written int64
 }
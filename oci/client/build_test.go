@@ -17,11 +17,15 @@ limitations under the License.
 package client
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 
@@ -92,6 +96,51 @@ func TestBuild(t *testing.T) {
 	}
 }
 
+func TestBuildWithOptions_Reproducible(t *testing.T) {
+	g := NewWithT(t)
+	c := NewLocalClient()
+	modTime := time.Date(2022, 9, 21, 10, 0, 0, 0, time.UTC)
+
+	// Copies of the same content with different modes and times.
+	build := func(mode os.FileMode, mtime time.Time) []byte {
+		srcDir := t.TempDir()
+		g.Expect(os.MkdirAll(filepath.Join(srcDir, "dir"), 0o700)).To(Succeed())
+		for _, name := range []string{"a.yaml", "dir/b.yaml"} {
+			path := filepath.Join(srcDir, name)
+			g.Expect(os.WriteFile(path, []byte(name), mode)).To(Succeed())
+			g.Expect(os.Chtimes(path, mtime, mtime)).To(Succeed())
+		}
+
+		artifactPath := filepath.Join(t.TempDir(), "files.tar.gz")
+		err := c.BuildWithOptions(artifactPath, srcDir, BuildOptions{Reproducible: true, ModTime: modTime})
+		g.Expect(err).ToNot(HaveOccurred())
+		b, err := os.ReadFile(artifactPath)
+		g.Expect(err).ToNot(HaveOccurred())
+		return b
+	}
+
+	first := build(0o600, time.Now())
+	second := build(0o664, time.Now().Add(-time.Hour))
+	g.Expect(first).To(Equal(second))
+
+	gr, err := gzip.NewReader(bytes.NewReader(first))
+	g.Expect(err).ToNot(HaveOccurred())
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(header.ModTime).To(BeTemporally("==", modTime))
+		if header.Typeflag == tar.TypeDir {
+			g.Expect(header.Mode).To(BeEquivalentTo(0o755))
+		} else {
+			g.Expect(header.Mode).To(BeEquivalentTo(0o644))
+		}
+	}
+}
+
 func checkPathExists(t *testing.T, dir, testDir string, paths []string) {
 	g := NewWithT(t)
 
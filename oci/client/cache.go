@@ -0,0 +1,231 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/fluxcd/pkg/lockedfile"
+)
+
+const (
+	cacheBlobsDir   = "blobs"
+	cacheLockFile   = ".lock"
+	cacheTempPrefix = ".tmp-"
+)
+
+var sha256HexRegexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// Cache is a content-addressed on-disk cache of the manifests and layers
+// of pulled artifacts, keyed by digest. The cache directory can be shared
+// by multiple processes, as the writes and evictions are serialized with a
+// lock file. When the size of the cache exceeds its maximum size, the least
+// recently used blobs are evicted.
+type Cache struct {
+	dir     string
+	maxSize int64
+	mu      *lockedfile.Mutex
+}
+
+// NewCache returns a cache storing blobs in the given directory, created if
+// it does not exist. The size of the cache is unbounded if maxSize is zero.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, cacheBlobsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		mu:      lockedfile.MutexAt(filepath.Join(dir, cacheLockFile)),
+	}, nil
+}
+
+// SetCache configures the client to cache the manifests and layers of
+// pulled artifacts in the given cache.
+func (c *Client) SetCache(cache *Cache) {
+	c.cache = cache
+}
+
+// Open returns a reader for the cached blob with the given digest, or false
+// if the blob is not cached. Opening a blob marks it as recently used.
+func (c *Cache) Open(digest gcrv1.Hash) (io.ReadCloser, bool, error) {
+	path, err := c.path(digest)
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	// The modification time of blobs records their last use.
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return f, true, nil
+}
+
+// Get returns the content of the cached blob with the given digest, or
+// false if the blob is not cached.
+func (c *Cache) Get(digest gcrv1.Hash) ([]byte, bool, error) {
+	rc, ok, err := c.Open(digest)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Put stores the content of the reader as the blob with the given digest,
+// and evicts the least recently used blobs if the cache exceeds its
+// maximum size. It returns an error if the content does not match the
+// digest.
+func (c *Cache) Put(digest gcrv1.Hash, r io.Reader) error {
+	path, err := c.path(digest)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, cacheBlobsDir), cacheTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob '%s' to cache: %w", digest, err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != digest.Hex {
+		return fmt.Errorf("blob digest mismatch, expected '%s' got 'sha256:%s'", digest, actual)
+	}
+
+	unlock, err := c.mu.Lock()
+	if err != nil {
+		return fmt.Errorf("failed to lock cache: %w", err)
+	}
+	defer unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	return c.evict(path)
+}
+
+// Remove removes the cached blob with the given digest, for example after
+// its content did not match the digest.
+func (c *Cache) Remove(digest gcrv1.Hash) error {
+	path, err := c.path(digest)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.mu.Lock()
+	if err != nil {
+		return fmt.Errorf("failed to lock cache: %w", err)
+	}
+	defer unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove blob '%s' from cache: %w", digest, err)
+	}
+	return nil
+}
+
+// evict removes the least recently used blobs, other than the given one,
+// until the size of the cache is within its maximum size. It must be called
+// with the lock held.
+func (c *Cache) evict(keep string) error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var blobs []blob
+	var total int64
+	root := filepath.Join(c.dir, cacheBlobsDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), cacheTempPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, blob{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compute cache size: %w", err)
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].modTime.Before(blobs[j].modTime) })
+	for _, b := range blobs {
+		if total <= c.maxSize {
+			break
+		}
+		if b.path == keep {
+			continue
+		}
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to evict blob from cache: %w", err)
+		}
+		total -= b.size
+	}
+	return nil
+}
+
+// path returns the path of the blob with the given digest.
+func (c *Cache) path(digest gcrv1.Hash) (string, error) {
+	if digest.Algorithm != "sha256" || !sha256HexRegexp.MatchString(digest.Hex) {
+		return "", fmt.Errorf("unsupported digest '%s'", digest)
+	}
+	return filepath.Join(c.dir, cacheBlobsDir, digest.Algorithm, digest.Hex), nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/google/go-containerregistry/pkg/crane"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+)
+
+func Test_Cache(t *testing.T) {
+	g := NewWithT(t)
+
+	cache, err := NewCache(t.TempDir(), 10)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	blobs := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	digests := make([]gcrv1.Hash, len(blobs))
+	for i, blob := range blobs {
+		digests[i], _, err = gcrv1.SHA256(bytes.NewReader(blob))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	g.Expect(cache.Put(digests[0], bytes.NewReader(blobs[0]))).To(Succeed())
+	data, ok, err := cache.Get(digests[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(data).To(Equal(blobs[0]))
+
+	_, ok, err = cache.Get(digests[1])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+
+	err = cache.Put(digests[1], bytes.NewReader(blobs[0]))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("blob digest mismatch"))
+
+	// The least recently used blob is evicted when the size limit is exceeded.
+	path, err := cache.path(digests[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	old := time.Now().Add(-time.Hour)
+	g.Expect(os.Chtimes(path, old, old)).To(Succeed())
+	g.Expect(cache.Put(digests[1], bytes.NewReader(blobs[1]))).To(Succeed())
+	_, ok, err = cache.Get(digests[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	_, ok, err = cache.Get(digests[1])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+
+	_, _, err = cache.Get(gcrv1.Hash{Algorithm: "sha256", Hex: "../invalid"})
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(cache.Remove(digests[1])).To(Succeed())
+	_, ok, err = cache.Get(digests[1])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(cache.Remove(digests[1])).To(Succeed())
+}
+
+func Test_Pull_Cache(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	cache, err := NewCache(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+	c := NewLocalClient()
+	c.SetCache(cache)
+
+	var offline atomic.Bool
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	app := handlers.NewApp(ctx, config)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if offline.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		app.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	url := fmt.Sprintf("%s/%s:v0.0.1", strings.TrimPrefix(srv.URL, "http://"), "test-cache"+randStringRunes(5))
+	digest, err := c.Push(ctx, url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	meta, err := c.Pull(ctx, url, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(meta.Digest).To(Equal(digest))
+
+	// Cached blobs not matching their digest are removed from the cache and
+	// fetched again from the registry.
+	manifestDigest, err := gcrv1.NewHash(strings.Split(digest, "@")[1])
+	g.Expect(err).ToNot(HaveOccurred())
+	rawManifest, err := crane.Manifest(digest, c.options...)
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := gcrv1.ParseManifest(bytes.NewReader(rawManifest))
+	g.Expect(err).ToNot(HaveOccurred())
+	blobs := []gcrv1.Hash{manifestDigest, manifest.Layers[0].Digest}
+	for _, h := range blobs {
+		path, err := cache.path(h)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(os.WriteFile(path, []byte("tampered"), 0o644)).To(Succeed())
+	}
+	tmpDir := t.TempDir()
+	meta, err = c.Pull(ctx, digest, tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(meta.Digest).To(Equal(digest))
+	g.Expect(filepath.Join(tmpDir, "testdata/artifact/deployment.yaml")).To(BeAnExistingFile())
+	for _, h := range blobs {
+		data, ok, err := cache.Get(h)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+		actual, _, err := gcrv1.SHA256(bytes.NewReader(data))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(actual).To(Equal(h))
+	}
+
+	// Artifacts pulled by digest are extracted from the cache without
+	// contacting the registry.
+	offline.Store(true)
+	tmpDir = t.TempDir()
+	meta, err = c.Pull(ctx, digest, tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(meta.Digest).To(Equal(digest))
+	g.Expect(filepath.Join(tmpDir, "testdata/artifact/deployment.yaml")).To(BeAnExistingFile())
+
+	_, err = c.Pull(ctx, url, t.TempDir())
+	g.Expect(err).To(HaveOccurred())
+}
+
+func Test_PullIfChanged(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	url, digest := pushTestArtifact(t, c)
+
+	for _, known := range []string{digest.String(), digest.DigestStr()} {
+		meta, changed, err := c.PullIfChanged(ctx, url, t.TempDir(), known, PullOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(changed).To(BeFalse())
+		g.Expect(meta).To(BeNil())
+	}
+
+	tmpDir := t.TempDir()
+	meta, changed, err := c.PullIfChanged(ctx, url, tmpDir, "", PullOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(meta.Digest).To(Equal(digest.String()))
+	g.Expect(filepath.Join(tmpDir, "testdata/artifact/deployment.yaml")).To(BeAnExistingFile())
+}
@@ -0,0 +1,365 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+// chartFileName is the name of the metadata file of Helm charts.
+const chartFileName = "Chart.yaml"
+
+// ChartMetadata holds the metadata of a Helm chart, as defined in its
+// Chart.yaml file.
+type ChartMetadata struct {
+	APIVersion   string            `json:"apiVersion"`
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	KubeVersion  string            `json:"kubeVersion,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Type         string            `json:"type,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Home         string            `json:"home,omitempty"`
+	Sources      []string          `json:"sources,omitempty"`
+	Maintainers  []ChartMaintainer `json:"maintainers,omitempty"`
+	Icon         string            `json:"icon,omitempty"`
+	AppVersion   string            `json:"appVersion,omitempty"`
+	Deprecated   bool              `json:"deprecated,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Dependencies []ChartDependency `json:"dependencies,omitempty"`
+}
+
+// ChartMaintainer holds the details of a maintainer of a Helm chart.
+type ChartMaintainer struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// ChartDependency holds the details of a dependency of a Helm chart.
+type ChartDependency struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version,omitempty"`
+	Repository string   `json:"repository"`
+	Condition  string   `json:"condition,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Alias      string   `json:"alias,omitempty"`
+}
+
+// ChartPushOptions holds the options for pushing Helm charts.
+type ChartPushOptions struct {
+	// ProvenancePath is the path of the provenance file of the chart,
+	// pushed as a layer of the artifact if set.
+	ProvenancePath string
+}
+
+// ChartPullResult holds the Helm chart pulled from a registry.
+type ChartPullResult struct {
+	// Metadata is the metadata of the chart, read from the artifact config.
+	Metadata ChartMetadata
+	// Digest is the digest reference of the chart artifact.
+	Digest string
+	// ChartPath is the path of the packaged chart.
+	ChartPath string
+	// ProvenancePath is the path of the provenance file of the chart, if
+	// the artifact has one.
+	ProvenancePath string
+}
+
+// ChartTag returns the OCI tag of the given Helm chart version. OCI tags
+// do not allow '+', which is replaced by '_' as done by Helm.
+func ChartTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
+
+// ChartVersion returns the Helm chart version of the given OCI tag.
+func ChartVersion(tag string) string {
+	return strings.ReplaceAll(tag, "_", "+")
+}
+
+// PushChart uploads the packaged Helm chart at the given path to the given
+// OCI repository prefix, as done by 'helm push', and returns the digest
+// reference of the chart artifact. The chart is pushed to the repository
+// with its name under the prefix, tagged with its version, for example
+// 'ghcr.io/org/charts/podinfo:6.2.0' for the 'ghcr.io/org/charts' prefix.
+// The artifact config holds the metadata of the Chart.yaml file, which is
+// also added to the manifest annotations.
+func (c *Client) PushChart(ctx context.Context, url, chartPath string, opts ChartPushOptions) (string, error) {
+	chartData, err := os.ReadFile(chartPath)
+	if err != nil {
+		return "", err
+	}
+	rawMeta, meta, err := loadChartMetadata(chartData)
+	if err != nil {
+		return "", fmt.Errorf("invalid chart '%s': %w", chartPath, err)
+	}
+
+	ref, err := name.NewTag(fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(url, "/"), meta.Name, ChartTag(meta.Version)))
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	layers := []gcrv1.Layer{
+		static.NewLayer(rawMeta, oci.HelmChartConfigMediaType),
+		static.NewLayer(chartData, oci.HelmChartContentMediaType),
+	}
+	if opts.ProvenancePath != "" {
+		provData, err := os.ReadFile(opts.ProvenancePath)
+		if err != nil {
+			return "", err
+		}
+		layers = append(layers, static.NewLayer(provData, oci.HelmChartProvenanceMediaType))
+	}
+
+	manifest := gcrv1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Annotations:   chartAnnotations(meta),
+	}
+	options := crane.GetOptions(c.optionsWithContext(ctx)...).Remote
+	for i, l := range layers {
+		if err := remote.WriteLayer(ref.Context(), l, options...); err != nil {
+			return "", fmt.Errorf("uploading chart blob failed: %w", err)
+		}
+		desc, err := partial.Descriptor(l)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			manifest.Config = *desc
+			continue
+		}
+		manifest.Layers = append(manifest.Layers, *desc)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	digest, _, err := gcrv1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if err := remote.Put(ref, rawManifest{data: data, mediaType: types.OCIManifestSchema1}, options...); err != nil {
+		return "", fmt.Errorf("pushing chart failed: %w", err)
+	}
+	return ref.Context().Digest(digest.String()).String(), nil
+}
+
+// PullChart downloads the Helm chart with the given URL to the given
+// directory, as '<name>-<version>.tgz' with its provenance file if any, and
+// returns the chart metadata. The tag of the URL is the chart version
+// mapped with ChartTag.
+func (c *Client) PullChart(ctx context.Context, url, outDir string) (*ChartPullResult, error) {
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	img, err := remote.Image(ref, crane.GetOptions(c.optionsWithContext(ctx)...).Remote...)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest failed: %w", err)
+	}
+	if manifest.Config.MediaType != oci.HelmChartConfigMediaType {
+		return nil, fmt.Errorf("artifact '%s' is not a Helm chart, config media type is '%s'", url, manifest.Config.MediaType)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("parsing digest failed: %w", err)
+	}
+
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("fetching chart metadata failed: %w", err)
+	}
+	result := &ChartPullResult{Digest: ref.Context().Digest(digest.String()).String()}
+	if err := json.Unmarshal(rawConfig, &result.Metadata); err != nil {
+		return nil, fmt.Errorf("parsing chart metadata failed: %w", err)
+	}
+	if err := validateChartMetadata(result.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid chart metadata: %w", err)
+	}
+
+	chartPath := filepath.Join(outDir, fmt.Sprintf("%s-%s.tgz", result.Metadata.Name, result.Metadata.Version))
+	for _, desc := range manifest.Layers {
+		var dst string
+		switch desc.MediaType {
+		case oci.HelmChartContentMediaType:
+			dst = chartPath
+			result.ChartPath = dst
+		case oci.HelmChartProvenanceMediaType:
+			dst = chartPath + ".prov"
+			result.ProvenancePath = dst
+		default:
+			continue
+		}
+		if err := writeChartLayer(img, desc.Digest, dst); err != nil {
+			return nil, fmt.Errorf("writing '%s' failed: %w", dst, err)
+		}
+	}
+	if result.ChartPath == "" {
+		return nil, fmt.Errorf("no layer found in artifact with media type '%s'", oci.HelmChartContentMediaType)
+	}
+	return result, nil
+}
+
+// writeChartLayer writes the content of the layer of the chart artifact
+// with the given digest to the given path.
+func writeChartLayer(img gcrv1.Image, digest gcrv1.Hash, dst string) error {
+	layer, err := img.LayerByDigest(digest)
+	if err != nil {
+		return err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadChartMetadata returns the Chart.yaml file in JSON format and the
+// metadata of the given packaged chart.
+func loadChartMetadata(chartData []byte) ([]byte, *ChartMetadata, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(chartData))
+	if err != nil {
+		return nil, nil, fmt.Errorf("requires gzip-compressed chart: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("no %s found", chartFileName)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The chart files are in a directory named after the chart.
+		dir, file := path.Split(strings.TrimPrefix(hdr.Name, "./"))
+		if file != chartFileName || strings.Count(dir, "/") != 1 {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		rawMeta, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %s failed: %w", chartFileName, err)
+		}
+		var meta ChartMetadata
+		if err := json.Unmarshal(rawMeta, &meta); err != nil {
+			return nil, nil, fmt.Errorf("parsing %s failed: %w", chartFileName, err)
+		}
+		if err := validateChartMetadata(meta); err != nil {
+			return nil, nil, err
+		}
+		return rawMeta, &meta, nil
+	}
+}
+
+// validateChartMetadata returns an error if the chart name or version are
+// invalid.
+func validateChartMetadata(meta ChartMetadata) error {
+	if meta.Name == "" {
+		return fmt.Errorf("chart name is required")
+	}
+	if strings.ContainsAny(meta.Name, "/\\") {
+		return fmt.Errorf("chart name '%s' is invalid", meta.Name)
+	}
+	if _, err := semver.StrictNewVersion(meta.Version); err != nil {
+		return fmt.Errorf("chart version '%s' is not a valid semver: %w", meta.Version, err)
+	}
+	return nil
+}
+
+// chartAnnotations returns the OpenContainers annotations of the artifact
+// of the chart with the given metadata, as set by Helm. The annotations of
+// the chart are added, except those overriding the chart metadata.
+func chartAnnotations(meta *ChartMetadata) map[string]string {
+	annotations := map[string]string{
+		oci.TitleAnnotation:   meta.Name,
+		oci.VersionAnnotation: meta.Version,
+		oci.CreatedAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+	if meta.Description != "" {
+		annotations[oci.DescriptionAnnotation] = meta.Description
+	}
+	if meta.Home != "" {
+		annotations[oci.URLAnnotation] = meta.Home
+	}
+	if len(meta.Sources) > 0 {
+		annotations[oci.SourceAnnotation] = meta.Sources[0]
+	}
+	var authors []string
+	for _, m := range meta.Maintainers {
+		author := m.Name
+		if m.Email != "" {
+			author = fmt.Sprintf("%s (%s)", m.Name, m.Email)
+		}
+		authors = append(authors, author)
+	}
+	if len(authors) > 0 {
+		annotations[oci.AuthorsAnnotation] = strings.Join(authors, ", ")
+	}
+
+	for k, v := range meta.Annotations {
+		if _, ok := annotations[k]; ok {
+			continue
+		}
+		annotations[k] = v
+	}
+	return annotations
+}
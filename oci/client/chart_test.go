@@ -0,0 +1,208 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+func Test_ChartTag(t *testing.T) {
+	tests := []struct {
+		version string
+		tag     string
+	}{
+		{"6.2.0", "6.2.0"},
+		{"1.0.0-rc.1+build.5", "1.0.0-rc.1_build.5"},
+		{"0.1.0+20221018", "0.1.0_20221018"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ChartTag(tt.version)).To(Equal(tt.tag))
+			g.Expect(ChartVersion(tt.tag)).To(Equal(tt.version))
+		})
+	}
+}
+
+func Test_PushChart_PullChart(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+	repo := fmt.Sprintf("%s/%s", dockerReg, "charts"+randStringRunes(5))
+
+	chartYAML := `apiVersion: v2
+name: podinfo
+version: 6.2.0+build.1
+appVersion: 6.2.0
+description: Podinfo Helm chart for Kubernetes
+home: https://github.com/stefanprodan/podinfo
+sources:
+  - https://github.com/stefanprodan/podinfo
+maintainers:
+  - name: stefanprodan
+    email: stefanprodan@users.noreply.github.com
+annotations:
+  org.opencontainers.image.title: other
+  example.com/team: flux
+`
+	tmpDir := t.TempDir()
+	chartPath := writeTestChart(t, tmpDir, "podinfo", chartYAML)
+	provPath := chartPath + ".prov"
+	if err := os.WriteFile(provPath, []byte("provenance"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewWithT(t)
+	digest, err := c.PushChart(ctx, repo, chartPath, ChartPushOptions{ProvenancePath: provPath})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	url := repo + "/podinfo:6.2.0_build.1"
+	remoteDigest, err := crane.Digest(url)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(digest).To(Equal(repo + "/podinfo@" + remoteDigest))
+
+	image, err := crane.Pull(url)
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := image.Manifest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifest.Config.MediaType).To(Equal(oci.HelmChartConfigMediaType))
+	g.Expect(manifest.Layers).To(HaveLen(2))
+	g.Expect(manifest.Layers[0].MediaType).To(Equal(oci.HelmChartContentMediaType))
+	g.Expect(manifest.Layers[1].MediaType).To(Equal(oci.HelmChartProvenanceMediaType))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue(oci.TitleAnnotation, "podinfo"))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue(oci.VersionAnnotation, "6.2.0+build.1"))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue(oci.DescriptionAnnotation, "Podinfo Helm chart for Kubernetes"))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue(oci.URLAnnotation, "https://github.com/stefanprodan/podinfo"))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue(oci.SourceAnnotation, "https://github.com/stefanprodan/podinfo"))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue(oci.AuthorsAnnotation, "stefanprodan (stefanprodan@users.noreply.github.com)"))
+	g.Expect(manifest.Annotations).To(HaveKeyWithValue("example.com/team", "flux"))
+	g.Expect(manifest.Annotations).To(HaveKey(oci.CreatedAnnotation))
+
+	t.Run("pull", func(t *testing.T) {
+		g := NewWithT(t)
+
+		outDir := t.TempDir()
+		result, err := c.PullChart(ctx, url, outDir)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Digest).To(Equal(digest))
+		g.Expect(result.Metadata.Name).To(Equal("podinfo"))
+		g.Expect(result.Metadata.Version).To(Equal("6.2.0+build.1"))
+		g.Expect(result.Metadata.AppVersion).To(Equal("6.2.0"))
+		g.Expect(result.Metadata.Maintainers).To(HaveLen(1))
+		g.Expect(result.ChartPath).To(Equal(filepath.Join(outDir, "podinfo-6.2.0+build.1.tgz")))
+		g.Expect(result.ProvenancePath).To(Equal(result.ChartPath + ".prov"))
+
+		pulled, err := os.ReadFile(result.ChartPath)
+		g.Expect(err).ToNot(HaveOccurred())
+		pushed, err := os.ReadFile(chartPath)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pulled).To(Equal(pushed))
+		g.Expect(os.ReadFile(result.ProvenancePath)).To(Equal([]byte("provenance")))
+	})
+
+	t.Run("pull non-chart artifact", func(t *testing.T) {
+		g := NewWithT(t)
+
+		artifactURL, _ := pushTestArtifact(t, c)
+		_, err := c.PullChart(ctx, artifactURL, t.TempDir())
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("is not a Helm chart"))
+	})
+}
+
+func Test_PushChart_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		chartName string
+		chartYAML string
+		wantErr   string
+	}{
+		{
+			name:      "missing Chart.yaml",
+			chartName: "podinfo",
+			wantErr:   "no Chart.yaml found",
+		},
+		{
+			name:      "missing name",
+			chartName: "podinfo",
+			chartYAML: "apiVersion: v2\nversion: 1.0.0\n",
+			wantErr:   "chart name is required",
+		},
+		{
+			name:      "invalid version",
+			chartName: "podinfo",
+			chartYAML: "apiVersion: v2\nname: podinfo\nversion: latest\n",
+			wantErr:   "chart version 'latest' is not a valid semver",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			chartPath := writeTestChart(t, t.TempDir(), tt.chartName, tt.chartYAML)
+			_, err := NewLocalClient().PushChart(context.Background(), dockerReg+"/charts", chartPath, ChartPushOptions{})
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+		})
+	}
+}
+
+// writeTestChart writes a packaged Helm chart with the given name and
+// Chart.yaml content to the given directory, and returns its path. The
+// Chart.yaml file is omitted if the content is empty.
+func writeTestChart(t *testing.T, dir, chartName, chartYAML string) string {
+	t.Helper()
+
+	chartPath := filepath.Join(dir, chartName+".tgz")
+	f, err := os.Create(chartPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	files := map[string]string{chartName + "/values.yaml": "replicaCount: 1\n"}
+	if chartYAML != "" {
+		files[chartName+"/Chart.yaml"] = chartYAML
+	}
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return chartPath
+}
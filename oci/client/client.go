@@ -18,8 +18,11 @@ package client
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
 	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
 
 	"github.com/fluxcd/pkg/oci"
@@ -28,6 +31,22 @@ import (
 // Client holds the options for accessing remote OCI registries.
 type Client struct {
 	options []crane.Option
+	// auth is the authenticator configured with the login methods, used
+	// for the registry API calls which are not supported by crane.
+	auth authn.Authenticator
+	// keychain resolves the authenticator of registries, if configured
+	// with LoginWithKeychain.
+	keychain authn.Keychain
+	// cache holds the manifests and layers of pulled artifacts, if set.
+	cache *Cache
+	// registries holds the TLS and HTTP options of registry hosts, set
+	// with SetRegistryOptions.
+	registries map[string]RegistryOptions
+	// baseTransport is the transport set with SetTransport, used for the
+	// registry hosts without options.
+	baseTransport http.RoundTripper
+	// transport applies the registry options to the requests, if set.
+	transport http.RoundTripper
 }
 
 // NewClient returns an OCI client configured with the given crane options.
@@ -58,5 +77,22 @@ func (c *Client) optionsWithContext(ctx context.Context) []crane.Option {
 	options := []crane.Option{
 		crane.WithContext(ctx),
 	}
-	return append(options, c.options...)
+	options = append(options, c.options...)
+	if c.transport != nil {
+		options = append(options, crane.WithTransport(c.transport))
+	}
+	return options
+}
+
+// authenticator returns the authenticator for the given repository, either
+// configured with the login methods or resolved with the configured keychain,
+// defaulting to the Docker keychain.
+func (c *Client) authenticator(repo name.Repository) (authn.Authenticator, error) {
+	if c.auth != nil {
+		return c.auth, nil
+	}
+	if c.keychain != nil {
+		return c.keychain.Resolve(repo)
+	}
+	return authn.DefaultKeychain.Resolve(repo)
 }
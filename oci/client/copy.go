@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// CopyOptions holds the options for copying artifacts between registries.
+type CopyOptions struct {
+	// Destination is the client used to push to the destination registry,
+	// configured with its own credentials. Defaults to the copying client.
+	Destination *Client
+	// IncludeSignatures copies the cosign signatures stored under the
+	// sha256-<digest>.sig tag of the artifact.
+	IncludeSignatures bool
+	// IncludeReferrers copies the referrers of the artifact, such as SBOMs
+	// and signatures stored as OCI referrers.
+	IncludeReferrers bool
+}
+
+// Copy copies the artifact with the given source URL, a tag or a digest, to
+// the given destination URL, and returns the digest reference of the copied
+// artifact. The artifact is read from the source registry with the client
+// credentials and pushed to the destination registry with the credentials of
+// the destination client. The blobs are streamed between registries and the
+// manifests copied as is, so the digest of the artifact is preserved.
+func (c *Client) Copy(ctx context.Context, srcURL, dstURL string, opts CopyOptions) (string, error) {
+	src, err := name.ParseReference(srcURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid source URL: %w", err)
+	}
+	dst, err := name.ParseReference(dstURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination URL: %w", err)
+	}
+	dstClient := opts.Destination
+	if dstClient == nil {
+		dstClient = c
+	}
+
+	desc, err := c.copy(ctx, dstClient, src, dst)
+	if err != nil {
+		return "", fmt.Errorf("copying '%s' failed: %w", srcURL, err)
+	}
+
+	if opts.IncludeSignatures {
+		sigTag := cosignSignatureTag(desc.Digest)
+		_, err := c.copy(ctx, dstClient, src.Context().Tag(sigTag), dst.Context().Tag(sigTag))
+		if err != nil && !isNotFound(err) {
+			return "", fmt.Errorf("copying signature of '%s' failed: %w", srcURL, err)
+		}
+	}
+
+	if opts.IncludeReferrers {
+		referrers, err := c.referrers(ctx, src.Context().Digest(desc.Digest.String()), "")
+		if err != nil {
+			return "", err
+		}
+		for _, r := range referrers {
+			digest := r.Digest.String()
+			if _, err := c.copy(ctx, dstClient, src.Context().Digest(digest), dst.Context().Digest(digest)); err != nil {
+				return "", fmt.Errorf("copying referrer '%s' of '%s' failed: %w", digest, srcURL, err)
+			}
+		}
+	}
+
+	return dst.Context().Digest(desc.Digest.String()).String(), nil
+}
+
+// copy copies the image or index with the given source reference to the
+// destination reference with the destination client, and returns the
+// descriptor of the source.
+func (c *Client) copy(ctx context.Context, dstClient *Client, src, dst name.Reference) (*remote.Descriptor, error) {
+	desc, err := remote.Get(src, crane.GetOptions(c.optionsWithContext(ctx)...).Remote...)
+	if err != nil {
+		return nil, err
+	}
+
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		if err := remote.WriteIndex(dst, idx, crane.GetOptions(dstClient.optionsWithContext(ctx)...).Remote...); err != nil {
+			return nil, fmt.Errorf("pushing '%s' failed: %w", dst, err)
+		}
+		return desc, nil
+	}
+
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	if err := dstClient.writeImage(ctx, dst, img); err != nil {
+		return nil, fmt.Errorf("pushing '%s' failed: %w", dst, err)
+	}
+	return desc, nil
+}
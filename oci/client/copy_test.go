@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	. "github.com/onsi/gomega"
+)
+
+func Test_Copy(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbom := filepath.Join(t.TempDir(), "sbom.spdx.json")
+	if err := os.WriteFile(sbom, []byte(`{"spdxVersion":"SPDX-2.3"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	url, digest := pushTestArtifact(t, c)
+	if _, err := c.Sign(ctx, url, SignOptions{Signer: key}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Attach(ctx, url, Attachment{ArtifactType: "application/spdx+json", Files: []AttachmentFile{{Path: sbom}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	apiReg := newReferrersAPIRegistry(t)
+	for _, tt := range []struct {
+		name     string
+		registry string
+		src      string
+	}{
+		{name: "tag to referrers tag schema", registry: dockerReg, src: url},
+		{name: "digest to referrers API", registry: apiReg, src: digest.String()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dst := fmt.Sprintf("%s/%s:v1.0.0", tt.registry, "test-copy"+randStringRunes(5))
+			copied, err := c.Copy(ctx, tt.src, dst, CopyOptions{
+				Destination:       NewLocalClient(),
+				IncludeSignatures: true,
+				IncludeReferrers:  true,
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			dstDigest, err := crane.Digest(dst)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(dstDigest).To(Equal(digest.DigestStr()))
+			g.Expect(copied).To(HaveSuffix("@" + digest.DigestStr()))
+
+			err = c.Verify(ctx, dst, VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &key.PublicKey)}}})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			referrers, err := c.Referrers(ctx, dst, "application/spdx+json")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(referrers).To(HaveLen(1))
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := c.Copy(ctx, fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "not-found"), fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-copy"), CopyOptions{})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("copying"))
+	})
+}
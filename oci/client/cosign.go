@@ -0,0 +1,626 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// cosignSignatureTagSuffix is the suffix of the tags holding the cosign
+	// signatures of an artifact, named after its digest.
+	cosignSignatureTagSuffix = ".sig"
+
+	// cosignSimpleSigningMediaType is the media type of the layers of
+	// cosign signatures, holding the signed payload.
+	cosignSimpleSigningMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// cosignSignatureAnnotation is the annotation of a signature layer
+	// holding the base64 encoded signature of the payload.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	// cosignCertificateAnnotation is the annotation of a keyless signature
+	// layer holding the PEM encoded signing certificate.
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+
+	// cosignChainAnnotation is the annotation of a keyless signature layer
+	// holding the PEM encoded certificate chain of the signing certificate.
+	cosignChainAnnotation = "dev.sigstore.cosign/chain"
+
+	// cosignBundleAnnotation is the annotation of a keyless signature layer
+	// holding the Rekor transparency log entry of the signature.
+	cosignBundleAnnotation = "dev.sigstore.cosign/bundle"
+
+	// rekorHashedRekordKind is the kind of the Rekor entries of signatures
+	// of hashed payloads.
+	rekorHashedRekordKind = "hashedrekord"
+
+	// cosignSignatureType is the type of the payload of container image
+	// signatures.
+	cosignSignatureType = "cosign container image signature"
+)
+
+var (
+	// oidIssuer is the Fulcio certificate extension holding the OIDC
+	// issuer of the identity, as raw string.
+	oidIssuer = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	// oidIssuerV2 is the Fulcio certificate extension holding the OIDC
+	// issuer of the identity, as DER encoded UTF8String.
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// CosignVerifyOptions holds the trust configuration for verifying cosign
+// signatures.
+type CosignVerifyOptions struct {
+	// PublicKeys are the PEM encoded public keys of which signatures made
+	// with static keys are trusted.
+	PublicKeys [][]byte
+	// Roots is the pool of root certificates of which keyless signatures
+	// are trusted. Keyless signatures are ignored if nil.
+	Roots *x509.CertPool
+	// RekorPublicKeys are the PEM encoded public keys of the Rekor
+	// transparency logs of which entries are trusted. They are required to
+	// verify keyless signatures, which are only trusted with a log entry
+	// proving they were made while their certificate was valid.
+	RekorPublicKeys [][]byte
+	// Intermediates is an optional pool of intermediate certificates, in
+	// addition to the chain stored with keyless signatures.
+	Intermediates *x509.CertPool
+	// Identities restricts the trusted keyless signatures to the ones with
+	// a certificate matching one of the identities. At least one identity
+	// is required to verify keyless signatures, as any holder of an OIDC
+	// token can get a certificate from the trust roots.
+	Identities []CosignIdentity
+}
+
+// CosignIdentity is the identity of the signer of a keyless signature.
+type CosignIdentity struct {
+	// Subject is the email address or URI of the signer. Any subject
+	// matches if empty.
+	Subject string
+	// Issuer is the OIDC issuer which authenticated the signer. Any issuer
+	// matches if empty.
+	Issuer string
+}
+
+// cosignPayload is the payload signed by cosign, in the simple signing
+// format.
+type cosignPayload struct {
+	Critical cosignCritical         `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+type cosignCritical struct {
+	Identity cosignIdentity `json:"identity"`
+	Image    cosignImage    `json:"image"`
+	Type     string         `json:"type"`
+}
+
+type cosignIdentity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+type cosignImage struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// cosignBundle is the Rekor transparency log entry of a keyless signature,
+// stored with the signature by cosign.
+type cosignBundle struct {
+	SignedEntryTimestamp []byte              `json:"SignedEntryTimestamp"`
+	Payload              cosignBundlePayload `json:"Payload"`
+}
+
+type cosignBundlePayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogIndex       int64  `json:"logIndex"`
+	LogID          string `json:"logID"`
+}
+
+// rekorEntry holds the fields of the hashedrekord entries of Rekor used to
+// match an entry with a signature.
+type rekorEntry struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// cosignSignatureTag returns the tag holding the cosign signatures of the
+// artifact with the given digest.
+func cosignSignatureTag(digest gcrv1.Hash) string {
+	return fmt.Sprintf("%s-%s%s", digest.Algorithm, digest.Hex, cosignSignatureTagSuffix)
+}
+
+// verifyCosign verifies that the artifact with the given digest has at
+// least one cosign signature trusted by the given options.
+func (c *Client) verifyCosign(ctx context.Context, ref name.Digest, opts *CosignVerifyOptions) error {
+	if len(opts.PublicKeys) == 0 && opts.Roots == nil {
+		return fmt.Errorf("no cosign public keys or trust roots configured")
+	}
+	keys := make([]crypto.PublicKey, 0, len(opts.PublicKeys))
+	for _, k := range opts.PublicKeys {
+		pub, err := parsePublicKey(k)
+		if err != nil {
+			return fmt.Errorf("invalid cosign public key: %w", err)
+		}
+		keys = append(keys, pub)
+	}
+	if opts.Roots != nil && len(opts.RekorPublicKeys) == 0 {
+		return fmt.Errorf("no Rekor public keys configured for verifying keyless cosign signatures")
+	}
+	if opts.Roots != nil && len(opts.Identities) == 0 {
+		return fmt.Errorf("no identities configured for verifying keyless cosign signatures")
+	}
+
+	digest, err := gcrv1.NewHash(ref.DigestStr())
+	if err != nil {
+		return err
+	}
+	sigImgs, err := c.cosignSignatureImages(ctx, ref, digest)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, sigImg := range sigImgs {
+		manifest, err := sigImg.Manifest()
+		if err != nil {
+			return fmt.Errorf("parsing cosign signatures manifest failed: %w", err)
+		}
+		for _, desc := range manifest.Layers {
+			if desc.MediaType != cosignSimpleSigningMediaType {
+				continue
+			}
+			err := verifyCosignSignature(sigImg, desc, digest, keys, opts)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no cosign signatures found for '%s'", ref)
+	}
+	return fmt.Errorf("no valid cosign signature found for '%s': %s", ref, strings.Join(errs, "; "))
+}
+
+// cosignSignatureImages returns the images holding the cosign signatures of
+// the artifact with the given digest, stored under the signature tag or as
+// OCI referrers.
+func (c *Client) cosignSignatureImages(ctx context.Context, ref name.Digest, digest gcrv1.Hash) ([]gcrv1.Image, error) {
+	var images []gcrv1.Image
+	sigRef := ref.Context().Tag(cosignSignatureTag(digest))
+	sigImg, err := crane.Pull(sigRef.String(), c.optionsWithContext(ctx)...)
+	switch {
+	case err == nil:
+		images = append(images, sigImg)
+	case !isNotFound(err):
+		return nil, fmt.Errorf("fetching cosign signatures of '%s' failed: %w", ref, err)
+	}
+
+	referrers, err := c.referrers(ctx, ref, cosignSignatureArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range referrers {
+		img, err := crane.Pull(ref.Context().Digest(desc.Digest.String()).String(), c.optionsWithContext(ctx)...)
+		if err != nil {
+			return nil, fmt.Errorf("fetching cosign signature '%s' failed: %w", desc.Digest, err)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// verifyCosignSignature verifies the signature stored in the given layer of
+// a cosign signatures image against the digest of the signed artifact.
+func verifyCosignSignature(img gcrv1.Image, desc gcrv1.Descriptor, digest gcrv1.Hash, keys []crypto.PublicKey, opts *CosignVerifyOptions) error {
+	layer, err := img.LayerByDigest(desc.Digest)
+	if err != nil {
+		return err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	payload, err := io.ReadAll(rc)
+	if err != nil {
+		return fmt.Errorf("reading signature payload failed: %w", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(desc.Annotations[cosignSignatureAnnotation])
+	if err != nil {
+		return fmt.Errorf("decoding signature failed: %w", err)
+	}
+	if len(sig) == 0 {
+		return fmt.Errorf("signature annotation '%s' not found", cosignSignatureAnnotation)
+	}
+
+	if certPEM := desc.Annotations[cosignCertificateAnnotation]; certPEM != "" && opts.Roots != nil {
+		signedAt, err := verifyCosignBundle([]byte(desc.Annotations[cosignBundleAnnotation]), []byte(certPEM), payload, sig, opts)
+		if err != nil {
+			return err
+		}
+		cert, err := verifyCosignCertificate([]byte(certPEM), []byte(desc.Annotations[cosignChainAnnotation]), signedAt, opts)
+		if err != nil {
+			return err
+		}
+		if err := verifyPayloadSignature(cert.PublicKey, payload, sig); err != nil {
+			return err
+		}
+	} else {
+		if len(keys) == 0 {
+			return fmt.Errorf("signature is not keyless and no public keys are configured")
+		}
+		var verified bool
+		for _, k := range keys {
+			if verifyPayloadSignature(k, payload, sig) == nil {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return fmt.Errorf("signature does not match any of the public keys")
+		}
+	}
+
+	// The payload is only trusted once its signature is verified.
+	var p cosignPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("parsing signature payload failed: %w", err)
+	}
+	if p.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unsupported signature type '%s'", p.Critical.Type)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature is for digest '%s'", p.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+// verifyCosignBundle verifies that the Rekor transparency log entry of a
+// keyless signature is signed by one of the Rekor public keys of the
+// options, and that it records the given signature of the payload made with
+// the given certificate. It returns the time at which the entry was
+// integrated in the log.
+func verifyCosignBundle(bundleJSON, certPEM, payload, sig []byte, opts *CosignVerifyOptions) (time.Time, error) {
+	if len(bundleJSON) == 0 {
+		return time.Time{}, fmt.Errorf("keyless signature has no transparency log entry")
+	}
+	var bundle cosignBundle
+	if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+		return time.Time{}, fmt.Errorf("parsing transparency log entry failed: %w", err)
+	}
+
+	var rekorKey crypto.PublicKey
+	for _, k := range opts.RekorPublicKeys {
+		pub, err := parsePublicKey(k)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Rekor public key: %w", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Rekor public key: %w", err)
+		}
+		if logID := sha256.Sum256(der); hex.EncodeToString(logID[:]) == bundle.Payload.LogID {
+			rekorKey = pub
+			break
+		}
+	}
+	if rekorKey == nil {
+		return time.Time{}, fmt.Errorf("transparency log entry is from untrusted log '%s'", bundle.Payload.LogID)
+	}
+	// The signed entry timestamp is a signature of the canonical JSON of the
+	// entry.
+	canonical, err := canonicalJSON(map[string]interface{}{
+		"body":           bundle.Payload.Body,
+		"integratedTime": bundle.Payload.IntegratedTime,
+		"logIndex":       bundle.Payload.LogIndex,
+		"logID":          bundle.Payload.LogID,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry: %w", err)
+	}
+	if err := verifyPayloadSignature(rekorKey, canonical, bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry timestamp: %w", err)
+	}
+
+	// The entry is only trusted once its timestamp is verified.
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding transparency log entry failed: %w", err)
+	}
+	var entry rekorEntry
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("parsing transparency log entry failed: %w", err)
+	}
+	if entry.Kind != rekorHashedRekordKind {
+		return time.Time{}, fmt.Errorf("unsupported transparency log entry kind '%s'", entry.Kind)
+	}
+	h := sha256.Sum256(payload)
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(h[:]) {
+		return time.Time{}, fmt.Errorf("transparency log entry does not match the signature payload")
+	}
+	if !bytes.Equal(entry.Spec.Signature.Content, sig) {
+		return time.Time{}, fmt.Errorf("transparency log entry does not match the signature")
+	}
+	entryCerts, err := parseCertificates(entry.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry certificate: %w", err)
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil || !entryCerts[0].Equal(certs[0]) {
+		return time.Time{}, fmt.Errorf("transparency log entry does not match the signing certificate")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// canonicalJSON returns the JSON canonical form, as defined by RFC 8785, of
+// the given object holding string and integer values. The integers must be
+// exactly representable as IEEE 754 doubles.
+func canonicalJSON(obj map[string]interface{}) ([]byte, error) {
+	// The keys are sorted by their UTF-16 code units.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := utf16.Encode([]rune(keys[i])), utf16.Encode([]rune(keys[j]))
+		for n := 0; n < len(a) && n < len(b); n++ {
+			if a[n] != b[n] {
+				return a[n] < b[n]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeCanonicalString(&buf, k); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		switch v := obj[k].(type) {
+		case string:
+			if err := writeCanonicalString(&buf, v); err != nil {
+				return nil, err
+			}
+		case int64:
+			if v > 1<<53 || v < -(1<<53) {
+				return nil, fmt.Errorf("integer '%s' is not exactly representable", k)
+			}
+			buf.WriteString(strconv.FormatInt(v, 10))
+		default:
+			return nil, fmt.Errorf("unsupported value type %T of '%s'", v, k)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeCanonicalString writes the given string in the JSON canonical form,
+// escaping only the quotation mark, the reverse solidus and the control
+// characters.
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("invalid UTF-8 string")
+	}
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return nil
+}
+
+// verifyCosignCertificate verifies the PEM encoded certificate of a keyless
+// signature against the trust roots and identities of the options, and
+// returns it. The chain is verified at the given signing time, as recorded
+// by the transparency log, since keyless signing certificates are
+// short-lived.
+func verifyCosignCertificate(certPEM, chainPEM []byte, signedAt time.Time, opts *CosignVerifyOptions) (*x509.Certificate, error) {
+	certs, err := parseCertificates(certPEM)
+	if err != nil || len(certs) != 1 {
+		return nil, fmt.Errorf("invalid signing certificate")
+	}
+	cert := certs[0]
+
+	intermediates := x509.NewCertPool()
+	if opts.Intermediates != nil {
+		intermediates = opts.Intermediates.Clone()
+	}
+	if len(chainPEM) > 0 {
+		chain, err := parseCertificates(chainPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing certificate chain: %w", err)
+		}
+		for _, c := range chain {
+			intermediates.AddCert(c)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+
+	subjects := append([]string{}, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		subjects = append(subjects, u.String())
+	}
+	issuer := certificateIssuer(cert)
+	for _, id := range opts.Identities {
+		if id.Issuer != "" && id.Issuer != issuer {
+			continue
+		}
+		if id.Subject == "" || containsString(subjects, id.Subject) {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("signing certificate identity %v issued by '%s' is not trusted", subjects, issuer)
+}
+
+// certificateIssuer returns the OIDC issuer stored in the extensions of a
+// keyless signing certificate.
+func certificateIssuer(cert *x509.Certificate) string {
+	var issuer string
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			var s string
+			if _, err := asn1.Unmarshal(ext.Value, &s); err == nil {
+				return s
+			}
+		case ext.Id.Equal(oidIssuer):
+			issuer = string(ext.Value)
+		}
+	}
+	return issuer
+}
+
+// verifyPayloadSignature verifies the cosign signature of the payload with
+// the given public key.
+func verifyPayloadSignature(pub crypto.PublicKey, payload, sig []byte) error {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(k, h[:], sig) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return fmt.Errorf("invalid ED25519 signature")
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(payload)
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			return fmt.Errorf("invalid RSA signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// parseCertificates parses the PEM encoded certificates of the given data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// dockerHubConfigKey is the key of the Docker Hub credentials in
+	// Docker config files.
+	dockerHubConfigKey = "https://index.docker.io/v1/"
+
+	// credentialHelperPrefix is the prefix of the Docker credential helper
+	// executables.
+	credentialHelperPrefix = "docker-credential-"
+
+	// credentialHelperTokenUsername is the username returned by Docker
+	// credential helpers for identity tokens.
+	credentialHelperTokenUsername = "<token>"
+)
+
+// credentialHelperRegexp matches the valid names of Docker credential
+// helpers, which are the suffix of their executable name.
+var credentialHelperRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// dockerConfig is the format of Docker config files and of the data of
+// Kubernetes secrets of type kubernetes.io/dockerconfigjson.
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+}
+
+// dockerAuth holds the credentials of a registry in Docker config files.
+type dockerAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// DockerConfigKeychain is a keychain resolving the credentials of registries
+// from Docker config files. Registries are matched by host, or by wildcard
+// patterns like '*.example.com' where each label of the host is matched
+// against the pattern with the same index. Exact matches take precedence
+// over wildcard ones.
+type DockerConfigKeychain struct {
+	auths       map[string]authn.AuthConfig
+	credHelpers map[string]string
+	credsStore  string
+}
+
+// NewDockerConfigKeychain returns a keychain for the given Docker config
+// file content, in the format of '~/.docker/config.json'.
+func NewDockerConfigKeychain(data []byte) (*DockerConfigKeychain, error) {
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse Docker config: %w", err)
+	}
+	return newDockerConfigKeychain(config)
+}
+
+// NewKeychainFromSecret returns a keychain for the given Kubernetes secret,
+// of type kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg. The
+// credential helpers configured in the secret are ignored, as they would
+// run executables chosen by the author of the secret.
+func NewKeychainFromSecret(secret *corev1.Secret) (*DockerConfigKeychain, error) {
+	var config dockerConfig
+	var err error
+	if data, ok := secret.Data[corev1.DockerConfigJsonKey]; ok {
+		err = json.Unmarshal(data, &config)
+	} else if data, ok := secret.Data[corev1.DockerConfigKey]; ok {
+		// The legacy format holds the credentials at the top level.
+		err = json.Unmarshal(data, &config.Auths)
+	} else {
+		return nil, fmt.Errorf("'%s' not found in secret '%s/%s'", corev1.DockerConfigJsonKey, secret.Namespace, secret.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker config in secret '%s/%s': %w", secret.Namespace, secret.Name, err)
+	}
+	config.CredHelpers = nil
+	config.CredsStore = ""
+
+	keychain, err := newDockerConfigKeychain(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker config in secret '%s/%s': %w", secret.Namespace, secret.Name, err)
+	}
+	return keychain, nil
+}
+
+// NewKeychainFromSecrets returns a keychain resolving the credentials of
+// registries from the given Kubernetes secrets, in order.
+func NewKeychainFromSecrets(secrets []corev1.Secret) (authn.Keychain, error) {
+	keychains := make([]authn.Keychain, 0, len(secrets))
+	for i := range secrets {
+		keychain, err := NewKeychainFromSecret(&secrets[i])
+		if err != nil {
+			return nil, err
+		}
+		keychains = append(keychains, keychain)
+	}
+	return authn.NewMultiKeychain(keychains...), nil
+}
+
+func newDockerConfigKeychain(config dockerConfig) (*DockerConfigKeychain, error) {
+	keychain := &DockerConfigKeychain{
+		auths:       make(map[string]authn.AuthConfig, len(config.Auths)),
+		credHelpers: make(map[string]string, len(config.CredHelpers)),
+		credsStore:  config.CredsStore,
+	}
+	for key, auth := range config.Auths {
+		authConfig := authn.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+		}
+		if auth.Auth != "" && auth.Username == "" && auth.Password == "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for '%s': %w", key, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth for '%s': expected user:password format", key)
+			}
+			authConfig.Username, authConfig.Password = parts[0], parts[1]
+		}
+		keychain.auths[registryHost(key)] = authConfig
+	}
+	for key, helper := range config.CredHelpers {
+		if !credentialHelperRegexp.MatchString(helper) {
+			return nil, fmt.Errorf("invalid credential helper '%s' for '%s'", helper, key)
+		}
+		keychain.credHelpers[registryHost(key)] = helper
+	}
+	if config.CredsStore != "" && !credentialHelperRegexp.MatchString(config.CredsStore) {
+		return nil, fmt.Errorf("invalid credentials store '%s'", config.CredsStore)
+	}
+	return keychain, nil
+}
+
+// Resolve returns the authenticator for the registry of the given resource,
+// or the anonymous authenticator if the config holds no credentials for it.
+func (k *DockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	host := target.RegistryStr()
+	if host == name.DefaultRegistry {
+		host = registryHost(dockerHubConfigKey)
+	}
+
+	// Credential helpers store the Docker Hub credentials under the
+	// Docker Hub config key, like Docker does.
+	serverURL := host
+	if host == registryHost(dockerHubConfigKey) {
+		serverURL = dockerHubConfigKey
+	}
+
+	if helper, ok := matchRegistry(k.credHelpers, host); ok {
+		return credentialHelperAuth(helper, serverURL)
+	}
+	if auth, ok := matchRegistry(k.auths, host); ok {
+		return authn.FromConfig(auth), nil
+	}
+	if k.credsStore != "" {
+		return credentialHelperAuth(k.credsStore, serverURL)
+	}
+	return authn.Anonymous, nil
+}
+
+// LoginWithKeychain configures the client to resolve the credentials of
+// registries with the given keychain, for all operations.
+func (c *Client) LoginWithKeychain(keychain authn.Keychain) {
+	c.keychain = keychain
+	c.options = append(c.options, crane.WithAuthFromKeychain(keychain))
+}
+
+// LoginWithDockerConfig configures the client with the credentials of the
+// given Kubernetes secrets, of type kubernetes.io/dockerconfigjson.
+func (c *Client) LoginWithDockerConfig(secrets ...corev1.Secret) error {
+	keychain, err := NewKeychainFromSecrets(secrets)
+	if err != nil {
+		return err
+	}
+	c.LoginWithKeychain(keychain)
+	return nil
+}
+
+// matchRegistry returns the value of the entry matching the given host,
+// preferring exact matches over wildcard ones.
+func matchRegistry[T any](entries map[string]T, host string) (T, bool) {
+	if v, ok := entries[host]; ok {
+		return v, true
+	}
+	var match string
+	for pattern := range entries {
+		// The longest pattern wins, for the result to be deterministic.
+		if strings.Contains(pattern, "*") && matchHost(pattern, host) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	if match != "" {
+		return entries[match], true
+	}
+	var zero T
+	return zero, false
+}
+
+// matchHost reports whether the host matches the wildcard pattern, label
+// by label. The port, if any, must match exactly.
+func matchHost(pattern, host string) bool {
+	patternHost, patternPort := splitPort(pattern)
+	hostName, hostPort := splitPort(host)
+	if patternPort != hostPort {
+		return false
+	}
+
+	patternLabels := strings.Split(patternHost, ".")
+	hostLabels := strings.Split(hostName, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i := range patternLabels {
+		if ok, err := path.Match(patternLabels[i], hostLabels[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPort splits the host and port of the given address, returning an
+// empty port if it has none.
+func splitPort(addr string) (string, string) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return host, port
+	}
+	return addr, ""
+}
+
+// registryHost returns the registry host of the given Docker config key,
+// which can be a host or a URL.
+func registryHost(key string) string {
+	host := key
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if host == "docker.io" {
+		host = "index.docker.io"
+	}
+	return strings.ToLower(host)
+}
+
+// credentialHelperAuth returns the authenticator for the given registry
+// server URL, with the credentials returned by the Docker credential helper.
+func credentialHelperAuth(helper, serverURL string) (authn.Authenticator, error) {
+	if !credentialHelperRegexp.MatchString(helper) {
+		return nil, fmt.Errorf("invalid credential helper '%s'", helper)
+	}
+	cmd := exec.Command(credentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Credential helpers report missing credentials on stdout.
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return authn.Anonymous, nil
+		}
+		return nil, fmt.Errorf("credential helper '%s' failed: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse output of credential helper '%s': %w", helper, err)
+	}
+	if creds.Username == credentialHelperTokenUsername {
+		return authn.FromConfig(authn.AuthConfig{IdentityToken: creds.Secret}), nil
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: creds.Username, Password: creds.Secret}), nil
+}
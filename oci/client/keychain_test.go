@@ -0,0 +1,222 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testDockerConfig = `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "aHViOnB3ZA=="},
+    "ghcr.io": {"username": "ghcr", "password": "pwd"},
+    "http://registry.example.com:5000/v2/": {"username": "example", "password": "pwd"},
+    "*.mirror.example.com": {"username": "mirror", "password": "pwd"},
+    "eu.mirror.example.com": {"registrytoken": "token"}
+  },
+  "credHelpers": {
+    "helper.example.com": "test"
+  }
+}`
+
+// testHelperDockerConfig is a Docker config resolving the credentials of
+// all registries with the test credential helper.
+const testHelperDockerConfig = `{"credsStore": "test"}`
+
+func Test_DockerConfigKeychain(t *testing.T) {
+	g := NewWithT(t)
+
+	// The credential helper prints the credentials for the registry host.
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nread host\necho \"{\\\"Username\\\":\\\"<token>\\\",\\\"Secret\\\":\\\"$host\\\"}\"\n"
+	g.Expect(os.WriteFile(filepath.Join(binDir, credentialHelperPrefix+"test"), []byte(script), 0o755)).To(Succeed())
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	keychain, err := NewDockerConfigKeychain([]byte(testDockerConfig))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		repo   string
+		config string
+		want   authn.AuthConfig
+	}{
+		{repo: "fluxcd/flux", want: authn.AuthConfig{Username: "hub", Password: "pwd"}},
+		{repo: "ghcr.io/fluxcd/flux", want: authn.AuthConfig{Username: "ghcr", Password: "pwd"}},
+		{repo: "registry.example.com:5000/flux", want: authn.AuthConfig{Username: "example", Password: "pwd"}},
+		{repo: "registry.example.com/flux", want: authn.AuthConfig{}},
+		{repo: "us.mirror.example.com/flux", want: authn.AuthConfig{Username: "mirror", Password: "pwd"}},
+		{repo: "eu.mirror.example.com/flux", want: authn.AuthConfig{RegistryToken: "token"}},
+		{repo: "a.b.mirror.example.com/flux", want: authn.AuthConfig{}},
+		{repo: "helper.example.com/flux", want: authn.AuthConfig{IdentityToken: "helper.example.com"}},
+		{repo: "fluxcd/flux", config: testHelperDockerConfig, want: authn.AuthConfig{IdentityToken: dockerHubConfigKey}},
+		{repo: "ghcr.io/fluxcd/flux", config: testHelperDockerConfig, want: authn.AuthConfig{IdentityToken: "ghcr.io"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			g := NewWithT(t)
+
+			keychain := keychain
+			if tt.config != "" {
+				var err error
+				keychain, err = NewDockerConfigKeychain([]byte(tt.config))
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			repo, err := name.NewRepository(tt.repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			auth, err := keychain.Resolve(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			authConfig, err := auth.Authorization()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(*authConfig).To(Equal(tt.want))
+		})
+	}
+}
+
+func Test_NewKeychainFromSecret(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          map[string][]byte
+		wantErr       string
+		wantAnonymous bool
+	}{
+		{
+			name: "dockerconfigjson",
+			data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(testDockerConfig)},
+		},
+		{
+			name: "dockercfg",
+			data: map[string][]byte{corev1.DockerConfigKey: []byte(`{"ghcr.io": {"username": "ghcr", "password": "pwd"}}`)},
+		},
+		{
+			name:    "invalid auth",
+			data:    map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {"ghcr.io": {"auth": "invalid"}}}`)},
+			wantErr: "invalid auth for 'ghcr.io'",
+		},
+		{
+			name:    "missing config",
+			wantErr: "'.dockerconfigjson' not found in secret 'default/creds'",
+		},
+		{
+			// The credential helpers of the secret are not run.
+			name:          "credential helpers",
+			data:          map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"credHelpers": {"ghcr.io": "../../bin/sh"}, "credsStore": "test"}`)},
+			wantAnonymous: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			keychain, err := NewKeychainFromSecret(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+				Data:       tt.data,
+			})
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+
+			repo, err := name.NewRepository("ghcr.io/fluxcd/flux")
+			g.Expect(err).ToNot(HaveOccurred())
+			auth, err := keychain.Resolve(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.wantAnonymous {
+				g.Expect(auth).To(Equal(authn.Anonymous))
+				return
+			}
+			g.Expect(auth).ToNot(Equal(authn.Anonymous))
+		})
+	}
+}
+
+func Test_NewDockerConfigKeychain_InvalidHelper(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "credential helper path",
+			config:  `{"credHelpers": {"ghcr.io": "../../bin/sh"}}`,
+			wantErr: "invalid credential helper '../../bin/sh' for 'ghcr.io'",
+		},
+		{
+			name:    "credentials store with arguments",
+			config:  `{"credsStore": "pass; rm"}`,
+			wantErr: "invalid credentials store 'pass; rm'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := NewDockerConfigKeychain([]byte(tt.config))
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+		})
+	}
+}
+
+func Test_LoginWithDockerConfig(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	c := NewLocalClient()
+	config := fmt.Sprintf(`{"auths": {%q: {"username": "username", "password": "password"}}}`, dockerReg)
+	err := c.LoginWithDockerConfig(corev1.Secret{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	transportFunc := mockTransport{
+		response: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+		},
+	}
+	c.options = append(c.options, crane.WithTransport(&transportFunc))
+
+	err = crane.Delete(fmt.Sprintf("%s/%s:%s", dockerReg, "test", "test"), c.optionsWithContext(ctx)...)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(transportFunc.request).ToNot(BeNil())
+	g.Expect(transportFunc.request.Header.Get("Authorization")).To(Equal("Basic dXNlcm5hbWU6cGFzc3dvcmQ="))
+
+	repo, err := name.NewRepository(fmt.Sprintf("%s/%s", dockerReg, "test"))
+	g.Expect(err).ToNot(HaveOccurred())
+	auth, err := c.authenticator(repo)
+	g.Expect(err).ToNot(HaveOccurred())
+	authConfig, err := auth.Authorization()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Username).To(Equal("username"))
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/fluxcd/pkg/untar"
+)
+
+const (
+	// layoutRefNameAnnotation is the annotation of the descriptors of the
+	// OCI image layout index holding the reference of tagged artifacts.
+	layoutRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+	// layoutRepositoryAnnotation is the annotation of the descriptors of
+	// the OCI image layout index holding the repository of referrers.
+	layoutRepositoryAnnotation = "io.fluxcd.oci.repository"
+)
+
+// ExportOptions holds the options for exporting artifacts to an OCI image
+// layout.
+type ExportOptions struct {
+	// IncludeSignatures exports the cosign signatures stored under the
+	// sha256-<digest>.sig tag of the artifacts.
+	IncludeSignatures bool
+	// IncludeReferrers exports the referrers of the artifacts, such as
+	// SBOMs and signatures stored as OCI referrers.
+	IncludeReferrers bool
+}
+
+// Export saves the artifacts with the given URLs to an OCI image layout at
+// the given path, with their signatures and referrers if configured. The
+// layout is written as a gzip compressed tarball if the path ends with
+// '.tar.gz' or '.tgz', and as a directory otherwise.
+func (c *Client) Export(ctx context.Context, urls []string, path string, opts ExportOptions) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("at least one artifact URL is required")
+	}
+
+	dir := path
+	if isTarball(path) {
+		tmpDir, err := os.MkdirTemp("", "oci-layout")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		dir = tmpDir
+	}
+
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		if p, err = layout.Write(dir, empty.Index); err != nil {
+			return fmt.Errorf("creating OCI layout failed: %w", err)
+		}
+	}
+
+	for _, url := range urls {
+		if err := c.export(ctx, p, url, opts); err != nil {
+			return err
+		}
+	}
+
+	if isTarball(path) {
+		return c.Build(path, dir, nil)
+	}
+	return nil
+}
+
+// export saves the artifact with the given URL to the OCI image layout.
+func (c *Client) export(ctx context.Context, p layout.Path, url string, opts ExportOptions) error {
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	options := crane.GetOptions(c.optionsWithContext(ctx)...).Remote
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return fmt.Errorf("fetching artifact '%s' failed: %w", url, err)
+	}
+	if err := appendDescriptor(p, desc, map[string]string{layoutRefNameAnnotation: ref.Name()}); err != nil {
+		return fmt.Errorf("exporting artifact '%s' failed: %w", url, err)
+	}
+	digestRef := ref.Context().Digest(desc.Digest.String())
+
+	if opts.IncludeSignatures {
+		sigTag := ref.Context().Tag(cosignSignatureTag(desc.Digest))
+		sigDesc, err := remote.Get(sigTag, options...)
+		switch {
+		case err == nil:
+			if err := appendDescriptor(p, sigDesc, map[string]string{layoutRefNameAnnotation: sigTag.Name()}); err != nil {
+				return fmt.Errorf("exporting signature of '%s' failed: %w", url, err)
+			}
+		case !isNotFound(err):
+			return fmt.Errorf("fetching signature of '%s' failed: %w", url, err)
+		}
+	}
+
+	if opts.IncludeReferrers {
+		referrers, err := c.referrers(ctx, digestRef, "")
+		if err != nil {
+			return err
+		}
+		for _, r := range referrers {
+			rDesc, err := remote.Get(ref.Context().Digest(r.Digest.String()), options...)
+			if err != nil {
+				return fmt.Errorf("fetching referrer '%s' of '%s' failed: %w", r.Digest, url, err)
+			}
+			annotations := map[string]string{layoutRepositoryAnnotation: ref.Context().Name()}
+			if err := appendDescriptor(p, rDesc, annotations); err != nil {
+				return fmt.Errorf("exporting referrer '%s' of '%s' failed: %w", r.Digest, url, err)
+			}
+		}
+	}
+	return nil
+}
+
+// appendDescriptor writes the image or index with the given descriptor to
+// the OCI image layout, and adds it to the layout index with the given
+// annotations.
+func appendDescriptor(p layout.Path, desc *remote.Descriptor, annotations map[string]string) error {
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return p.AppendIndex(idx, layout.WithAnnotations(annotations))
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return p.AppendImage(img, layout.WithAnnotations(annotations))
+}
+
+// ImportOptions holds the options for importing artifacts from an OCI image
+// layout.
+type ImportOptions struct {
+	// MaxExtractedSize is the maximum total size in bytes of the files
+	// extracted from an OCI image layout tarball. There is no limit if zero.
+	MaxExtractedSize int64
+}
+
+// Import pushes the artifacts of the OCI image layout at the given path,
+// written by Export, to the given target registry or repository prefix,
+// and returns the pushed references. The artifacts are pushed to the
+// repository with the same path under the target, for example
+// 'ghcr.io/org/app:v1' is pushed to 'registry.local/mirror/org/app:v1'
+// for the 'registry.local/mirror' target. The manifests are pushed as is,
+// so the digests of the artifacts are preserved.
+func (c *Client) Import(ctx context.Context, path, target string, opts ImportOptions) ([]string, error) {
+	dir := path
+	if isTarball(path) {
+		tmpDir, err := os.MkdirTemp("", "oci-layout")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var untarOpts []untar.Option
+		if opts.MaxExtractedSize > 0 {
+			untarOpts = append(untarOpts, untar.WithMaxUntarSize(opts.MaxExtractedSize))
+		}
+		_, err = untar.Untar(f, tmpDir, untarOpts...)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("extracting OCI layout failed: %w", err)
+		}
+		dir = tmpDir
+	}
+
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI layout: %w", err)
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout index failed: %w", err)
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout index failed: %w", err)
+	}
+
+	var refs []string
+	for _, desc := range manifest.Manifests {
+		ref, err := importReference(desc, target)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.importDescriptor(ctx, idx, desc, ref); err != nil {
+			return nil, fmt.Errorf("pushing '%s' failed: %w", ref, err)
+		}
+		refs = append(refs, ref.String())
+	}
+	return refs, nil
+}
+
+// importReference returns the reference in the target registry of the
+// artifact with the given OCI image layout index descriptor.
+func importReference(desc gcrv1.Descriptor, target string) (name.Reference, error) {
+	if refName, ok := desc.Annotations[layoutRefNameAnnotation]; ok {
+		ref, err := name.ParseReference(refName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference '%s' in OCI layout: %w", refName, err)
+		}
+		repo, err := name.NewRepository(strings.TrimSuffix(target, "/") + "/" + ref.Context().RepositoryStr())
+		if err != nil {
+			return nil, fmt.Errorf("invalid target: %w", err)
+		}
+		if _, ok := ref.(name.Digest); ok {
+			return repo.Digest(desc.Digest.String()), nil
+		}
+		return repo.Tag(ref.Identifier()), nil
+	}
+
+	if repoName, ok := desc.Annotations[layoutRepositoryAnnotation]; ok {
+		src, err := name.NewRepository(repoName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository '%s' in OCI layout: %w", repoName, err)
+		}
+		repo, err := name.NewRepository(strings.TrimSuffix(target, "/") + "/" + src.RepositoryStr())
+		if err != nil {
+			return nil, fmt.Errorf("invalid target: %w", err)
+		}
+		return repo.Digest(desc.Digest.String()), nil
+	}
+
+	return nil, fmt.Errorf("no reference found for '%s' in OCI layout", desc.Digest)
+}
+
+// importDescriptor pushes the image or index with the given descriptor of
+// the OCI image layout to the given reference.
+func (c *Client) importDescriptor(ctx context.Context, idx gcrv1.ImageIndex, desc gcrv1.Descriptor, ref name.Reference) error {
+	options := crane.GetOptions(c.optionsWithContext(ctx)...).Remote
+	if desc.MediaType.IsIndex() {
+		ii, err := idx.ImageIndex(desc.Digest)
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(ref, ii, options...)
+	}
+
+	img, err := idx.Image(desc.Digest)
+	if err != nil {
+		return err
+	}
+	return c.writeImage(ctx, ref, img)
+}
+
+// isTarball returns true if the given path is a gzip compressed tarball.
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	. "github.com/onsi/gomega"
+)
+
+func Test_Export_Import(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbom := filepath.Join(t.TempDir(), "sbom.spdx.json")
+	if err := os.WriteFile(sbom, []byte(`{"spdxVersion":"SPDX-2.3"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	url, digest := pushTestArtifact(t, c)
+	if _, err := c.Sign(ctx, url, SignOptions{Signer: key}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Attach(ctx, url, Attachment{ArtifactType: "application/spdx+json", Files: []AttachmentFile{{Path: sbom}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"layout", "layout.tar.gz"} {
+		t.Run(path, func(t *testing.T) {
+			g := NewWithT(t)
+
+			layoutPath := filepath.Join(t.TempDir(), path)
+			err := c.Export(ctx, []string{url}, layoutPath, ExportOptions{IncludeSignatures: true, IncludeReferrers: true})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			target := fmt.Sprintf("%s/%s", dockerReg, "airgap"+randStringRunes(5))
+			refs, err := c.Import(ctx, layoutPath, target, ImportOptions{MaxExtractedSize: 10 << 20})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(refs).To(HaveLen(3))
+
+			imported := target + "/" + strings.TrimPrefix(url, dockerReg+"/")
+			g.Expect(refs[0]).To(Equal(imported))
+			importedDigest, err := crane.Digest(imported)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(importedDigest).To(Equal(digest.DigestStr()))
+
+			err = c.Verify(ctx, imported, VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &key.PublicKey)}}})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			referrers, err := c.Referrers(ctx, imported, "application/spdx+json")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(referrers).To(HaveLen(1))
+
+			meta, err := c.Pull(ctx, imported, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(meta.Revision).To(Equal("rev"))
+		})
+	}
+
+	t.Run("invalid layout", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := c.Import(ctx, t.TempDir(), dockerReg, ImportOptions{})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("invalid OCI layout"))
+	})
+
+	t.Run("extracted size exceeded", func(t *testing.T) {
+		g := NewWithT(t)
+
+		layoutPath := filepath.Join(t.TempDir(), "layout.tar.gz")
+		err := c.Export(ctx, []string{url}, layoutPath, ExportOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = c.Import(ctx, layoutPath, dockerReg, ImportOptions{MaxExtractedSize: 100})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("exceeds the max untar size of 100 bytes"))
+	})
+}
@@ -26,23 +26,51 @@ import (
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
 	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/fluxcd/pkg/version"
 )
 
+// defaultListConcurrency is the default number of tags whose manifest is
+// fetched concurrently when listing an OCI repository.
+const defaultListConcurrency = 10
+
 // ListOptions contains options for listing tags from an OCI repository.
 type ListOptions struct {
 	// SemverFilter contains semver for filtering tags.
 	SemverFilter string
 	// RegexFilter contains a regex that tags will be filtered by.
 	RegexFilter string
+	// SkipMetadata skips fetching the manifests of the tags, the returned
+	// metadata only holds the URL and digest of the tags.
+	SkipMetadata bool
+	// Limit is the maximum number of returned tags, no limit if zero.
+	Limit int
+	// Concurrency is the maximum number of manifests fetched concurrently,
+	// defaults to 10.
+	Concurrency int
+	// PageSize is the number of tags requested per page from registries
+	// supporting paginated tag listing, defaults to the registry page size.
+	PageSize int
 }
 
 // List fetches the tags and their manifests for a given OCI repository.
+// The tags are returned in reverse lexical order.
 func (c *Client) List(ctx context.Context, url string, opts ListOptions) ([]Metadata, error) {
-	metas := make([]Metadata, 0)
-	tags, err := crane.ListTags(url, c.options...)
+	repo, err := name.NewRepository(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	options := crane.GetOptions(c.optionsWithContext(ctx)...).Remote
+	listOptions := options
+	if opts.PageSize > 0 {
+		listOptions = append(listOptions, remote.WithPageSize(opts.PageSize))
+	}
+	tags, err := remote.List(repo, listOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("listing tags failed: %w", err)
 	}
@@ -65,12 +93,22 @@ func (c *Client) List(ctx context.Context, url string, opts ListOptions) ([]Meta
 		}
 	}
 
+	var selected []string
 	for _, tag := range tags {
+		if opts.Limit > 0 && len(selected) >= opts.Limit {
+			break
+		}
+
 		// exclude cosign signatures
 		if strings.HasSuffix(tag, ".sig") {
 			continue
 		}
 
+		// exclude referrers indexes
+		if referrersTagRegexp.MatchString(tag) {
+			continue
+		}
+
 		if constraint != nil {
 			v, err := version.ParseVersion(tag)
 			// version isn't a valid semver so we can skip
@@ -87,33 +125,53 @@ func (c *Client) List(ctx context.Context, url string, opts ListOptions) ([]Meta
 			continue
 		}
 
-		meta := Metadata{
-			URL: fmt.Sprintf("%s:%s", url, tag),
-		}
+		selected = append(selected, tag)
+	}
 
-		manifestJSON, err := crane.Manifest(meta.URL, c.optionsWithContext(ctx)...)
-		if err != nil {
-			return nil, fmt.Errorf("fetching manifest failed: %w", err)
-		}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultListConcurrency
+	}
+	metas := make([]Metadata, len(selected))
+	// The remaining fetches are cancelled once one of them fails.
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	tagOptions := crane.GetOptions(c.optionsWithContext(gctx)...).Remote
+	for i, t := range selected {
+		meta := &metas[i]
+		meta.URL = fmt.Sprintf("%s:%s", url, t)
+		tag := repo.Tag(t)
+		g.Go(func() error {
+			if opts.SkipMetadata {
+				desc, err := remote.Head(tag, tagOptions...)
+				if err != nil {
+					return fmt.Errorf("fetching digest failed: %w", err)
+				}
+				meta.Digest = desc.Digest.String()
+				return nil
+			}
 
-		manifest, err := gcrv1.ParseManifest(bytes.NewReader(manifestJSON))
-		if err != nil {
-			return nil, fmt.Errorf("parsing manifest failed: %w", err)
-		}
+			// The digest is computed from the fetched manifest.
+			desc, err := remote.Get(tag, tagOptions...)
+			if err != nil {
+				return fmt.Errorf("fetching manifest failed: %w", err)
+			}
+			manifest, err := gcrv1.ParseManifest(bytes.NewReader(desc.Manifest))
+			if err != nil {
+				return fmt.Errorf("parsing manifest failed: %w", err)
+			}
 
-		if m, err := MetadataFromAnnotations(manifest.Annotations); err == nil {
+			m := ParseMetadata(manifest.Annotations)
 			meta.Revision = m.Revision
 			meta.Source = m.Source
 			meta.Created = m.Created
-		}
-
-		digest, err := crane.Digest(meta.URL, c.optionsWithContext(ctx)...)
-		if err != nil {
-			return nil, fmt.Errorf("fetching digest failed: %w", err)
-		}
-		meta.Digest = digest
-
-		metas = append(metas, meta)
+			meta.Annotations = m.Annotations
+			meta.Digest = desc.Digest.String()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 
 	return metas, nil
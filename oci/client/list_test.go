@@ -19,9 +19,17 @@ package client
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
@@ -106,3 +114,133 @@ func Test_List(t *testing.T) {
 		})
 	}
 }
+
+func Test_List_Pagination(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	// The registry counts the requests of each kind.
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	app := handlers.NewApp(ctx, config)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") || strings.Contains(r.URL.Path, "/manifests/") {
+			mu.Lock()
+			requests[r.Method+" "+path.Base(path.Dir(r.URL.Path))]++
+			mu.Unlock()
+		}
+		app.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	repo := fmt.Sprintf("%s/%s", strings.TrimPrefix(srv.URL, "http://"), "test-list"+randStringRunes(5))
+	m := Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev", Created: time.Now().Format(time.RFC3339)}
+	for i := 0; i < 5; i++ {
+		img, err := random.Image(1024, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		img = mutate.Annotations(img, m.ToAnnotations()).(gcrv1.Image)
+		g.Expect(crane.Push(img, fmt.Sprintf("%s:v0.0.%d", repo, i), c.options...)).To(Succeed())
+	}
+
+	tests := []struct {
+		name         string
+		opts         ListOptions
+		wantTags     []string
+		wantRequests map[string]int
+	}{
+		{
+			name:         "paginated listing",
+			opts:         ListOptions{PageSize: 2, Concurrency: 2},
+			wantTags:     []string{"v0.0.4", "v0.0.3", "v0.0.2", "v0.0.1", "v0.0.0"},
+			wantRequests: map[string]int{"GET tags": 3, "GET manifests": 5},
+		},
+		{
+			name:         "limit without metadata",
+			opts:         ListOptions{Limit: 2, SkipMetadata: true},
+			wantTags:     []string{"v0.0.4", "v0.0.3"},
+			wantRequests: map[string]int{"GET tags": 1, "HEAD manifests": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			mu.Lock()
+			requests = make(map[string]int)
+			mu.Unlock()
+
+			metas, err := c.List(ctx, repo, tt.opts)
+			g.Expect(err).ToNot(HaveOccurred())
+			mu.Lock()
+			g.Expect(requests).To(Equal(tt.wantRequests))
+			mu.Unlock()
+
+			g.Expect(metas).To(HaveLen(len(tt.wantTags)))
+			for i, meta := range metas {
+				g.Expect(meta.URL).To(Equal(fmt.Sprintf("%s:%s", repo, tt.wantTags[i])))
+				digest, err := crane.Digest(meta.URL, c.options...)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(meta.Digest).To(Equal(digest))
+				if tt.opts.SkipMetadata {
+					g.Expect(meta.Revision).To(BeEmpty())
+				} else {
+					g.Expect(meta.Revision).To(Equal("rev"))
+				}
+			}
+		})
+	}
+}
+
+func Test_List_CancelOnError(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	// The registry fails to serve the manifest of one tag, and holds the
+	// requests of the others until they are cancelled.
+	release := make(chan struct{})
+	var failing int32
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	app := handlers.NewApp(ctx, config)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 && strings.Contains(r.URL.Path, "/manifests/") {
+			if path.Base(r.URL.Path) == "v0.0.0" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		app.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	repo := fmt.Sprintf("%s/%s", strings.TrimPrefix(srv.URL, "http://"), "test-list"+randStringRunes(5))
+	for i := 0; i < 3; i++ {
+		img, err := random.Image(1024, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(crane.Push(img, fmt.Sprintf("%s:v0.0.%d", repo, i), c.options...)).To(Succeed())
+	}
+	atomic.StoreInt32(&failing, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.List(ctx, repo, ListOptions{Concurrency: 3})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("fetching manifest failed"))
+	case <-time.After(10 * time.Second):
+		t.Fatal("the pending manifest fetches were not cancelled")
+	}
+}
@@ -48,7 +48,8 @@ func (c *Client) LoginWithCredentials(credentials string) error {
 		authConfig = authn.AuthConfig{Username: parts[0], Password: parts[1]}
 	}
 
-	c.options = append(c.options, crane.WithAuth(authn.FromConfig(authConfig)))
+	c.auth = authn.FromConfig(authConfig)
+	c.options = append(c.options, crane.WithAuth(c.auth))
 	return nil
 }
 
@@ -68,7 +69,7 @@ func (c *Client) LoginWithProvider(ctx context.Context, url string, provider oci
 	case oci.ProviderGCP:
 		authenticator, err = gcp.NewClient().Login(ctx, true, url, ref)
 	case oci.ProviderAzure:
-		authenticator, err = azure.NewClient().Login(ctx, true, url, ref)
+		authenticator, err = azure.NewClient().WithTransport(c.httpTransport()).Login(ctx, true, url, ref)
 	default:
 		return errors.New(fmt.Sprintf("unsupported provider"))
 	}
@@ -77,6 +78,7 @@ func (c *Client) LoginWithProvider(ctx context.Context, url string, provider oci
 		return fmt.Errorf("could not login to provider %v with url %s: %w", provider, url, err)
 	}
 
+	c.auth = authenticator
 	c.options = append(c.options, crane.WithAuth(authenticator))
 	return nil
 }
@@ -30,41 +30,64 @@ type Metadata struct {
 	Revision string `json:"source_revision"`
 	Digest   string `json:"digest"`
 	URL      string `json:"url"`
+	// Annotations holds the annotations of the artifact other than the
+	// created, source and revision ones, for example the title, description
+	// and licenses, or custom keys.
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // ToAnnotations returns the OpenContainers annotations map.
+// The created, source and revision annotations are omitted if empty.
 func (m *Metadata) ToAnnotations() map[string]string {
-	annotations := map[string]string{
+	annotations := make(map[string]string, len(m.Annotations)+3)
+	for k, v := range m.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range map[string]string{
 		oci.CreatedAnnotation:  m.Created,
 		oci.SourceAnnotation:   m.Source,
 		oci.RevisionAnnotation: m.Revision,
+	} {
+		if v != "" {
+			annotations[k] = v
+		}
 	}
 
 	return annotations
 }
 
 // MetadataFromAnnotations parses the OpenContainers annotations and returns a Metadata object.
+// It returns an error if any of the created, source or revision annotations is missing,
+// see ParseMetadata for artifacts not pushed by Flux.
 func MetadataFromAnnotations(annotations map[string]string) (*Metadata, error) {
-	created, ok := annotations[oci.CreatedAnnotation]
-	if !ok {
-		return nil, fmt.Errorf("'%s' annotation not found", oci.CreatedAnnotation)
+	for _, key := range []string{oci.CreatedAnnotation, oci.SourceAnnotation, oci.RevisionAnnotation} {
+		if _, ok := annotations[key]; !ok {
+			return nil, fmt.Errorf("'%s' annotation not found", key)
+		}
 	}
 
-	source, ok := annotations[oci.SourceAnnotation]
-	if !ok {
-		return nil, fmt.Errorf("'%s' annotation not found", oci.SourceAnnotation)
-	}
+	return ParseMetadata(annotations), nil
+}
 
-	revision, ok := annotations[oci.RevisionAnnotation]
-	if !ok {
-		return nil, fmt.Errorf("'%s' annotation not found", oci.RevisionAnnotation)
+// ParseMetadata returns a Metadata object for the given annotations, leaving
+// the fields of missing annotations empty.
+func ParseMetadata(annotations map[string]string) *Metadata {
+	m := Metadata{
+		Created:  annotations[oci.CreatedAnnotation],
+		Source:   annotations[oci.SourceAnnotation],
+		Revision: annotations[oci.RevisionAnnotation],
 	}
 
-	m := Metadata{
-		Created:  created,
-		Source:   source,
-		Revision: revision,
+	for k, v := range annotations {
+		switch k {
+		case oci.CreatedAnnotation, oci.SourceAnnotation, oci.RevisionAnnotation:
+			continue
+		}
+		if m.Annotations == nil {
+			m.Annotations = make(map[string]string)
+		}
+		m.Annotations[k] = v
 	}
 
-	return &m, nil
+	return &m
 }
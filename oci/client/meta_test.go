@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+func TestMetadataFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *Metadata
+		wantErr     string
+	}{
+		{
+			name: "flux annotations",
+			annotations: map[string]string{
+				oci.CreatedAnnotation:  "2022-09-21T10:00:00Z",
+				oci.SourceAnnotation:   "github.com/fluxcd/flux2",
+				oci.RevisionAnnotation: "rev",
+			},
+			want: &Metadata{Created: "2022-09-21T10:00:00Z", Source: "github.com/fluxcd/flux2", Revision: "rev"},
+		},
+		{
+			name: "extended annotations",
+			annotations: map[string]string{
+				oci.CreatedAnnotation:     "2022-09-21T10:00:00Z",
+				oci.SourceAnnotation:      "github.com/fluxcd/flux2",
+				oci.RevisionAnnotation:    "rev",
+				oci.LicensesAnnotation:    "Apache-2.0",
+				"org.example.environment": "staging",
+			},
+			want: &Metadata{
+				Created:  "2022-09-21T10:00:00Z",
+				Source:   "github.com/fluxcd/flux2",
+				Revision: "rev",
+				Annotations: map[string]string{
+					oci.LicensesAnnotation:    "Apache-2.0",
+					"org.example.environment": "staging",
+				},
+			},
+		},
+		{
+			name:        "missing revision",
+			annotations: map[string]string{oci.CreatedAnnotation: "2022-09-21T10:00:00Z", oci.SourceAnnotation: "github.com/fluxcd/flux2"},
+			wantErr:     "'org.opencontainers.image.revision' annotation not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			meta, err := MetadataFromAnnotations(tt.annotations)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(meta).To(Equal(tt.want))
+			g.Expect(meta.ToAnnotations()).To(Equal(tt.annotations))
+		})
+	}
+}
+
+func TestMetadata_ToAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	meta := Metadata{
+		Source:      "github.com/fluxcd/flux2",
+		Annotations: map[string]string{oci.TitleAnnotation: "podinfo"},
+	}
+	g.Expect(meta.ToAnnotations()).To(Equal(map[string]string{
+		oci.SourceAnnotation: "github.com/fluxcd/flux2",
+		oci.TitleAnnotation:  "podinfo",
+	}))
+
+	g.Expect((&Metadata{}).ToAnnotations()).To(BeEmpty())
+}
+
+func TestParseMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	meta := ParseMetadata(map[string]string{oci.TitleAnnotation: "podinfo"})
+	g.Expect(meta.Created).To(BeEmpty())
+	g.Expect(meta.Source).To(BeEmpty())
+	g.Expect(meta.Revision).To(BeEmpty())
+	g.Expect(meta.Annotations).To(Equal(map[string]string{oci.TitleAnnotation: "podinfo"}))
+
+	g.Expect(ParseMetadata(nil)).To(Equal(&Metadata{}))
+}
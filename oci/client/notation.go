@@ -0,0 +1,610 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// notationArtifactType is the artifact type of Notation signatures.
+	notationArtifactType = "application/vnd.cncf.notary.signature"
+
+	// notationJWSMediaType is the media type of the layer of Notation
+	// signatures holding a JWS envelope.
+	notationJWSMediaType types.MediaType = "application/jose+json"
+
+	// notationPayloadContentType is the content type of the payload of
+	// Notation signature envelopes.
+	notationPayloadContentType = "application/vnd.cncf.notary.payload.v1+json"
+
+	// notationSigningScheme is the signing scheme of Notation signatures
+	// made with X.509 certificates.
+	notationSigningScheme = "notary.x509"
+
+	// notationSigningSchemeHeader is the protected header holding the
+	// signing scheme, which must be marked critical.
+	notationSigningSchemeHeader = "io.cncf.notary.signingScheme"
+
+	// notationExpiryHeader is the protected header holding the expiry of
+	// the signature, which must be marked critical if set.
+	notationExpiryHeader = "io.cncf.notary.expiry"
+
+	// notationSubjectIdentityPrefix is the prefix of trusted identities
+	// matching the subject of the signing certificate.
+	notationSubjectIdentityPrefix = "x509.subject:"
+)
+
+// Notation signature verification levels.
+const (
+	// NotationLevelStrict enforces all validations, including the expiry
+	// of the signature and the certificates at verification time.
+	NotationLevelStrict = "strict"
+	// NotationLevelPermissive enforces the integrity and authenticity of
+	// signatures, and logs the expiry of the signature and the certificates
+	// as a warning.
+	NotationLevelPermissive = "permissive"
+	// NotationLevelAudit enforces the integrity of signatures, without
+	// failing on their authenticity, and logs their expiry as a warning.
+	NotationLevelAudit = "audit"
+	// NotationLevelSkip skips signature verification.
+	NotationLevelSkip = "skip"
+)
+
+// NotationVerifyOptions holds the trust configuration for verifying
+// Notation signatures.
+type NotationVerifyOptions struct {
+	// TrustPolicy selects the verification level, trust stores and trusted
+	// identities per repository.
+	TrustPolicy *NotationTrustPolicy
+	// TrustStores holds the root certificates of the trust stores
+	// referenced by the trust policy, keyed by "<type>:<name>", for
+	// example "ca:acme-rockets".
+	TrustStores map[string][]*x509.Certificate
+}
+
+// NotationTrustPolicy is a Notation trust policy document.
+type NotationTrustPolicy struct {
+	Version       string                         `json:"version"`
+	TrustPolicies []NotationTrustPolicyStatement `json:"trustPolicies"`
+}
+
+// NotationTrustPolicyStatement is the trust policy of a set of
+// repositories.
+type NotationTrustPolicyStatement struct {
+	Name                  string                        `json:"name"`
+	RegistryScopes        []string                      `json:"registryScopes"`
+	SignatureVerification NotationSignatureVerification `json:"signatureVerification"`
+	TrustStores           []string                      `json:"trustStores,omitempty"`
+	TrustedIdentities     []string                      `json:"trustedIdentities,omitempty"`
+}
+
+// NotationSignatureVerification holds the verification level of a trust
+// policy statement.
+type NotationSignatureVerification struct {
+	Level string `json:"level"`
+}
+
+// ParseNotationTrustPolicy parses and validates the given JSON Notation
+// trust policy document.
+func ParseNotationTrustPolicy(data []byte) (*NotationTrustPolicy, error) {
+	var policy NotationTrustPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse trust policy: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate returns an error if the trust policy is invalid.
+func (p *NotationTrustPolicy) Validate() error {
+	if p.Version != "1.0" {
+		return fmt.Errorf("unsupported trust policy version '%s'", p.Version)
+	}
+	if len(p.TrustPolicies) == 0 {
+		return fmt.Errorf("trust policy has no statements")
+	}
+	names := make(map[string]struct{}, len(p.TrustPolicies))
+	scopes := make(map[string]string)
+	for _, s := range p.TrustPolicies {
+		if s.Name == "" {
+			return fmt.Errorf("trust policy statement name is required")
+		}
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("duplicate trust policy statement '%s'", s.Name)
+		}
+		names[s.Name] = struct{}{}
+
+		if len(s.RegistryScopes) == 0 {
+			return fmt.Errorf("trust policy statement '%s' has no registry scopes", s.Name)
+		}
+		for _, scope := range s.RegistryScopes {
+			if scope == "*" && len(s.RegistryScopes) > 1 {
+				return fmt.Errorf("trust policy statement '%s' wildcard registry scope must be the only scope", s.Name)
+			}
+			if other, ok := scopes[scope]; ok {
+				return fmt.Errorf("registry scope '%s' is used by trust policy statements '%s' and '%s'", scope, other, s.Name)
+			}
+			scopes[scope] = s.Name
+		}
+
+		switch s.SignatureVerification.Level {
+		case NotationLevelSkip:
+			continue
+		case NotationLevelStrict, NotationLevelPermissive, NotationLevelAudit:
+		default:
+			return fmt.Errorf("trust policy statement '%s' has invalid verification level '%s'", s.Name, s.SignatureVerification.Level)
+		}
+		if len(s.TrustStores) == 0 {
+			return fmt.Errorf("trust policy statement '%s' has no trust stores", s.Name)
+		}
+		if len(s.TrustedIdentities) == 0 {
+			return fmt.Errorf("trust policy statement '%s' has no trusted identities", s.Name)
+		}
+		for _, id := range s.TrustedIdentities {
+			if id == "*" {
+				if len(s.TrustedIdentities) > 1 {
+					return fmt.Errorf("trust policy statement '%s' wildcard trusted identity must be the only identity", s.Name)
+				}
+				continue
+			}
+			if _, err := parseDistinguishedName(id); err != nil {
+				return fmt.Errorf("trust policy statement '%s' has invalid trusted identity '%s': %w", s.Name, id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// statementFor returns the trust policy statement of the given repository,
+// preferring a statement naming the repository over a wildcard one.
+func (p *NotationTrustPolicy) statementFor(repository string) (*NotationTrustPolicyStatement, error) {
+	var wildcard *NotationTrustPolicyStatement
+	for i, s := range p.TrustPolicies {
+		for _, scope := range s.RegistryScopes {
+			switch scope {
+			case repository:
+				return &p.TrustPolicies[i], nil
+			case "*":
+				wildcard = &p.TrustPolicies[i]
+			}
+		}
+	}
+	if wildcard == nil {
+		return nil, fmt.Errorf("no trust policy statement applies to '%s'", repository)
+	}
+	return wildcard, nil
+}
+
+// jwsEnvelope is a Notation signature envelope in JWS JSON serialization.
+type jwsEnvelope struct {
+	Payload   string         `json:"payload"`
+	Protected string         `json:"protected"`
+	Header    jwsUnprotected `json:"header"`
+	Signature string         `json:"signature"`
+}
+
+type jwsUnprotected struct {
+	// CertChain holds the DER encoded signing certificate chain, the
+	// signing certificate first.
+	CertChain    [][]byte `json:"x5c"`
+	SigningAgent string   `json:"io.cncf.notary.signingAgent,omitempty"`
+}
+
+type jwsProtected struct {
+	Algorithm     string     `json:"alg"`
+	ContentType   string     `json:"cty"`
+	Critical      []string   `json:"crit"`
+	SigningScheme string     `json:"io.cncf.notary.signingScheme"`
+	SigningTime   *time.Time `json:"io.cncf.notary.signingTime,omitempty"`
+	Expiry        *time.Time `json:"io.cncf.notary.expiry,omitempty"`
+}
+
+// notationPayload is the payload of Notation signature envelopes.
+type notationPayload struct {
+	TargetArtifact gcrv1.Descriptor `json:"targetArtifact"`
+}
+
+// notationTrustError is an authenticity or expiry failure of a Notation
+// signature of which the integrity is verified. Such failures are tolerated
+// by the audit verification level.
+type notationTrustError struct {
+	err error
+}
+
+func (e *notationTrustError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notationTrustError) Unwrap() error {
+	return e.err
+}
+
+// verifyNotation verifies that the artifact with the given digest has at
+// least one Notation signature trusted by the trust policy of its
+// repository.
+func (c *Client) verifyNotation(ctx context.Context, ref name.Digest, opts *NotationVerifyOptions) error {
+	if opts.TrustPolicy == nil {
+		return fmt.Errorf("no Notation trust policy configured")
+	}
+	statement, err := opts.TrustPolicy.statementFor(ref.Context().Name())
+	if err != nil {
+		return err
+	}
+	level := statement.SignatureVerification.Level
+	if level == NotationLevelSkip {
+		return nil
+	}
+
+	return c.verifyNotationSignatures(ctx, ref, statement, opts.TrustStores)
+}
+
+func (c *Client) verifyNotationSignatures(ctx context.Context, ref name.Digest, statement *NotationTrustPolicyStatement, stores map[string][]*x509.Certificate) error {
+	roots := x509.NewCertPool()
+	for _, s := range statement.TrustStores {
+		certs, ok := stores[s]
+		if !ok {
+			return fmt.Errorf("trust store '%s' of trust policy statement '%s' not found", s, statement.Name)
+		}
+		for _, cert := range certs {
+			roots.AddCert(cert)
+		}
+	}
+
+	target, err := crane.Head(ref.String(), c.optionsWithContext(ctx)...)
+	if err != nil {
+		return fmt.Errorf("fetching descriptor of '%s' failed: %w", ref, err)
+	}
+
+	signatures, err := c.referrers(ctx, ref, notationArtifactType)
+	if err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return fmt.Errorf("no Notation signatures found for '%s'", ref)
+	}
+
+	var errs []string
+	for _, desc := range signatures {
+		err := c.verifyNotationSignature(ctx, ref.Context().Digest(desc.Digest.String()), target, statement, roots)
+		var trustErr *notationTrustError
+		if err == nil || (statement.SignatureVerification.Level == NotationLevelAudit && errors.As(err, &trustErr)) {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("no valid Notation signature found for '%s': %s", ref, strings.Join(errs, "; "))
+}
+
+// verifyNotationSignature verifies the Notation signature with the given
+// reference against the descriptor of the signed artifact.
+func (c *Client) verifyNotationSignature(ctx context.Context, sigRef name.Digest, target *gcrv1.Descriptor, statement *NotationTrustPolicyStatement, roots *x509.CertPool) error {
+	img, err := crane.Pull(sigRef.String(), c.optionsWithContext(ctx)...)
+	if err != nil {
+		return fmt.Errorf("fetching signature '%s' failed: %w", sigRef.DigestStr(), err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	var envelope []byte
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != notationJWSMediaType {
+			continue
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		envelope, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		break
+	}
+	if envelope == nil {
+		return fmt.Errorf("signature '%s' has no JWS envelope", sigRef.DigestStr())
+	}
+
+	payload, err := verifyJWSEnvelope(ctx, envelope, statement, roots, time.Now())
+	var trustErr *notationTrustError
+	if err != nil && !errors.As(err, &trustErr) {
+		return fmt.Errorf("signature '%s': %w", sigRef.DigestStr(), err)
+	}
+	if payload.TargetArtifact.Digest != target.Digest || payload.TargetArtifact.Size != target.Size ||
+		payload.TargetArtifact.MediaType != target.MediaType {
+		return fmt.Errorf("signature '%s' is for artifact '%s'", sigRef.DigestStr(), payload.TargetArtifact.Digest)
+	}
+	if err != nil {
+		return fmt.Errorf("signature '%s': %w", sigRef.DigestStr(), err)
+	}
+	return nil
+}
+
+// verifyJWSEnvelope verifies the given JWS envelope according to the trust
+// policy statement, and returns the signed payload. Once the integrity of
+// the envelope is verified, the payload is returned along with a
+// notationTrustError if the authenticity or expiry verification fails.
+func verifyJWSEnvelope(ctx context.Context, data []byte, statement *NotationTrustPolicyStatement, roots *x509.CertPool, now time.Time) (*notationPayload, error) {
+	var env jwsEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("parsing envelope failed: %w", err)
+	}
+	protectedJSON, err := base64.RawURLEncoding.DecodeString(env.Protected)
+	if err != nil {
+		return nil, fmt.Errorf("decoding protected header failed: %w", err)
+	}
+	var protected jwsProtected
+	if err := json.Unmarshal(protectedJSON, &protected); err != nil {
+		return nil, fmt.Errorf("parsing protected header failed: %w", err)
+	}
+	if protected.ContentType != notationPayloadContentType {
+		return nil, fmt.Errorf("unsupported payload content type '%s'", protected.ContentType)
+	}
+	if protected.SigningScheme != notationSigningScheme {
+		return nil, fmt.Errorf("unsupported signing scheme '%s'", protected.SigningScheme)
+	}
+	if !containsString(protected.Critical, notationSigningSchemeHeader) {
+		return nil, fmt.Errorf("protected header '%s' is not marked critical", notationSigningSchemeHeader)
+	}
+	if protected.Expiry != nil && !containsString(protected.Critical, notationExpiryHeader) {
+		return nil, fmt.Errorf("protected header '%s' is not marked critical", notationExpiryHeader)
+	}
+	for _, h := range protected.Critical {
+		switch h {
+		case notationSigningSchemeHeader, notationExpiryHeader:
+		default:
+			return nil, fmt.Errorf("unsupported critical header '%s'", h)
+		}
+	}
+
+	if len(env.Header.CertChain) == 0 {
+		return nil, fmt.Errorf("no signing certificate found")
+	}
+	chain := make([]*x509.Certificate, 0, len(env.Header.CertChain))
+	for _, der := range env.Header.CertChain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate chain failed: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	leaf := chain[0]
+
+	sig, err := base64.RawURLEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature failed: %w", err)
+	}
+	if err := verifyJWSSignature(protected.Algorithm, leaf.PublicKey, []byte(env.Protected+"."+env.Payload), sig); err != nil {
+		return nil, err
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decoding payload failed: %w", err)
+	}
+	var payload notationPayload
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return nil, fmt.Errorf("parsing payload failed: %w", err)
+	}
+
+	if err := verifyNotationTrust(ctx, protected, chain, statement, roots, now); err != nil {
+		return &payload, &notationTrustError{err: err}
+	}
+	return &payload, nil
+}
+
+// verifyNotationTrust verifies the authenticity and expiry of a Notation
+// signature made with the given certificate chain, according to the trust
+// policy statement.
+//
+// The certificate chain is verified at the given time, as the signing time
+// is claimed by the signer and not authenticated by the x509 signing
+// scheme. Only the strict level fails on the expiry of the signature or of
+// the certificates, the other levels log it as a warning.
+func verifyNotationTrust(ctx context.Context, protected jwsProtected, chain []*x509.Certificate, statement *NotationTrustPolicyStatement, roots *x509.CertPool, now time.Time) error {
+	strict := statement.SignatureVerification.Level == NotationLevelStrict
+	if protected.Expiry != nil && now.After(*protected.Expiry) {
+		err := fmt.Errorf("signature expired on %s", protected.Expiry.Format(time.RFC3339))
+		if strict {
+			return err
+		}
+		ctrl.LoggerFrom(ctx).Info("warning: Notation " + err.Error())
+	}
+
+	leaf := chain[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	verifyOpts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	_, err := leaf.Verify(verifyOpts)
+	var certErr x509.CertificateInvalidError
+	if err != nil && !strict && errors.As(err, &certErr) && certErr.Reason == x509.Expired {
+		// The validity errors are also returned for the certificates which
+		// are not valid yet, which are not downgraded.
+		if notAfter, ok := chainExpiry(chain, now); ok {
+			ctrl.LoggerFrom(ctx).Info("warning: Notation signing certificate expired on " + notAfter.Format(time.RFC3339))
+			// The rest of the chain is verified at the time at which all
+			// the certificates of the chain were last valid.
+			verifyOpts.CurrentTime = notAfter
+			_, err = leaf.Verify(verifyOpts)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+	if !trustedIdentity(statement.TrustedIdentities, leaf.Subject) {
+		return fmt.Errorf("signing certificate subject '%s' is not trusted", leaf.Subject)
+	}
+	return nil
+}
+
+// chainExpiry returns the earliest expiry of the certificates of the given
+// chain, and whether it is before the given time while all the certificates
+// were already valid at that time.
+func chainExpiry(chain []*x509.Certificate, now time.Time) (time.Time, bool) {
+	notAfter := chain[0].NotAfter
+	for _, cert := range chain {
+		if now.Before(cert.NotBefore) {
+			return time.Time{}, false
+		}
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	return notAfter, now.After(notAfter)
+}
+
+// verifyJWSSignature verifies the JWS signature of the signing input with
+// the given public key and JWS algorithm.
+func verifyJWSSignature(alg string, pub crypto.PublicKey, signingInput, sig []byte) error {
+	var hash crypto.Hash
+	var curve elliptic.Curve
+	switch alg {
+	case "ES256", "PS256":
+		hash, curve = crypto.SHA256, elliptic.P256()
+	case "ES384", "PS384":
+		hash, curve = crypto.SHA384, elliptic.P384()
+	case "ES512", "PS512":
+		hash, curve = crypto.SHA512, elliptic.P521()
+	default:
+		return fmt.Errorf("unsupported signature algorithm '%s'", alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") || k.Curve != curve {
+			return fmt.Errorf("signature algorithm '%s' does not match ECDSA key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "PS") {
+			return fmt.Errorf("signature algorithm '%s' does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("invalid RSA signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// trustedIdentity returns true if the subject matches one of the trusted
+// identities.
+func trustedIdentity(identities []string, subject pkix.Name) bool {
+	for _, id := range identities {
+		if id == "*" {
+			return true
+		}
+		attrs, err := parseDistinguishedName(id)
+		if err != nil {
+			continue
+		}
+		if matchDistinguishedName(attrs, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDistinguishedName parses the attributes of the distinguished name
+// of an "x509.subject: C=US, O=..." trusted identity.
+func parseDistinguishedName(identity string) (map[string]string, error) {
+	if !strings.HasPrefix(identity, notationSubjectIdentityPrefix) {
+		return nil, fmt.Errorf("identity must start with '%s'", notationSubjectIdentityPrefix)
+	}
+	attrs := make(map[string]string)
+	for _, rdn := range strings.Split(strings.TrimPrefix(identity, notationSubjectIdentityPrefix), ",") {
+		kv := strings.SplitN(strings.TrimSpace(rdn), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid attribute '%s'", rdn)
+		}
+		attrs[kv[0]] = kv[1]
+	}
+	return attrs, nil
+}
+
+// matchDistinguishedName returns true if the subject has all the given
+// attributes.
+func matchDistinguishedName(attrs map[string]string, subject pkix.Name) bool {
+	for k, v := range attrs {
+		var values []string
+		switch k {
+		case "C":
+			values = subject.Country
+		case "ST":
+			values = subject.Province
+		case "L":
+			values = subject.Locality
+		case "O":
+			values = subject.Organization
+		case "OU":
+			values = subject.OrganizationalUnit
+		case "CN":
+			values = []string{subject.CommonName}
+		}
+		if !containsString(values, v) {
+			return false
+		}
+	}
+	return true
+}
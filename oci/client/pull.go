@@ -17,59 +17,419 @@ limitations under the License.
 package client
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/fluxcd/pkg/sourceignore"
 	"github.com/fluxcd/pkg/untar"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/fluxcd/pkg/oci"
 )
 
+// PullOptions holds the options used to select the layer extracted on
+// pull, and to limit its extraction.
+type PullOptions struct {
+	// LayerName selects the layer with the given name, as pushed with
+	// PushLayers.
+	LayerName string
+	// LayerMediaType selects the first layer with the given media type.
+	// Defaults to oci.CanonicalContentMediaType, unless LayerName is set
+	// in which case any media type is accepted.
+	LayerMediaType types.MediaType
+	// Verify configures the verification of the signatures of the
+	// artifact, before its content is extracted.
+	Verify *VerifyOptions
+	// MaxArtifactSize is the maximum size in bytes of the compressed layer
+	// downloaded from the registry. There is no limit if zero.
+	MaxArtifactSize int64
+	// MaxExtractedSize is the maximum total size in bytes of the files
+	// extracted from the layer. There is no limit if zero.
+	MaxExtractedSize int64
+	// IncludePaths are the paths extracted from the layer, in .gitignore
+	// format relative to the root of the layer. All the paths are extracted
+	// if empty.
+	IncludePaths []string
+	// ExcludePaths are the paths excluded from the extraction, in
+	// .gitignore format.
+	ExcludePaths []string
+}
+
 // Pull downloads an artifact from an OCI repository and extracts the content to the given directory.
+// The content layer is selected by the oci.CanonicalContentMediaType, or for artifacts pushed
+// without Flux media types, is the first layer.
 func (c *Client) Pull(ctx context.Context, url, outDir string) (*Metadata, error) {
+	return c.PullWithOptions(ctx, url, outDir, PullOptions{})
+}
+
+// PullWithOptions downloads an artifact from an OCI repository and extracts the content of the
+// layer selected with the given options to the given directory. The layer is streamed from the
+// registry to the directory within the configured size limits, and its digest is verified once
+// fully read, so the content extracted from an invalid layer must be discarded on error.
+// When the client has a cache,
+// artifacts pulled by digest are extracted from the cache without contacting the registry,
+// unless their signatures are verified. Cached manifests and layers which do not match their
+// digest are removed from the cache and fetched again from the registry.
+func (c *Client) PullWithOptions(ctx context.Context, url, outDir string, opts PullOptions) (*Metadata, error) {
 	ref, err := name.ParseReference(url)
 	if err != nil {
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
-	img, err := crane.Pull(url, c.optionsWithContext(ctx)...)
+	// Tags are resolved to the digest looked up in the cache.
+	if _, ok := ref.(name.Digest); !ok && c.cache != nil {
+		digest, err := crane.Digest(url, c.optionsWithContext(ctx)...)
+		if err != nil {
+			return nil, fmt.Errorf("fetching digest failed: %w", err)
+		}
+		ref = ref.Context().Digest(digest)
+	}
+	return c.pull(ctx, ref, outDir, opts)
+}
+
+// PullIfChanged pulls the artifact with the given URL like PullWithOptions, unless the digest of
+// the artifact equals the given known digest, in which case it returns false without pulling.
+// The known digest can either be a digest or a digest reference, as returned in Metadata.Digest.
+func (c *Client) PullIfChanged(ctx context.Context, url, outDir, knownDigest string, opts PullOptions) (*Metadata, bool, error) {
+	ref, err := name.ParseReference(url)
 	if err != nil {
-		return nil, err
+		return nil, false, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	digest, err := crane.Digest(url, c.optionsWithContext(ctx)...)
+	if err != nil {
+		return nil, false, fmt.Errorf("fetching digest failed: %w", err)
+	}
+	if known := knownDigest[strings.LastIndex(knownDigest, "@")+1:]; known == digest {
+		return nil, false, nil
+	}
+
+	// The artifact is pulled by digest, so that it matches the compared
+	// digest even if the tag is updated in between.
+	meta, err := c.pull(ctx, ref.Context().Digest(digest), outDir, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	return meta, true, nil
+}
+
+// pull downloads the artifact with the given reference and extracts the content of the selected
+// layer to the given directory, using the cache for references by digest.
+func (c *Client) pull(ctx context.Context, ref name.Reference, outDir string, opts PullOptions) (*Metadata, error) {
+	var img gcrv1.Image
+	var rawManifest []byte
+	if d, ok := ref.(name.Digest); ok && c.cache != nil {
+		digest, err := gcrv1.NewHash(d.DigestStr())
+		if err != nil {
+			return nil, err
+		}
+		if rawManifest, _, err = c.cache.Get(digest); err != nil {
+			return nil, fmt.Errorf("reading manifest from cache failed: %w", err)
+		}
+		// A cached manifest not matching its digest is removed from the
+		// cache and fetched again from the registry.
+		if rawManifest != nil {
+			if actual, _, err := gcrv1.SHA256(bytes.NewReader(rawManifest)); err != nil || actual != digest {
+				if err := c.cache.Remove(digest); err != nil {
+					return nil, err
+				}
+				rawManifest = nil
+			}
+		}
+	}
+
+	if rawManifest == nil {
+		var err error
+		if img, err = crane.Pull(ref.String(), c.optionsWithContext(ctx)...); err != nil {
+			return nil, err
+		}
+		if rawManifest, err = img.RawManifest(); err != nil {
+			return nil, fmt.Errorf("fetching manifest failed: %w", err)
+		}
 	}
 
-	digest, err := img.Digest()
+	digest, _, err := gcrv1.SHA256(bytes.NewReader(rawManifest))
 	if err != nil {
 		return nil, fmt.Errorf("parsing digest failed: %w", err)
 	}
+	if img != nil && c.cache != nil {
+		if err := c.cache.Put(digest, bytes.NewReader(rawManifest)); err != nil {
+			return nil, err
+		}
+	}
 
-	manifest, err := img.Manifest()
+	manifest, err := gcrv1.ParseManifest(bytes.NewReader(rawManifest))
 	if err != nil {
 		return nil, fmt.Errorf("parsing manifest failed: %w", err)
 	}
 
-	meta, err := MetadataFromAnnotations(manifest.Annotations)
+	meta := ParseMetadata(manifest.Annotations)
+	digestRef := ref.Context().Digest(digest.String())
+	meta.Digest = digestRef.String()
+
+	if opts.Verify != nil {
+		if err := c.verify(ctx, digestRef, *opts.Verify); err != nil {
+			return nil, err
+		}
+	}
+
+	desc, err := selectLayer(manifest, opts)
 	if err != nil {
 		return nil, err
 	}
-	meta.Digest = ref.Context().Digest(digest.String()).String()
+	if opts.MaxArtifactSize > 0 && desc.Size > opts.MaxArtifactSize {
+		return nil, fmt.Errorf("layer size %d exceeds the max artifact size of %d bytes", desc.Size, opts.MaxArtifactSize)
+	}
 
-	layers, err := img.Layers()
+	err = c.pullLayer(ctx, img, digestRef, desc, outDir, opts)
+	// A cached layer not matching its digest is removed from the cache and
+	// downloaded again from the registry.
+	if errors.Is(err, errLayerDigestMismatch) && c.cache != nil {
+		if err := c.cache.Remove(desc.Digest); err != nil {
+			return nil, err
+		}
+		err = c.pullLayer(ctx, img, digestRef, desc, outDir, opts)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to list layers: %w", err)
+		return nil, err
 	}
 
-	if len(layers) < 1 {
-		return nil, fmt.Errorf("no layers found in artifact")
+	return meta, nil
+}
+
+// pullLayer extracts the content of the layer with the given descriptor to
+// the given directory, after verifying its digest.
+func (c *Client) pullLayer(ctx context.Context, img gcrv1.Image, ref name.Digest, desc *gcrv1.Descriptor, outDir string, opts PullOptions) error {
+	blob, err := c.openLayer(ctx, img, ref, desc)
+	if err != nil {
+		return fmt.Errorf("extracting layer failed: %w", err)
+	}
+	defer blob.Close()
+
+	r, err := newLayerReader(blob, desc.Digest, opts.MaxArtifactSize)
+	if err != nil {
+		return err
 	}
+	return extractLayer(r, outDir, untarOptions(opts))
+}
 
-	blob, err := layers[0].Compressed()
+// extractLayer extracts the layer read by r into a temporary directory next
+// to outDir, and moves the extracted files into outDir once the digest of
+// the layer is verified, so that no tampered or partial content is left in
+// outDir on failure.
+func extractLayer(r *layerReader, outDir string, opts []untar.Option) error {
+	parent := filepath.Dir(filepath.Clean(outDir))
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(parent, "."+filepath.Base(outDir)+"-")
 	if err != nil {
-		return nil, fmt.Errorf("extracting first layer failed: %w", err)
+		return err
 	}
+	defer os.RemoveAll(tmpDir)
 
-	if _, err = untar.Untar(blob, outDir); err != nil {
-		return nil, fmt.Errorf("failed to untar first layer: %w", err)
+	if _, err = untar.Untar(r, tmpDir, opts...); err != nil {
+		// A layer which can't be extracted is reported as such only if it
+		// matches its digest.
+		if verr := r.verify(); errors.Is(verr, errLayerDigestMismatch) {
+			return verr
+		}
+		return fmt.Errorf("failed to untar layer: %w", err)
 	}
+	if err := r.verify(); err != nil {
+		return err
+	}
+	return moveDir(tmpDir, outDir)
+}
 
-	return meta, nil
+// moveDir moves the content of the src directory into the dst directory,
+// merging it with the existing directories and replacing the existing
+// files.
+func moveDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		srcPath, dstPath := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		if e.IsDir() {
+			if fi, err := os.Stat(dstPath); err == nil && fi.IsDir() {
+				if err := moveDir(srcPath, dstPath); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := os.Rename(srcPath, dstPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untarOptions returns the options for extracting the layer within the
+// size limit and path filters of the given pull options.
+func untarOptions(opts PullOptions) []untar.Option {
+	var untarOpts []untar.Option
+	if opts.MaxExtractedSize > 0 {
+		untarOpts = append(untarOpts, untar.WithMaxUntarSize(opts.MaxExtractedSize))
+	}
+	if len(opts.IncludePaths) == 0 && len(opts.ExcludePaths) == 0 {
+		return untarOpts
+	}
+
+	var include, exclude gitignore.Matcher
+	if len(opts.IncludePaths) > 0 {
+		include = sourceignore.NewMatcher(sourceignore.ReadPatterns(strings.NewReader(strings.Join(opts.IncludePaths, "\n")), nil))
+	}
+	if len(opts.ExcludePaths) > 0 {
+		exclude = sourceignore.NewMatcher(sourceignore.ReadPatterns(strings.NewReader(strings.Join(opts.ExcludePaths, "\n")), nil))
+	}
+	skip := func(name string, isDir bool) bool {
+		parts := strings.Split(name, "/")
+		if exclude != nil && exclude.Match(parts, isDir) {
+			return true
+		}
+		// The directories are created with the included files.
+		return include != nil && (isDir || !include.Match(parts, isDir))
+	}
+	return append(untarOpts, untar.WithSkipFunc(skip))
+}
+
+// errLayerDigestMismatch is returned when the content of a layer does not
+// match its digest.
+var errLayerDigestMismatch = errors.New("layer digest mismatch")
+
+// layerReader reads the compressed content of a layer within the max
+// artifact size, and verifies its digest once fully read.
+type layerReader struct {
+	r       io.Reader
+	hasher  hash.Hash
+	digest  gcrv1.Hash
+	size    int64
+	maxSize int64
+}
+
+// newLayerReader returns a reader of the layer with the given digest, which
+// fails if the layer is larger than the given max size, unless zero.
+func newLayerReader(r io.Reader, digest gcrv1.Hash, maxSize int64) (*layerReader, error) {
+	hasher, err := gcrv1.Hasher(digest.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &layerReader{
+		r:       io.TeeReader(r, hasher),
+		hasher:  hasher,
+		digest:  digest,
+		maxSize: maxSize,
+	}, nil
+}
+
+// Read implements io.Reader.
+func (l *layerReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.size += int64(n)
+	if l.maxSize > 0 && l.size > l.maxSize {
+		return n, fmt.Errorf("layer size exceeds the max artifact size of %d bytes", l.maxSize)
+	}
+	return n, err
+}
+
+// verify reads the rest of the layer and verifies its digest.
+func (l *layerReader) verify() error {
+	if _, err := io.Copy(io.Discard, l); err != nil {
+		return fmt.Errorf("reading layer failed: %w", err)
+	}
+	actual := gcrv1.Hash{Algorithm: l.digest.Algorithm, Hex: hex.EncodeToString(l.hasher.Sum(nil))}
+	if actual != l.digest {
+		return fmt.Errorf("%w, expected '%s', got '%s'", errLayerDigestMismatch, l.digest, actual)
+	}
+	return nil
+}
+
+// openLayer returns a reader for the compressed content of the layer with the given descriptor,
+// of the artifact with the given digest. Layers are read from the cache if set, after being
+// downloaded to the cache if missing.
+func (c *Client) openLayer(ctx context.Context, img gcrv1.Image, ref name.Digest, desc *gcrv1.Descriptor) (io.ReadCloser, error) {
+	if c.cache == nil {
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return layer.Compressed()
+	}
+
+	if rc, ok, err := c.cache.Open(desc.Digest); err != nil || ok {
+		return rc, err
+	}
+	layer, err := remote.Layer(ref.Context().Digest(desc.Digest.String()), crane.GetOptions(c.optionsWithContext(ctx)...).Remote...)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	err = c.cache.Put(desc.Digest, rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	rc, ok, err := c.cache.Open(desc.Digest)
+	if err == nil && !ok {
+		err = fmt.Errorf("layer '%s' evicted from cache", desc.Digest)
+	}
+	return rc, err
+}
+
+// selectLayer returns the descriptor of the layer of the manifest matching the given options.
+func selectLayer(manifest *gcrv1.Manifest, opts PullOptions) (*gcrv1.Descriptor, error) {
+	if len(manifest.Layers) < 1 {
+		return nil, fmt.Errorf("no layers found in artifact")
+	}
+
+	mediaType := opts.LayerMediaType
+	if mediaType == "" && opts.LayerName == "" {
+		mediaType = oci.CanonicalContentMediaType
+	}
+	for i, desc := range manifest.Layers {
+		if opts.LayerName != "" && desc.Annotations[oci.TitleAnnotation] != opts.LayerName {
+			continue
+		}
+		if mediaType != "" && desc.MediaType != mediaType {
+			continue
+		}
+		return &manifest.Layers[i], nil
+	}
+
+	// Artifacts pushed without the Flux media types hold the content in
+	// their first layer.
+	if opts.LayerName == "" && opts.LayerMediaType == "" && manifest.Config.MediaType != oci.CanonicalConfigMediaType {
+		return &manifest.Layers[0], nil
+	}
+
+	switch {
+	case opts.LayerName != "" && opts.LayerMediaType != "":
+		return nil, fmt.Errorf("no layer found in artifact with name '%s' and media type '%s'", opts.LayerName, opts.LayerMediaType)
+	case opts.LayerName != "":
+		return nil, fmt.Errorf("no layer found in artifact with name '%s'", opts.LayerName)
+	default:
+		return nil, fmt.Errorf("no layer found in artifact with media type '%s'", mediaType)
+	}
 }
@@ -28,45 +28,176 @@ import (
 	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/fluxcd/pkg/oci"
 )
 
+// Layer is a layer of a multi-layer artifact.
+type Layer struct {
+	// Name of the layer, stored as the title annotation of the layer and
+	// used to select the layer on pull. Must be unique within an artifact.
+	Name string
+	// SourceDir is the directory archived as layer content.
+	SourceDir string
+	// IgnorePaths are the paths of SourceDir excluded from the archive,
+	// in .gitignore format.
+	IgnorePaths []string
+	// MediaType of the layer, defaults to oci.CanonicalContentMediaType.
+	MediaType types.MediaType
+}
+
 // Push creates an artifact from the given directory, uploads the artifact
 // to the given OCI repository and returns the digest.
 func (c *Client) Push(ctx context.Context, url, sourceDir string, meta Metadata, ignorePaths []string) (string, error) {
+	return c.PushLayers(ctx, url, []Layer{{SourceDir: sourceDir, IgnorePaths: ignorePaths}}, meta)
+}
+
+// PushLayers creates an artifact with a layer for each of the given
+// layers, uploads the artifact to the given OCI repository and returns the
+// digest. The config of the artifact has the oci.CanonicalConfigMediaType.
+func (c *Client) PushLayers(ctx context.Context, url string, layers []Layer, meta Metadata) (string, error) {
+	res, err := c.PushWithOptions(ctx, url, layers, meta, PushOptions{})
+	if err != nil {
+		return "", err
+	}
+	return res.Digest, nil
+}
+
+// PushOptions holds the options for pushing artifacts.
+type PushOptions struct {
+	// Sign configures the signing of the pushed artifact.
+	Sign *SignOptions
+	// Reproducible builds the layers in reproducible mode and derives the
+	// created annotation from RevisionTime, so that pushing the same content
+	// produces the same digest. When the artifact is already in the
+	// repository under the given tag, it is not pushed again, and only
+	// signed if it has no cosign signature yet.
+	Reproducible bool
+	// RevisionTime is the time of the source revision, required in
+	// reproducible mode.
+	RevisionTime time.Time
+}
+
+// PushResult holds the digests of a pushed artifact.
+type PushResult struct {
+	// Digest is the digest reference of the artifact.
+	Digest string
+	// SignatureDigest is the digest reference of the signature of the
+	// artifact, if signed. For unchanged artifacts, it is the digest
+	// reference of their existing signature.
+	SignatureDigest string
+	// Unchanged is true if the artifact was already in the repository, in
+	// reproducible mode.
+	Unchanged bool
+}
+
+// PushWithOptions creates an artifact with a layer for each of the given
+// layers, uploads the artifact to the given OCI repository and signs it if
+// configured.
+func (c *Client) PushWithOptions(ctx context.Context, url string, layers []Layer, meta Metadata, opts PushOptions) (*PushResult, error) {
 	ref, err := name.ParseReference(url)
 	if err != nil {
-		return "", fmt.Errorf("invalid URL: %w", err)
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("at least one layer is required")
+	}
+	if opts.Sign != nil && opts.Sign.Signer == nil {
+		return nil, fmt.Errorf("no signer configured")
+	}
+	if opts.Reproducible && opts.RevisionTime.IsZero() {
+		return nil, fmt.Errorf("revision time is required for reproducible builds")
 	}
 
 	tmpDir, err := os.MkdirTemp("", "oci")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer os.RemoveAll(tmpDir)
 
-	tmpFile := filepath.Join(tmpDir, "artifact.tgz")
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, oci.CanonicalConfigMediaType)
 
-	if err := c.Build(tmpFile, sourceDir, ignorePaths); err != nil {
-		return "", err
-	}
+	names := make(map[string]struct{}, len(layers))
+	for i, l := range layers {
+		if l.Name != "" {
+			if _, ok := names[l.Name]; ok {
+				return nil, fmt.Errorf("duplicate layer name '%s'", l.Name)
+			}
+			names[l.Name] = struct{}{}
+		}
 
-	img, err := crane.Append(empty.Image, tmpFile)
-	if err != nil {
-		return "", fmt.Errorf("appeding content to artifact failed: %w", err)
+		tmpFile := filepath.Join(tmpDir, fmt.Sprintf("layer-%d.tgz", i))
+		buildOpts := BuildOptions{
+			IgnorePaths:  l.IgnorePaths,
+			Reproducible: opts.Reproducible,
+			ModTime:      opts.RevisionTime,
+		}
+		if err := c.BuildWithOptions(tmpFile, l.SourceDir, buildOpts); err != nil {
+			return nil, err
+		}
+
+		mediaType := l.MediaType
+		if mediaType == "" {
+			mediaType = oci.CanonicalContentMediaType
+		}
+		layer, err := tarball.LayerFromFile(tmpFile, tarball.WithMediaType(mediaType))
+		if err != nil {
+			return nil, fmt.Errorf("creating content layer failed: %w", err)
+		}
+
+		addendum := mutate.Addendum{Layer: layer}
+		if l.Name != "" {
+			addendum.Annotations = map[string]string{oci.TitleAnnotation: l.Name}
+		}
+		img, err = mutate.Append(img, addendum)
+		if err != nil {
+			return nil, fmt.Errorf("appending content to artifact failed: %w", err)
+		}
 	}
 
 	ct := time.Now()
+	if opts.Reproducible {
+		ct = opts.RevisionTime.UTC()
+	}
 	meta.Created = ct.Format(time.RFC3339)
 	img = mutate.Annotations(img, meta.ToAnnotations()).(gcrv1.Image)
 
-	if err := crane.Push(img, url, c.optionsWithContext(ctx)...); err != nil {
-		return "", fmt.Errorf("pushing artifact failed: %w", err)
-	}
-
 	digest, err := img.Digest()
 	if err != nil {
-		return "", fmt.Errorf("parsing artifact digest failed: %w", err)
+		return nil, fmt.Errorf("parsing artifact digest failed: %w", err)
+	}
+	digestRef := ref.Context().Digest(digest.String())
+	res := &PushResult{Digest: digestRef.String()}
+
+	if opts.Reproducible {
+		if remoteDigest, err := crane.Digest(url, c.optionsWithContext(ctx)...); err == nil && remoteDigest == digest.String() {
+			res.Unchanged = true
+		}
+	}
+
+	if !res.Unchanged {
+		if err := crane.Push(img, url, c.optionsWithContext(ctx)...); err != nil {
+			return nil, fmt.Errorf("pushing artifact failed: %w", err)
+		}
 	}
 
-	return ref.Context().Digest(digest.String()).String(), err
+	if opts.Sign != nil {
+		// An unchanged artifact is only signed if it has no signature yet,
+		// as a previous push may have failed to sign it.
+		if res.Unchanged {
+			if res.SignatureDigest, err = c.signatureDigest(ctx, digestRef); err != nil {
+				return nil, err
+			}
+		}
+		if res.SignatureDigest == "" {
+			if res.SignatureDigest, err = c.sign(ctx, digestRef, *opts.Sign); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
 }
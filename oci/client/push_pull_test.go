@@ -17,14 +17,22 @@ limitations under the License.
 package client
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/crane"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	. "github.com/onsi/gomega"
 
 	"github.com/fluxcd/pkg/oci"
@@ -60,6 +68,9 @@ func Test_Push_Pull(t *testing.T) {
 	g.Expect(manifest.Annotations[oci.CreatedAnnotation]).ToNot(BeEmpty())
 	g.Expect(manifest.Annotations[oci.SourceAnnotation]).ToNot(BeEmpty())
 	g.Expect(manifest.Annotations[oci.RevisionAnnotation]).ToNot(BeEmpty())
+	g.Expect(manifest.Config.MediaType).To(Equal(oci.CanonicalConfigMediaType))
+	g.Expect(manifest.Layers).To(HaveLen(1))
+	g.Expect(manifest.Layers[0].MediaType).To(Equal(oci.CanonicalContentMediaType))
 
 	tmpDir := t.TempDir()
 	_, err = c.Pull(ctx, url, tmpDir)
@@ -75,3 +86,397 @@ func Test_Push_Pull(t *testing.T) {
 		return nil
 	})
 }
+
+func Test_PushLayers_PullWithOptions(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+	repo := "test-push-layers" + randStringRunes(5)
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, repo)
+
+	layers := []Layer{
+		{Name: "manifests", SourceDir: "testdata/artifact/deploy"},
+		{Name: "config", SourceDir: "testdata/artifact/somedir", MediaType: "application/vnd.example.config.tar+gzip"},
+	}
+	_, err := c.PushLayers(ctx, url, layers, Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	image, err := crane.Pull(url)
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := image.Manifest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifest.Config.MediaType).To(Equal(oci.CanonicalConfigMediaType))
+	g.Expect(manifest.Layers).To(HaveLen(2))
+	g.Expect(manifest.Layers[0].MediaType).To(Equal(oci.CanonicalContentMediaType))
+	g.Expect(manifest.Layers[0].Annotations[oci.TitleAnnotation]).To(Equal("manifests"))
+	g.Expect(string(manifest.Layers[1].MediaType)).To(Equal("application/vnd.example.config.tar+gzip"))
+	g.Expect(manifest.Layers[1].Annotations[oci.TitleAnnotation]).To(Equal("config"))
+
+	tests := []struct {
+		name     string
+		opts     PullOptions
+		wantFile string
+		wantErr  string
+	}{
+		{
+			name:     "default media type",
+			wantFile: "testdata/artifact/deploy/repo.yaml",
+		},
+		{
+			name:     "layer name",
+			opts:     PullOptions{LayerName: "config"},
+			wantFile: "testdata/artifact/somedir/git/repo.yaml",
+		},
+		{
+			name:     "layer media type",
+			opts:     PullOptions{LayerMediaType: "application/vnd.example.config.tar+gzip"},
+			wantFile: "testdata/artifact/somedir/git/repo.yaml",
+		},
+		{
+			name:    "layer name and mismatching media type",
+			opts:    PullOptions{LayerName: "config", LayerMediaType: oci.CanonicalContentMediaType},
+			wantErr: "no layer found in artifact with name 'config' and media type",
+		},
+		{
+			name:    "unknown layer name",
+			opts:    PullOptions{LayerName: "unknown"},
+			wantErr: "no layer found in artifact with name 'unknown'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			parentDir := t.TempDir()
+			tmpDir := filepath.Join(parentDir, "out")
+			_, err := c.PullWithOptions(ctx, url, tmpDir, tt.opts)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				// Nothing is extracted and no temporary directory is left behind.
+				entries, err := os.ReadDir(parentDir)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(entries).To(BeEmpty())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(filepath.Join(tmpDir, tt.wantFile)).To(BeAnExistingFile())
+		})
+	}
+
+	t.Run("duplicate layer names", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := c.PushLayers(ctx, url, []Layer{
+			{Name: "manifests", SourceDir: "testdata/artifact/deploy"},
+			{Name: "manifests", SourceDir: "testdata/artifact/somedir"},
+		}, Metadata{})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("duplicate layer name"))
+	})
+}
+
+func Test_PushWithOptions_Reproducible(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-reproducible"+randStringRunes(5))
+	meta := Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}
+	revisionTime := time.Date(2022, 9, 21, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	opts := PushOptions{Reproducible: true, RevisionTime: revisionTime}
+
+	first, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, meta, opts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(first.Unchanged).To(BeFalse())
+
+	second, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, meta, opts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(second.Unchanged).To(BeTrue())
+	g.Expect(second.Digest).To(Equal(first.Digest))
+
+	pulled, err := c.Pull(ctx, url, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pulled.Created).To(Equal("2022-09-21T10:00:00Z"))
+
+	_, err = c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, meta, PushOptions{Reproducible: true})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("revision time is required"))
+}
+
+func Test_Push_Pull_Annotations(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-annotations"+randStringRunes(5))
+
+	annotations := map[string]string{
+		oci.TitleAnnotation:       "podinfo",
+		oci.DescriptionAnnotation: "Podinfo manifests",
+		oci.LicensesAnnotation:    "Apache-2.0",
+		"org.example.environment": "staging",
+	}
+	_, err := c.Push(ctx, url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev", Annotations: annotations}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	meta, err := c.Pull(ctx, url, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(meta.Revision).To(Equal("rev"))
+	g.Expect(meta.Annotations).To(Equal(annotations))
+
+	metas, err := c.List(ctx, strings.TrimSuffix(url, ":v0.0.1"), ListOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(metas).To(HaveLen(1))
+	g.Expect(metas[0].Annotations).To(Equal(annotations))
+}
+
+func Test_Pull_ThirdPartyArtifact(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-third-party"+randStringRunes(5))
+
+	// Artifacts pushed by other tools may lack the Flux annotations.
+	tgz := filepath.Join(t.TempDir(), "artifact.tgz")
+	g.Expect(c.Build(tgz, "testdata/artifact", nil)).To(Succeed())
+	img, err := crane.Append(empty.Image, tgz)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(crane.Push(img, url)).To(Succeed())
+
+	tmpDir := t.TempDir()
+	meta, err := c.Pull(ctx, url, tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(meta.Created).To(BeEmpty())
+	g.Expect(meta.Digest).ToNot(BeEmpty())
+	g.Expect(filepath.Join(tmpDir, "testdata/artifact/deployment.yaml")).To(BeAnExistingFile())
+}
+
+func Test_Pull_LegacyArtifact(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-legacy"+randStringRunes(5))
+
+	// Artifacts pushed by earlier versions hold the content in a single
+	// layer with the default media types.
+	tgz := filepath.Join(t.TempDir(), "artifact.tgz")
+	g.Expect(c.Build(tgz, "testdata/artifact", nil)).To(Succeed())
+	img, err := crane.Append(empty.Image, tgz)
+	g.Expect(err).ToNot(HaveOccurred())
+	meta := Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev", Created: time.Now().Format(time.RFC3339)}
+	img = mutate.Annotations(img, meta.ToAnnotations()).(gcrv1.Image)
+	g.Expect(crane.Push(img, url)).To(Succeed())
+
+	tmpDir := t.TempDir()
+	_, err = c.Pull(ctx, url, tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(filepath.Join(tmpDir, "testdata/artifact/deployment.yaml")).To(BeAnExistingFile())
+}
+
+func Test_PullWithOptions_Limits(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-pull-limits"+randStringRunes(5))
+
+	_, err := c.Push(ctx, url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name         string
+		opts         PullOptions
+		wantFiles    []string
+		wantNotFiles []string
+		wantErr      string
+	}{
+		{
+			name:      "within limits",
+			opts:      PullOptions{MaxArtifactSize: 1 << 20, MaxExtractedSize: 1 << 20},
+			wantFiles: []string{"deploy/repo.yaml", "ignore.txt", "somedir/git/repo.yaml"},
+		},
+		{
+			name:    "artifact size exceeded",
+			opts:    PullOptions{MaxArtifactSize: 100},
+			wantErr: "exceeds the max artifact size of 100 bytes",
+		},
+		{
+			name:    "extracted size exceeded",
+			opts:    PullOptions{MaxExtractedSize: 500},
+			wantErr: "exceeds the max untar size of 500 bytes",
+		},
+		{
+			name:         "include paths",
+			opts:         PullOptions{IncludePaths: []string{"deploy/", "**/somedir/*.yaml"}},
+			wantFiles:    []string{"deploy/repo.yaml", "somedir/repo.yaml"},
+			wantNotFiles: []string{"deployment.yaml", "ignore.txt", "somedir/git/repo.yaml"},
+		},
+		{
+			name:         "exclude paths",
+			opts:         PullOptions{ExcludePaths: []string{"somedir/", "*.txt"}},
+			wantFiles:    []string{"deploy/repo.yaml", "ignore-dir/deployment.yaml"},
+			wantNotFiles: []string{"ignore.txt", "somedir"},
+		},
+		{
+			name:         "include and exclude paths",
+			opts:         PullOptions{IncludePaths: []string{"*.yaml"}, ExcludePaths: []string{"ignore-dir/"}},
+			wantFiles:    []string{"deployment.yaml", "somedir/git/repo.yaml"},
+			wantNotFiles: []string{"ignore.txt", "ignore-dir"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			parentDir := t.TempDir()
+			tmpDir := filepath.Join(parentDir, "out")
+			_, err := c.PullWithOptions(ctx, url, tmpDir, tt.opts)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				// Nothing is extracted and no temporary directory is left behind.
+				entries, err := os.ReadDir(parentDir)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(entries).To(BeEmpty())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			for _, f := range tt.wantFiles {
+				g.Expect(filepath.Join(tmpDir, "testdata/artifact", f)).To(BeAnExistingFile())
+			}
+			for _, f := range tt.wantNotFiles {
+				g.Expect(filepath.Join(tmpDir, "testdata/artifact", f)).ToNot(BeAnExistingFile())
+			}
+		})
+	}
+}
+
+func Test_layerReader(t *testing.T) {
+	content := []byte("layer content")
+	digest, _, err := gcrv1.SHA256(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDigest, _, err := gcrv1.SHA256(strings.NewReader("other content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		digest  gcrv1.Hash
+		maxSize int64
+		wantErr string
+	}{
+		{
+			name:   "valid digest",
+			digest: digest,
+		},
+		{
+			name:    "within max size",
+			digest:  digest,
+			maxSize: int64(len(content)),
+		},
+		{
+			name:    "digest mismatch",
+			digest:  otherDigest,
+			wantErr: "layer digest mismatch",
+		},
+		{
+			name:    "max size exceeded",
+			digest:  digest,
+			maxSize: 5,
+			wantErr: "exceeds the max artifact size of 5 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r, err := newLayerReader(bytes.NewReader(content), tt.digest, tt.maxSize)
+			g.Expect(err).ToNot(HaveOccurred())
+			// Part of the layer is consumed before the verification.
+			_, err = r.Read(make([]byte, 2))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			err = r.verify()
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func Test_extractLayer(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range []struct{ name, content string }{
+		{"dir/a.yaml", "a"},
+		{"b.yaml", "b"},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer := buf.Bytes()
+	digest, _, err := gcrv1.SHA256(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDigest, _, err := gcrv1.SHA256(strings.NewReader("other content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("moves verified files into place", func(t *testing.T) {
+		g := NewWithT(t)
+
+		parentDir := t.TempDir()
+		outDir := filepath.Join(parentDir, "out")
+		g.Expect(os.MkdirAll(filepath.Join(outDir, "dir"), 0o755)).To(Succeed())
+		g.Expect(os.WriteFile(filepath.Join(outDir, "dir", "c.yaml"), []byte("c"), 0o644)).To(Succeed())
+		g.Expect(os.WriteFile(filepath.Join(outDir, "b.yaml"), []byte("old"), 0o644)).To(Succeed())
+
+		r, err := newLayerReader(bytes.NewReader(layer), digest, 0)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(extractLayer(r, outDir, nil)).To(Succeed())
+
+		g.Expect(filepath.Join(outDir, "dir", "a.yaml")).To(BeAnExistingFile())
+		g.Expect(filepath.Join(outDir, "dir", "c.yaml")).To(BeAnExistingFile())
+		b, err := os.ReadFile(filepath.Join(outDir, "b.yaml"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(string(b)).To(Equal("b"))
+
+		entries, err := os.ReadDir(parentDir)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(entries).To(HaveLen(1))
+	})
+
+	t.Run("leaves nothing on digest mismatch", func(t *testing.T) {
+		g := NewWithT(t)
+
+		parentDir := t.TempDir()
+		outDir := filepath.Join(parentDir, "out")
+
+		r, err := newLayerReader(bytes.NewReader(layer), otherDigest, 0)
+		g.Expect(err).ToNot(HaveOccurred())
+		err = extractLayer(r, outDir, nil)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("layer digest mismatch"))
+
+		entries, err := os.ReadDir(parentDir)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(entries).To(BeEmpty())
+	})
+}
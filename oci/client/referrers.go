@@ -0,0 +1,499 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+// Referrer is an artifact referring to another artifact, such as an SBOM,
+// a provenance attestation or a signature.
+type Referrer struct {
+	// Digest is the digest reference of the referrer.
+	Digest string
+	// ArtifactType is the type of the referrer.
+	ArtifactType string
+	// Size is the size of the manifest of the referrer.
+	Size int64
+	// Annotations are the annotations of the manifest of the referrer.
+	Annotations map[string]string
+}
+
+// Attachment is the content of an artifact attached to another artifact.
+type Attachment struct {
+	// ArtifactType is the type of the attached artifact, for example
+	// "application/spdx+json" for SPDX SBOMs.
+	ArtifactType string
+	// Files are the files stored as layers of the attached artifact.
+	Files []AttachmentFile
+	// Annotations are added to the manifest of the attached artifact.
+	Annotations map[string]string
+}
+
+// AttachmentFile is a file of an attached artifact.
+type AttachmentFile struct {
+	// Path of the file.
+	Path string
+	// MediaType of the file, defaults to the artifact type.
+	MediaType types.MediaType
+}
+
+// Attach uploads an artifact with the given content referring to the
+// artifact with the given URL, and returns the digest of the attached
+// artifact. The attached artifact has the OCI 1.1 subject field set, and
+// is added to the referrers tag schema index for registries lacking the
+// referrers API.
+func (c *Client) Attach(ctx context.Context, url string, attachment Attachment) (string, error) {
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	if attachment.ArtifactType == "" {
+		return "", fmt.Errorf("artifact type is required")
+	}
+	if len(attachment.Files) == 0 {
+		return "", fmt.Errorf("at least one file is required")
+	}
+
+	digest, err := crane.Digest(url, c.optionsWithContext(ctx)...)
+	if err != nil {
+		return "", fmt.Errorf("fetching digest failed: %w", err)
+	}
+
+	layers := make([]mutate.Addendum, 0, len(attachment.Files))
+	for _, f := range attachment.Files {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return "", err
+		}
+		mediaType := f.MediaType
+		if mediaType == "" {
+			mediaType = types.MediaType(attachment.ArtifactType)
+		}
+		layers = append(layers, mutate.Addendum{
+			Layer:       static.NewLayer(data, mediaType),
+			Annotations: map[string]string{oci.TitleAnnotation: filepath.Base(f.Path)},
+		})
+	}
+
+	subject := ref.Context().Digest(digest)
+	desc, err := c.pushReferrer(ctx, subject, attachment.ArtifactType, layers, attachment.Annotations)
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Digest(desc.Digest.String()).String(), nil
+}
+
+// Referrers returns the artifacts referring to the artifact with the given
+// URL, filtered by the given artifact type if set. The referrers are
+// listed with the OCI 1.1 referrers API, falling back to the referrers tag
+// schema for registries lacking the API.
+func (c *Client) Referrers(ctx context.Context, url string, artifactType string) ([]Referrer, error) {
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	digest, err := crane.Digest(url, c.optionsWithContext(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching digest failed: %w", err)
+	}
+
+	descs, err := c.referrers(ctx, ref.Context().Digest(digest), artifactType)
+	if err != nil {
+		return nil, err
+	}
+	referrers := make([]Referrer, 0, len(descs))
+	for _, desc := range descs {
+		referrers = append(referrers, Referrer{
+			Digest:       ref.Context().Digest(desc.Digest.String()).String(),
+			ArtifactType: desc.ArtifactType,
+			Size:         desc.Size,
+			Annotations:  desc.Annotations,
+		})
+	}
+	return referrers, nil
+}
+
+// referrerDescriptor is the descriptor of a referrer of an artifact,
+// holding the OCI 1.1 artifact type of the referrer.
+type referrerDescriptor struct {
+	gcrv1.Descriptor
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// referrersIndex is the image index listing the referrers of an artifact.
+type referrersIndex struct {
+	SchemaVersion int64                `json:"schemaVersion"`
+	MediaType     types.MediaType      `json:"mediaType"`
+	Manifests     []referrerDescriptor `json:"manifests"`
+}
+
+// referrersTagRegexp matches the tags of the referrers tag schema.
+var referrersTagRegexp = regexp.MustCompile(`^sha256-[a-f0-9]{64}$`)
+
+// referrersTag returns the tag of the referrers tag schema, holding the
+// index of the referrers of the artifact with the given digest.
+func referrersTag(digest gcrv1.Hash) string {
+	return fmt.Sprintf("%s-%s", digest.Algorithm, digest.Hex)
+}
+
+// referrers returns the referrers of the artifact with the given digest,
+// filtered by the given artifact type if set.
+func (c *Client) referrers(ctx context.Context, ref name.Digest, artifactType string) ([]referrerDescriptor, error) {
+	referrers, supported, err := c.referrersFromAPI(ctx, ref, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	if supported {
+		return referrers, nil
+	}
+	return c.referrersFromTag(ctx, ref, artifactType)
+}
+
+// referrersFromAPI returns the referrers of the artifact with the given
+// digest listed by the OCI 1.1 referrers API, following pagination. It
+// returns false if the registry does not support the API.
+func (c *Client) referrersFromAPI(ctx context.Context, ref name.Digest, artifactType string) ([]referrerDescriptor, bool, error) {
+	repo := ref.Context()
+	auth, err := c.authenticator(repo)
+	if err != nil {
+		return nil, false, fmt.Errorf("resolving credentials failed: %w", err)
+	}
+	tr, err := transport.NewWithContext(ctx, repo.Registry, auth, transport.NewUserAgent(c.httpTransport(), oci.UserAgent),
+		[]string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, false, fmt.Errorf("connecting to registry failed: %w", err)
+	}
+	client := &http.Client{Transport: tr}
+
+	next := &neturl.URL{
+		Scheme: repo.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/referrers/%s", repo.RepositoryStr(), ref.DigestStr()),
+	}
+	if artifactType != "" {
+		next.RawQuery = neturl.Values{"artifactType": []string{artifactType}}.Encode()
+	}
+
+	var referrers []referrerDescriptor
+	for first := true; next != nil; first = false {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next.String(), nil)
+		if err != nil {
+			return nil, false, err
+		}
+		req.Header.Set("Accept", string(types.OCIImageIndex))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, false, fmt.Errorf("fetching referrers of '%s' failed: %w", ref, err)
+		}
+		if first && referrersAPIUnsupported(resp.StatusCode) {
+			resp.Body.Close()
+			return nil, false, nil
+		}
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, false, fmt.Errorf("fetching referrers of '%s' failed: %w", ref, err)
+		}
+		var index referrersIndex
+		err = json.NewDecoder(resp.Body).Decode(&index)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing referrers index failed: %w", err)
+		}
+		for _, desc := range index.Manifests {
+			if artifactType == "" || desc.ArtifactType == artifactType {
+				referrers = append(referrers, desc)
+			}
+		}
+
+		if next, err = nextPage(next, resp.Header.Get("Link")); err != nil {
+			return nil, false, err
+		}
+	}
+	return referrers, true, nil
+}
+
+// referrersAPIUnsupported returns true if the given status code of the first
+// referrers API response shows that the registry does not support the API.
+// Registries without the API answer with different client errors, depending
+// on how they route the unknown path.
+func referrersAPIUnsupported(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotAcceptable:
+		return true
+	}
+	return false
+}
+
+// nextPage returns the URL of the next page given in the Link header of a
+// paginated response, resolved against the URL of the response. It returns
+// nil if there is no next page.
+func nextPage(u *neturl.URL, link string) (*neturl.URL, error) {
+	if link == "" {
+		return nil, nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start || !strings.Contains(link[end:], `rel="next"`) {
+		return nil, nil
+	}
+	next, err := neturl.Parse(link[start+1 : end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pagination link '%s': %w", link, err)
+	}
+	return u.ResolveReference(next), nil
+}
+
+// referrersFromTag returns the referrers of the artifact with the given
+// digest listed in the index of the referrers tag schema, filtered by the
+// given artifact type if set. No referrers are returned if the index does
+// not exist.
+func (c *Client) referrersFromTag(ctx context.Context, ref name.Digest, artifactType string) ([]referrerDescriptor, error) {
+	digest, err := gcrv1.NewHash(ref.DigestStr())
+	if err != nil {
+		return nil, err
+	}
+	tag := ref.Context().Tag(referrersTag(digest))
+	// The index is fetched without resolving a platform specific child.
+	desc, err := remote.Get(tag, crane.GetOptions(c.optionsWithContext(ctx)...).Remote...)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("fetching referrers of '%s' failed: %w", ref, err)
+	}
+
+	var index referrersIndex
+	if err := json.Unmarshal(desc.Manifest, &index); err != nil {
+		return nil, fmt.Errorf("parsing referrers index failed: %w", err)
+	}
+	var referrers []referrerDescriptor
+	for _, desc := range index.Manifests {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrers, nil
+}
+
+// isNotFound returns true if the given error is a not found error returned
+// by the registry.
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
+
+// referrerManifest is the OCI image manifest of a referrer, holding the
+// descriptor of the artifact it refers to as subject.
+type referrerManifest struct {
+	SchemaVersion int64              `json:"schemaVersion"`
+	MediaType     types.MediaType    `json:"mediaType"`
+	ArtifactType  string             `json:"artifactType,omitempty"`
+	Config        gcrv1.Descriptor   `json:"config"`
+	Layers        []gcrv1.Descriptor `json:"layers"`
+	Subject       *gcrv1.Descriptor  `json:"subject,omitempty"`
+	Annotations   map[string]string  `json:"annotations,omitempty"`
+}
+
+// rawManifest is a raw manifest, pushed as is to a registry.
+type rawManifest struct {
+	data      []byte
+	mediaType types.MediaType
+}
+
+func (m rawManifest) RawManifest() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m rawManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+// pushReferrer pushes an artifact of the given type with the given layers
+// referring to the subject, adds it to the referrers index of the subject
+// and returns its descriptor. The config of the artifact has the artifact
+// type as media type, for registries which predate the artifactType field.
+func (c *Client) pushReferrer(ctx context.Context, subject name.Digest, artifactType string, layers []mutate.Addendum, annotations map[string]string) (*referrerDescriptor, error) {
+	options := crane.GetOptions(c.optionsWithContext(ctx)...).Remote
+	subjectDesc, err := remote.Head(subject, options...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching descriptor of '%s' failed: %w", subject, err)
+	}
+
+	config := static.NewLayer([]byte("{}"), types.MediaType(artifactType))
+	manifest := referrerManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  artifactType,
+		Subject: &gcrv1.Descriptor{
+			MediaType: subjectDesc.MediaType,
+			Digest:    subjectDesc.Digest,
+			Size:      subjectDesc.Size,
+		},
+		Annotations: annotations,
+	}
+	for i, l := range append([]mutate.Addendum{{Layer: config}}, layers...) {
+		if err := remote.WriteLayer(subject.Context(), l.Layer, options...); err != nil {
+			return nil, fmt.Errorf("uploading referrer blob failed: %w", err)
+		}
+		desc, err := partial.Descriptor(l.Layer)
+		if err != nil {
+			return nil, err
+		}
+		desc.Annotations = l.Annotations
+		if i == 0 {
+			manifest.Config = *desc
+			continue
+		}
+		manifest.Layers = append(manifest.Layers, *desc)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	digest, size, err := gcrv1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	ref := subject.Context().Digest(digest.String())
+	if err := remote.Put(ref, rawManifest{data: data, mediaType: types.OCIManifestSchema1}, options...); err != nil {
+		return nil, fmt.Errorf("pushing referrer failed: %w", err)
+	}
+
+	desc := referrerDescriptor{
+		Descriptor: gcrv1.Descriptor{
+			MediaType:   types.OCIManifestSchema1,
+			Digest:      digest,
+			Size:        size,
+			Annotations: annotations,
+		},
+		ArtifactType: artifactType,
+	}
+	// Registries supporting the referrers API index the referrer from its
+	// subject, the referrers tag schema index is updated otherwise.
+	if _, supported, err := c.referrersFromAPI(ctx, subject, artifactType); err != nil {
+		return nil, err
+	} else if !supported {
+		if err := c.addReferrerToTag(ctx, subject, desc); err != nil {
+			return nil, err
+		}
+	}
+	return &desc, nil
+}
+
+// addReferrerToTag adds the given referrer descriptor to the index of the
+// referrers tag schema of the subject, creating the index if needed.
+func (c *Client) addReferrerToTag(ctx context.Context, subject name.Digest, desc referrerDescriptor) error {
+	options := crane.GetOptions(c.optionsWithContext(ctx)...).Remote
+	digest, err := gcrv1.NewHash(subject.DigestStr())
+	if err != nil {
+		return err
+	}
+	tag := subject.Context().Tag(referrersTag(digest))
+
+	index := referrersIndex{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+	}
+	existing, err := remote.Get(tag, options...)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(existing.Manifest, &index); err != nil {
+			return fmt.Errorf("parsing referrers index failed: %w", err)
+		}
+	case !isNotFound(err):
+		return fmt.Errorf("fetching referrers of '%s' failed: %w", subject, err)
+	}
+
+	for _, d := range index.Manifests {
+		if d.Digest == desc.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := remote.Put(tag, rawManifest{data: data, mediaType: types.OCIImageIndex}, options...); err != nil {
+		return fmt.Errorf("updating referrers index failed: %w", err)
+	}
+	return nil
+}
+
+// writeImage pushes the image to the given reference, preserving its
+// manifest. Images referring to a subject are added to the referrers tag
+// schema index of the subject if the registry lacks the referrers API.
+func (c *Client) writeImage(ctx context.Context, ref name.Reference, img gcrv1.Image) error {
+	if err := remote.Write(ref, img, crane.GetOptions(c.optionsWithContext(ctx)...).Remote...); err != nil {
+		return err
+	}
+
+	raw, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	var manifest referrerManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return err
+	}
+	if manifest.Subject == nil {
+		return nil
+	}
+
+	subject := ref.Context().Digest(manifest.Subject.Digest.String())
+	if _, supported, err := c.referrersFromAPI(ctx, subject, manifest.ArtifactType); err != nil || supported {
+		return err
+	}
+	digest, size, err := gcrv1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	return c.addReferrerToTag(ctx, subject, referrerDescriptor{
+		Descriptor: gcrv1.Descriptor{
+			MediaType:   manifest.MediaType,
+			Digest:      digest,
+			Size:        size,
+			Annotations: manifest.Annotations,
+		},
+		ArtifactType: manifest.ArtifactType,
+	})
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/google/go-containerregistry/pkg/crane"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+func Test_Attach_Referrers(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	tmpDir := t.TempDir()
+	sbom := filepath.Join(tmpDir, "sbom.spdx.json")
+	if err := os.WriteFile(sbom, []byte(`{"spdxVersion":"SPDX-2.3"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provenance := filepath.Join(tmpDir, "provenance.json")
+	if err := os.WriteFile(provenance, []byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	apiReg := newReferrersAPIRegistry(t)
+	for _, tt := range []struct {
+		name     string
+		registry string
+		api      bool
+	}{
+		{name: "referrers tag schema", registry: dockerReg},
+		{name: "referrers API", registry: apiReg, api: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			repo := fmt.Sprintf("%s/%s", tt.registry, "test-referrers"+randStringRunes(5))
+			url := repo + ":v0.0.1"
+			digest, err := c.Push(ctx, url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			sbomDigest, err := c.Attach(ctx, url, Attachment{
+				ArtifactType: "application/spdx+json",
+				Files:        []AttachmentFile{{Path: sbom}},
+				Annotations:  map[string]string{"org.example.scanner": "syft"},
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+			_, err = c.Attach(ctx, url, Attachment{
+				ArtifactType: "application/vnd.in-toto+json",
+				Files:        []AttachmentFile{{Path: provenance, MediaType: "application/vnd.in-toto+json"}},
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			referrers, err := c.Referrers(ctx, url, "")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(referrers).To(HaveLen(2))
+
+			referrers, err = c.Referrers(ctx, digest, "application/spdx+json")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(referrers).To(HaveLen(1))
+			g.Expect(referrers[0].Digest).To(Equal(sbomDigest))
+			g.Expect(referrers[0].ArtifactType).To(Equal("application/spdx+json"))
+			g.Expect(referrers[0].Annotations).To(HaveKeyWithValue("org.example.scanner", "syft"))
+
+			// The attached artifact refers to the subject and holds the file.
+			raw, err := crane.Manifest(sbomDigest)
+			g.Expect(err).ToNot(HaveOccurred())
+			var manifest referrerManifest
+			g.Expect(json.Unmarshal(raw, &manifest)).To(Succeed())
+			g.Expect(manifest.Subject).ToNot(BeNil())
+			g.Expect(repo + "@" + manifest.Subject.Digest.String()).To(Equal(digest))
+			g.Expect(manifest.Layers).To(HaveLen(1))
+			g.Expect(manifest.Layers[0].Annotations).To(HaveKeyWithValue(oci.TitleAnnotation, "sbom.spdx.json"))
+
+			tags, err := crane.ListTags(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			h, err := gcrv1.NewHash(strings.Split(digest, "@")[1])
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.api {
+				g.Expect(tags).ToNot(ContainElement(referrersTag(h)))
+			} else {
+				g.Expect(tags).To(ContainElement(referrersTag(h)))
+			}
+		})
+	}
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotAcceptable} {
+		t.Run(fmt.Sprintf("referrers API unsupported with status %d", status), func(t *testing.T) {
+			g := NewWithT(t)
+
+			repo := fmt.Sprintf("%s/%s", newUnsupportedReferrersRegistry(t, status), "test-referrers"+randStringRunes(5))
+			url := repo + ":v0.0.1"
+			digest, err := c.Push(ctx, url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			sbomDigest, err := c.Attach(ctx, url, Attachment{
+				ArtifactType: "application/spdx+json",
+				Files:        []AttachmentFile{{Path: sbom}},
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			// The referrers are listed with the tag schema fallback.
+			referrers, err := c.Referrers(ctx, url, "")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(referrers).To(HaveLen(1))
+			g.Expect(referrers[0].Digest).To(Equal(sbomDigest))
+
+			tags, err := crane.ListTags(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			h, err := gcrv1.NewHash(strings.Split(digest, "@")[1])
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(tags).To(ContainElement(referrersTag(h)))
+		})
+	}
+
+	t.Run("no referrers", func(t *testing.T) {
+		g := NewWithT(t)
+
+		url, _ := pushTestArtifact(t, c)
+		referrers, err := c.Referrers(ctx, url, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(referrers).To(BeEmpty())
+	})
+
+	t.Run("invalid attachment", func(t *testing.T) {
+		g := NewWithT(t)
+
+		url, _ := pushTestArtifact(t, c)
+		_, err := c.Attach(ctx, url, Attachment{Files: []AttachmentFile{{Path: sbom}}})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("artifact type is required"))
+	})
+}
+
+var (
+	manifestPathRegexp  = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+	referrersPathRegexp = regexp.MustCompile(`^/v2/(.+)/referrers/([^/]+)$`)
+)
+
+// newReferrersAPIRegistry starts a registry serving the OCI 1.1 referrers
+// API, paginated with one referrer per page, and returns its host.
+func newReferrersAPIRegistry(t *testing.T) string {
+	t.Helper()
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	app := handlers.NewApp(context.Background(), config)
+
+	var mu sync.Mutex
+	referrers := make(map[string][]referrerDescriptor)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m := referrersPathRegexp.FindStringSubmatch(r.URL.Path); m != nil && r.Method == http.MethodGet {
+			mu.Lock()
+			descs := referrers[m[1]+"@"+m[2]]
+			mu.Unlock()
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			index := referrersIndex{SchemaVersion: 2, MediaType: types.OCIImageIndex, Manifests: []referrerDescriptor{}}
+			if page < len(descs) {
+				index.Manifests = append(index.Manifests, descs[page])
+			}
+			if page+1 < len(descs) {
+				w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next"`, r.URL.Path, page+1))
+			}
+			w.Header().Set("Content-Type", string(types.OCIImageIndex))
+			json.NewEncoder(w).Encode(index)
+			return
+		}
+
+		if m := manifestPathRegexp.FindStringSubmatch(r.URL.Path); m != nil && r.Method == http.MethodPut {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var manifest referrerManifest
+			if err := json.Unmarshal(body, &manifest); err == nil && manifest.Subject != nil {
+				digest, size, _ := gcrv1.SHA256(bytes.NewReader(body))
+				mu.Lock()
+				key := m[1] + "@" + manifest.Subject.Digest.String()
+				referrers[key] = append(referrers[key], referrerDescriptor{
+					Descriptor:   gcrv1.Descriptor{MediaType: manifest.MediaType, Digest: digest, Size: size, Annotations: manifest.Annotations},
+					ArtifactType: manifest.ArtifactType,
+				})
+				mu.Unlock()
+			}
+		}
+		app.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// newUnsupportedReferrersRegistry starts a registry answering the requests
+// to the OCI 1.1 referrers API with the given status code, and returns its
+// host.
+func newUnsupportedReferrersRegistry(t *testing.T, status int) string {
+	t.Helper()
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	app := handlers.NewApp(context.Background(), config)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if referrersPathRegexp.MatchString(r.URL.Path) {
+			w.WriteHeader(status)
+			return
+		}
+		app.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
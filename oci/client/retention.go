@@ -0,0 +1,187 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/fluxcd/pkg/version"
+)
+
+// RetentionPolicy defines which tags of an OCI repository are kept when
+// pruning the repository. A tag is kept if any of the keep rules matches it.
+type RetentionPolicy struct {
+	// KeepSemverVersions is the number of most recent semver tags to keep.
+	// It only applies to semver tags, the other tags are kept unless
+	// KeepNewerThan is set.
+	KeepSemverVersions int
+	// KeepNewerThan keeps the tags of the artifacts created within the
+	// given duration, according to their created annotation. It applies to
+	// all the tags. As their age is unknown, the tags of the artifacts
+	// without a created annotation, or with an invalid one, are kept.
+	KeepNewerThan time.Duration
+	// Include is a regex matching the tags subject to the policy, all the
+	// tags are subject to the policy if empty.
+	Include string
+	// Exclude is a regex matching the tags which are always kept.
+	Exclude string
+	// DryRun reports the tags which would be deleted without deleting them.
+	DryRun bool
+}
+
+// RetentionResult holds the tags kept and deleted when pruning a repository.
+type RetentionResult struct {
+	// Kept are the URLs of the kept tags.
+	Kept []string
+	// Deleted are the URLs of the deleted tags, or of the tags which would
+	// be deleted in dry-run mode, including the cosign signature and
+	// referrers tags of the deleted artifacts.
+	Deleted []string
+}
+
+// Prune deletes the tags of the given OCI repository which are not kept by
+// the given retention policy, and returns the kept and deleted tags.
+//
+// As registries may delete the manifest of a tag along with the tag, a tag
+// pointing to the same digest as a kept tag, or as a tag not subject to the
+// policy, is always kept. The cosign signature and referrers tags of the
+// artifacts of which all the tags are deleted are deleted with them.
+func (c *Client) Prune(ctx context.Context, url string, policy RetentionPolicy) (*RetentionResult, error) {
+	if policy.KeepSemverVersions <= 0 && policy.KeepNewerThan <= 0 {
+		return nil, fmt.Errorf("at least one of the semver versions count or the age to keep is required")
+	}
+
+	var include, exclude *regexp.Regexp
+	if policy.Include != "" {
+		var err error
+		if include, err = regexp.Compile(policy.Include); err != nil {
+			return nil, fmt.Errorf("regex '%s' parse error: %w", policy.Include, err)
+		}
+	}
+	if policy.Exclude != "" {
+		var err error
+		if exclude, err = regexp.Compile(policy.Exclude); err != nil {
+			return nil, fmt.Errorf("regex '%s' parse error: %w", policy.Exclude, err)
+		}
+	}
+
+	// All the tags are listed, as the tags not subject to the policy may
+	// share their digest with the pruned tags.
+	metas, err := c.List(ctx, url, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	type versionedTag struct {
+		url     string
+		version *semver.Version
+	}
+	var versions []versionedTag
+	var candidates []Metadata
+	keep := make(map[string]bool, len(metas))
+	keptDigests := make(map[string]bool, len(metas))
+	now := time.Now()
+	for _, meta := range metas {
+		tag, err := name.NewTag(meta.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag URL: %w", err)
+		}
+		if include != nil && !include.MatchString(tag.TagStr()) {
+			keptDigests[meta.Digest] = true
+			continue
+		}
+		candidates = append(candidates, meta)
+		if exclude != nil && exclude.MatchString(tag.TagStr()) {
+			keep[meta.URL] = true
+			continue
+		}
+		if policy.KeepNewerThan > 0 {
+			if created, err := time.Parse(time.RFC3339, meta.Created); err != nil || now.Sub(created) < policy.KeepNewerThan {
+				keep[meta.URL] = true
+			}
+		}
+		if v, err := version.ParseVersion(tag.TagStr()); err == nil {
+			versions = append(versions, versionedTag{url: meta.URL, version: v})
+		} else if policy.KeepNewerThan <= 0 {
+			keep[meta.URL] = true
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].version.GreaterThan(versions[j].version) })
+	for i := 0; i < policy.KeepSemverVersions && i < len(versions); i++ {
+		keep[versions[i].url] = true
+	}
+	for _, meta := range candidates {
+		if keep[meta.URL] {
+			keptDigests[meta.Digest] = true
+		}
+	}
+
+	result := &RetentionResult{}
+	deletedDigests := make(map[string]bool)
+	var deleted []string
+	for _, meta := range candidates {
+		if keep[meta.URL] || keptDigests[meta.Digest] {
+			result.Kept = append(result.Kept, meta.URL)
+			continue
+		}
+		if !policy.DryRun {
+			// The manifest may have been deleted with a previous tag
+			// pointing to the same digest.
+			if err := c.Delete(ctx, meta.URL); err != nil && !(deletedDigests[meta.Digest] && isNotFound(err)) {
+				return result, fmt.Errorf("deleting '%s' failed: %w", meta.URL, err)
+			}
+		}
+		result.Deleted = append(result.Deleted, meta.URL)
+		if !deletedDigests[meta.Digest] {
+			deletedDigests[meta.Digest] = true
+			deleted = append(deleted, meta.Digest)
+		}
+	}
+
+	for _, d := range deleted {
+		digest, err := gcrv1.NewHash(d)
+		if err != nil {
+			return result, fmt.Errorf("invalid digest '%s': %w", d, err)
+		}
+		for _, tag := range []string{cosignSignatureTag(digest), referrersTag(digest)} {
+			tagURL := fmt.Sprintf("%s:%s", url, tag)
+			if _, err := crane.Head(tagURL, c.optionsWithContext(ctx)...); err != nil {
+				if isNotFound(err) {
+					continue
+				}
+				return result, fmt.Errorf("fetching '%s' failed: %w", tagURL, err)
+			}
+			if !policy.DryRun {
+				if err := c.Delete(ctx, tagURL); err != nil && !isNotFound(err) {
+					return result, fmt.Errorf("deleting '%s' failed: %w", tagURL, err)
+				}
+			}
+			result.Deleted = append(result.Deleted, tagURL)
+		}
+	}
+	return result, nil
+}
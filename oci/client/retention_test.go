@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+	now := time.Now()
+	tags := map[string]time.Time{
+		"v0.1.0":          now.Add(-72 * time.Hour),
+		"v0.2.0":          now.Add(-48 * time.Hour),
+		"v0.3.0":          now.Add(-24 * time.Hour),
+		"main-fb3355b":    now.Add(-36 * time.Hour),
+		"main-a1b2c3d":    now.Add(-time.Hour),
+		"release-fb3355b": now.Add(-96 * time.Hour),
+		// The artifact has no created annotation.
+		"main-0d1e2f3": {},
+	}
+
+	tests := []struct {
+		name        string
+		policy      RetentionPolicy
+		wantDeleted []string
+		wantErr     string
+	}{
+		{
+			name:        "keep most recent semver versions",
+			policy:      RetentionPolicy{KeepSemverVersions: 2, Include: `^v`},
+			wantDeleted: []string{"v0.1.0"},
+		},
+		{
+			name:        "keep semver versions and other tags",
+			policy:      RetentionPolicy{KeepSemverVersions: 1},
+			wantDeleted: []string{"v0.1.0", "v0.2.0"},
+		},
+		{
+			name:        "keep tags newer than",
+			policy:      RetentionPolicy{KeepNewerThan: 30 * time.Hour},
+			wantDeleted: []string{"v0.1.0", "v0.2.0", "main-fb3355b", "release-fb3355b"},
+		},
+		{
+			name:        "keep semver versions or newer tags with exclusions",
+			policy:      RetentionPolicy{KeepSemverVersions: 1, KeepNewerThan: 30 * time.Hour, Exclude: `^release-`},
+			wantDeleted: []string{"v0.1.0", "v0.2.0", "main-fb3355b"},
+		},
+		{
+			name:    "no keep rule",
+			policy:  RetentionPolicy{Include: `^main-`},
+			wantErr: "at least one of the semver versions count or the age to keep is required",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, dryRun := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s (dry-run %v)", tt.name, dryRun), func(t *testing.T) {
+				g := NewWithT(t)
+
+				repo := fmt.Sprintf("%s/%s", dockerReg, "test-prune"+randStringRunes(5))
+				for tag, created := range tags {
+					img, err := random.Image(1024, 1)
+					g.Expect(err).ToNot(HaveOccurred())
+					m := Metadata{Source: "github.com/fluxcd/flux2", Revision: tag}
+					if !created.IsZero() {
+						m.Created = created.Format(time.RFC3339)
+					}
+					img = mutate.Annotations(img, m.ToAnnotations()).(gcrv1.Image)
+					g.Expect(crane.Push(img, fmt.Sprintf("%s:%s", repo, tag), c.options...)).To(Succeed())
+				}
+
+				policy := tt.policy
+				policy.DryRun = dryRun
+				result, err := c.Prune(ctx, repo, policy)
+				if tt.wantErr != "" {
+					g.Expect(err).To(HaveOccurred())
+					g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+					return
+				}
+				g.Expect(err).ToNot(HaveOccurred())
+
+				var wantDeleted []string
+				for _, tag := range tt.wantDeleted {
+					wantDeleted = append(wantDeleted, fmt.Sprintf("%s:%s", repo, tag))
+				}
+				g.Expect(result.Deleted).To(ConsistOf(wantDeleted))
+
+				remaining, err := crane.ListTags(repo)
+				g.Expect(err).ToNot(HaveOccurred())
+				if dryRun {
+					g.Expect(remaining).To(HaveLen(len(tags)))
+					return
+				}
+				g.Expect(remaining).To(HaveLen(len(tags) - len(tt.wantDeleted)))
+				for _, tag := range tt.wantDeleted {
+					g.Expect(remaining).ToNot(ContainElement(tag))
+				}
+			})
+		}
+	}
+}
+
+func TestPrune_SharedDigests(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		t.Run(fmt.Sprintf("dry-run %v", dryRun), func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+			c := NewLocalClient()
+			repo := fmt.Sprintf("%s/%s", dockerReg, "test-prune"+randStringRunes(5))
+
+			// The stable tag is not subject to the policy, and keeps
+			// v0.1.0 which points to the same artifact.
+			tags := map[string][]string{
+				"v0.1.0": {"v0.1.0", "stable"},
+				"v0.2.0": {"v0.2.0", "v0.2.1"},
+				"v0.3.0": {"v0.3.0"},
+			}
+			digests := make(map[string]gcrv1.Hash)
+			for revision, aliases := range tags {
+				img, err := random.Image(1024, 1)
+				g.Expect(err).ToNot(HaveOccurred())
+				m := Metadata{Source: "github.com/fluxcd/flux2", Revision: revision}
+				img = mutate.Annotations(img, m.ToAnnotations()).(gcrv1.Image)
+				for _, tag := range aliases {
+					g.Expect(crane.Push(img, fmt.Sprintf("%s:%s", repo, tag), c.options...)).To(Succeed())
+				}
+				digests[revision], err = img.Digest()
+				g.Expect(err).ToNot(HaveOccurred())
+
+				sig, err := random.Image(128, 1)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(crane.Push(sig, fmt.Sprintf("%s:%s", repo, cosignSignatureTag(digests[revision])), c.options...)).To(Succeed())
+			}
+
+			result, err := c.Prune(ctx, repo, RetentionPolicy{KeepSemverVersions: 1, Include: `^v`, DryRun: dryRun})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Kept).To(ConsistOf(repo+":v0.1.0", repo+":v0.3.0"))
+			deletedSig := cosignSignatureTag(digests["v0.2.0"])
+			g.Expect(result.Deleted).To(ConsistOf(repo+":v0.2.0", repo+":v0.2.1", repo+":"+deletedSig))
+
+			remaining, err := crane.ListTags(repo, c.options...)
+			g.Expect(err).ToNot(HaveOccurred())
+			if dryRun {
+				g.Expect(remaining).To(HaveLen(8))
+				return
+			}
+			g.Expect(remaining).To(ConsistOf("v0.1.0", "stable", "v0.3.0",
+				cosignSignatureTag(digests["v0.1.0"]), cosignSignatureTag(digests["v0.3.0"])))
+			_, err = crane.Head(repo+":v0.1.0", c.options...)
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
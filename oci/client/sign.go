@@ -0,0 +1,284 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// CosignPrivateKeySecretKey is the key of the private key in the
+	// Kubernetes secrets generated by cosign.
+	CosignPrivateKeySecretKey = "cosign.key"
+
+	// CosignPasswordSecretKey is the key of the password of the private
+	// key in the Kubernetes secrets generated by cosign.
+	CosignPasswordSecretKey = "cosign.password"
+
+	// cosignSignatureArtifactType is the artifact type of cosign
+	// signatures stored as OCI referrers.
+	cosignSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+)
+
+// SignOptions configures the signing of artifacts.
+type SignOptions struct {
+	// Signer signs the digest of artifacts, with an ECDSA or ED25519
+	// private key.
+	Signer crypto.Signer
+	// Referrer stores the signature as an OCI referrer of the artifact,
+	// instead of under the sha256-<digest>.sig tag.
+	Referrer bool
+}
+
+// Sign signs the artifact with the given URL with a cosign compatible
+// signature, uploads the signature to the repository of the artifact and
+// returns the digest of the signature.
+func (c *Client) Sign(ctx context.Context, url string, opts SignOptions) (string, error) {
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	digest, err := crane.Digest(url, c.optionsWithContext(ctx)...)
+	if err != nil {
+		return "", fmt.Errorf("fetching digest failed: %w", err)
+	}
+
+	return c.sign(ctx, ref.Context().Digest(digest), opts)
+}
+
+// signatureDigest returns the digest reference of a cosign signature of the
+// artifact with the given digest, stored under the signature tag or as OCI
+// referrer, or an empty string if the artifact is not signed.
+func (c *Client) signatureDigest(ctx context.Context, ref name.Digest) (string, error) {
+	h, err := gcrv1.NewHash(ref.DigestStr())
+	if err != nil {
+		return "", err
+	}
+	sigImgs, err := c.cosignSignatureImages(ctx, ref, h)
+	if err != nil || len(sigImgs) == 0 {
+		return "", err
+	}
+	sigDigest, err := sigImgs[0].Digest()
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Digest(sigDigest.String()).String(), nil
+}
+
+// sign signs the artifact with the given digest, and returns the digest of
+// the signature.
+func (c *Client) sign(ctx context.Context, ref name.Digest, opts SignOptions) (string, error) {
+	if opts.Signer == nil {
+		return "", fmt.Errorf("no signer configured")
+	}
+
+	payload, err := json.Marshal(cosignPayload{Critical: cosignCritical{
+		Identity: cosignIdentity{DockerReference: ref.Context().Name()},
+		Image:    cosignImage{DockerManifestDigest: ref.DigestStr()},
+		Type:     cosignSignatureType,
+	}})
+	if err != nil {
+		return "", err
+	}
+	sig, err := signPayload(opts.Signer, payload)
+	if err != nil {
+		return "", fmt.Errorf("signing failed: %w", err)
+	}
+	layer := mutate.Addendum{
+		Layer:       static.NewLayer(payload, cosignSimpleSigningMediaType),
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	}
+
+	if opts.Referrer {
+		desc, err := c.pushReferrer(ctx, ref, cosignSignatureArtifactType, []mutate.Addendum{layer}, nil)
+		if err != nil {
+			return "", err
+		}
+		return ref.Context().Digest(desc.Digest.String()).String(), nil
+	}
+
+	// Signatures are appended to the existing signatures of the artifact.
+	h, err := gcrv1.NewHash(ref.DigestStr())
+	if err != nil {
+		return "", err
+	}
+	sigTag := ref.Context().Tag(cosignSignatureTag(h))
+	sigImg, err := crane.Pull(sigTag.String(), c.optionsWithContext(ctx)...)
+	if err != nil {
+		if !isNotFound(err) {
+			return "", fmt.Errorf("fetching cosign signatures of '%s' failed: %w", ref, err)
+		}
+		sigImg = mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	}
+	sigImg, err = mutate.Append(sigImg, layer)
+	if err != nil {
+		return "", fmt.Errorf("appending signature failed: %w", err)
+	}
+	if err := crane.Push(sigImg, sigTag.String(), c.optionsWithContext(ctx)...); err != nil {
+		return "", fmt.Errorf("pushing signature failed: %w", err)
+	}
+	sigDigest, err := sigImg.Digest()
+	if err != nil {
+		return "", fmt.Errorf("parsing signature digest failed: %w", err)
+	}
+	return ref.Context().Digest(sigDigest.String()).String(), nil
+}
+
+// signPayload returns the cosign signature of the payload.
+func signPayload(signer crypto.Signer, payload []byte) ([]byte, error) {
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(payload)
+		return signer.Sign(rand.Reader, h[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", signer.Public())
+	}
+}
+
+// cosignEncryptedKey is the format of encrypted cosign private keys.
+type cosignEncryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadCosignSigner returns a signer for the given PEM encoded ECDSA or
+// ED25519 private key. Encrypted cosign private keys are decrypted with
+// the password, other keys must be in unencrypted PKCS#8 or SEC 1 format.
+func LoadCosignSigner(key, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		var err error
+		if der, err = decryptCosignKey(block.Bytes, password); err != nil {
+			return nil, err
+		}
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	}
+
+	pk, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	switch k := pk.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", pk)
+	}
+}
+
+// LoadCosignSignerFromFile returns a signer for the private key stored in
+// the given file, see LoadCosignSigner.
+func LoadCosignSignerFromFile(path string, password []byte) (crypto.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCosignSigner(key, password)
+}
+
+// LoadCosignSignerFromSecret returns a signer for the private key stored in
+// the given Kubernetes secret, in the format generated by cosign, see
+// LoadCosignSigner.
+func LoadCosignSignerFromSecret(secret *corev1.Secret) (crypto.Signer, error) {
+	key, ok := secret.Data[CosignPrivateKeySecretKey]
+	if !ok {
+		return nil, fmt.Errorf("'%s' not found in secret '%s/%s'", CosignPrivateKeySecretKey, secret.Namespace, secret.Name)
+	}
+	signer, err := LoadCosignSigner(key, secret.Data[CosignPasswordSecretKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in secret '%s/%s': %w", secret.Namespace, secret.Name, err)
+	}
+	return signer, nil
+}
+
+// decryptCosignKey decrypts an encrypted cosign private key, and returns
+// the PKCS#8 DER encoded key.
+func decryptCosignKey(data, password []byte) ([]byte, error) {
+	var k cosignEncryptedKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+	if k.KDF.Name != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function '%s'", k.KDF.Name)
+	}
+	if k.Cipher.Name != "nacl/secretbox" {
+		return nil, fmt.Errorf("unsupported cipher '%s'", k.Cipher.Name)
+	}
+	if len(k.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("invalid nonce length")
+	}
+
+	secret, err := scrypt.Key(password, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	var nonce [24]byte
+	var boxKey [32]byte
+	copy(nonce[:], k.Cipher.Nonce)
+	copy(boxKey[:], secret)
+	der, ok := secretbox.Open(nil, k.Ciphertext, &nonce, &boxKey)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt private key, invalid password")
+	}
+	return der, nil
+}
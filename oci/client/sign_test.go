@@ -0,0 +1,286 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_PushWithOptions_Sign(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, referrer := range []bool{false, true} {
+		t.Run(fmt.Sprintf("referrer %v", referrer), func(t *testing.T) {
+			g := NewWithT(t)
+
+			repo := fmt.Sprintf("%s/%s", dockerReg, "test-sign"+randStringRunes(5))
+			url := repo + ":v0.0.1"
+			res, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, Metadata{}, PushOptions{
+				Sign: &SignOptions{Signer: key, Referrer: referrer},
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(res.Digest).ToNot(BeEmpty())
+			g.Expect(res.SignatureDigest).ToNot(BeEmpty())
+
+			digest, err := crane.Digest(url)
+			g.Expect(err).ToNot(HaveOccurred())
+			h, err := gcrv1.NewHash(digest)
+			g.Expect(err).ToNot(HaveOccurred())
+			tags, err := crane.ListTags(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			if referrer {
+				g.Expect(tags).To(ContainElement(referrersTag(h)))
+				g.Expect(tags).ToNot(ContainElement(cosignSignatureTag(h)))
+			} else {
+				g.Expect(tags).To(ContainElement(cosignSignatureTag(h)))
+			}
+
+			err = c.Verify(ctx, url, VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &key.PublicKey)}}})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			// Signature tags are excluded from the listed artifacts.
+			metas, err := c.List(ctx, repo, ListOptions{})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(metas).To(HaveLen(1))
+		})
+	}
+
+	t.Run("appends signatures", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		url, _ := pushTestArtifact(t, c)
+		_, err = c.Sign(ctx, url, SignOptions{Signer: key})
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = c.Sign(ctx, url, SignOptions{Signer: otherKey})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		for _, pub := range []interface{}{&key.PublicKey, otherKey.Public()} {
+			err = c.Verify(ctx, url, VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, pub)}}})
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	t.Run("signs unchanged artifacts once", func(t *testing.T) {
+		g := NewWithT(t)
+
+		url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-sign"+randStringRunes(5))
+		opts := PushOptions{Reproducible: true, RevisionTime: time.Now()}
+		first, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, Metadata{}, opts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(first.SignatureDigest).To(BeEmpty())
+
+		opts.Sign = &SignOptions{Signer: key}
+		second, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, Metadata{}, opts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(second.Unchanged).To(BeTrue())
+		g.Expect(second.SignatureDigest).ToNot(BeEmpty())
+		err = c.Verify(ctx, url, VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &key.PublicKey)}}})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		third, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, Metadata{}, opts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(third.Unchanged).To(BeTrue())
+		g.Expect(third.SignatureDigest).To(Equal(second.SignatureDigest))
+	})
+
+	t.Run("no signer", func(t *testing.T) {
+		g := NewWithT(t)
+
+		url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-sign"+randStringRunes(5))
+		_, err := c.PushWithOptions(ctx, url, []Layer{{SourceDir: "testdata/artifact"}}, Metadata{}, PushOptions{Sign: &SignOptions{}})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("no signer configured"))
+	})
+}
+
+func Test_LoadCosignSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSEC1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPKCS8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      []byte
+		password []byte
+		wantErr  string
+	}{
+		{
+			name:     "encrypted cosign key",
+			key:      encryptCosignKey(t, ecPKCS8, []byte("password")),
+			password: []byte("password"),
+		},
+		{
+			name:     "encrypted cosign key with invalid password",
+			key:      encryptCosignKey(t, ecPKCS8, []byte("password")),
+			password: []byte("invalid"),
+			wantErr:  "invalid password",
+		},
+		{
+			name: "PKCS#8 ED25519 key",
+			key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edPKCS8}),
+		},
+		{
+			name: "SEC 1 ECDSA key",
+			key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecSEC1}),
+		},
+		{
+			name:    "RSA key",
+			key:     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8}),
+			wantErr: "unsupported private key type",
+		},
+		{
+			name:    "no PEM",
+			key:     []byte("invalid"),
+			wantErr: "no PEM encoded private key found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			signer, err := LoadCosignSigner(tt.key, tt.password)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(signer).ToNot(BeNil())
+		})
+	}
+
+	t.Run("from file", func(t *testing.T) {
+		g := NewWithT(t)
+
+		path := filepath.Join(t.TempDir(), "cosign.key")
+		g.Expect(os.WriteFile(path, encryptCosignKey(t, ecPKCS8, []byte("password")), 0o600)).To(Succeed())
+		signer, err := LoadCosignSignerFromFile(path, []byte("password"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(signer.Public()).To(Equal(ecKey.Public()))
+	})
+
+	t.Run("from secret", func(t *testing.T) {
+		g := NewWithT(t)
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cosign", Namespace: "default"},
+			Data: map[string][]byte{
+				CosignPrivateKeySecretKey: encryptCosignKey(t, edPKCS8, []byte("password")),
+				CosignPasswordSecretKey:   []byte("password"),
+			},
+		}
+		signer, err := LoadCosignSignerFromSecret(secret)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(signer.Public()).To(Equal(edKey.Public()))
+
+		delete(secret.Data, CosignPrivateKeySecretKey)
+		_, err = LoadCosignSignerFromSecret(secret)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("'cosign.key' not found in secret 'default/cosign'"))
+	})
+}
+
+// encryptCosignKey encrypts the PKCS#8 DER encoded key in the format of
+// cosign, with low scrypt parameters to keep tests fast.
+func encryptCosignKey(t *testing.T, der, password []byte) []byte {
+	t.Helper()
+
+	var k cosignEncryptedKey
+	k.KDF.Name = "scrypt"
+	k.KDF.Params.N = 1024
+	k.KDF.Params.R = 8
+	k.KDF.Params.P = 1
+	k.KDF.Salt = make([]byte, 32)
+	k.Cipher.Name = "nacl/secretbox"
+	k.Cipher.Nonce = make([]byte, 24)
+	if _, err := rand.Read(k.KDF.Salt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(k.Cipher.Nonce); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := scrypt.Key(password, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [24]byte
+	var boxKey [32]byte
+	copy(nonce[:], k.Cipher.Nonce)
+	copy(boxKey[:], secret)
+	k.Ciphertext = secretbox.Seal(nil, der, &nonce, &boxKey)
+
+	data, err := json.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED COSIGN PRIVATE KEY", Bytes: data})
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+// RegistryOptions holds the TLS and HTTP options for accessing a registry.
+type RegistryOptions struct {
+	// TLSConfig is the TLS configuration used to connect to the registry,
+	// for example with a custom CA or a client certificate for mTLS.
+	TLSConfig *tls.Config
+	// Insecure skips the verification of the registry TLS certificate.
+	Insecure bool
+	// PlainHTTP connects to the registry over plain HTTP instead of HTTPS.
+	PlainHTTP bool
+}
+
+// TLSConfigFromSecret returns a TLS config created from the content of the
+// secret, in the same way as the ConfigFromSecret function of the
+// github.com/fluxcd/pkg/runtime/tls package.
+// An error is returned if the secret does not contain a oci.ClientCert and
+// oci.ClientKey, or a oci.CACert.
+func TLSConfigFromSecret(certSecret *corev1.Secret) (*tls.Config, error) {
+	validSecret := false
+	tlsConfig := &tls.Config{}
+
+	clientCert, clientCertOk := certSecret.Data[oci.ClientCert]
+	clientKey, clientKeyOk := certSecret.Data[oci.ClientKey]
+	if clientKeyOk != clientCertOk {
+		return nil, fmt.Errorf("found one of %s or %s, and expected both or neither", oci.ClientCert, oci.ClientKey)
+	}
+	if clientCertOk && clientKeyOk {
+		validSecret = true
+		cert, err := tls.X509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+
+	if caCert, ok := certSecret.Data[oci.CACert]; ok {
+		validSecret = true
+		sysCerts, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		sysCerts.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = sysCerts
+	}
+
+	if !validSecret {
+		return nil, fmt.Errorf("no %s and %s, or %s found in secret", oci.ClientCert, oci.ClientKey, oci.CACert)
+	}
+
+	return tlsConfig, nil
+}
+
+// SetTransport configures the client to send the registry requests with the
+// given transport, including the provider login requests. Unlike a transport
+// set with the crane options, which the registry options replace, the
+// transport is kept for the hosts without registry options, and is cloned
+// for the hosts with TLS options if it is an *http.Transport.
+func (c *Client) SetTransport(t http.RoundTripper) {
+	c.baseTransport = t
+	c.transport = t
+	if c.registries != nil {
+		c.transport = newRegistryTransport(t, c.registries)
+	}
+}
+
+// SetRegistryOptions configures the client to access the registry with the
+// given host, including the port if any, with the given TLS and HTTP
+// options. The options apply to all the operations of the client, including
+// the provider login. The requests to the other hosts are sent with the
+// transport set with SetTransport, or with remote.DefaultTransport if none
+// is set, as a transport set with the crane options cannot be wrapped.
+func (c *Client) SetRegistryOptions(host string, opts RegistryOptions) {
+	if c.registries == nil {
+		c.registries = make(map[string]RegistryOptions)
+	}
+	c.registries[strings.ToLower(host)] = opts
+
+	base := c.baseTransport
+	if base == nil {
+		base = remote.DefaultTransport
+	}
+	c.transport = newRegistryTransport(base, c.registries)
+}
+
+// httpTransport returns the transport used for the registry requests made
+// outside crane.
+func (c *Client) httpTransport() http.RoundTripper {
+	if c.transport != nil {
+		return c.transport
+	}
+	return http.DefaultTransport
+}
+
+// registryTransport is an HTTP transport applying the options of each
+// registry host to the requests.
+type registryTransport struct {
+	base http.RoundTripper
+	// transports are the transports of the registry hosts with TLS options.
+	transports map[string]http.RoundTripper
+	// plainHTTP are the registry hosts accessed over plain HTTP.
+	plainHTTP map[string]bool
+}
+
+// newRegistryTransport returns a transport for the given registry options,
+// using the given base transport for the other hosts.
+func newRegistryTransport(base http.RoundTripper, registries map[string]RegistryOptions) *registryTransport {
+	t := &registryTransport{
+		base:       base,
+		transports: make(map[string]http.RoundTripper),
+		plainHTTP:  make(map[string]bool),
+	}
+	for host, opts := range registries {
+		if opts.PlainHTTP {
+			t.plainHTTP[host] = true
+		}
+		if opts.TLSConfig == nil && !opts.Insecure {
+			continue
+		}
+
+		var tlsConfig *tls.Config
+		if opts.TLSConfig != nil {
+			tlsConfig = opts.TLSConfig.Clone()
+		} else {
+			tlsConfig = &tls.Config{}
+		}
+		if opts.Insecure {
+			tlsConfig.InsecureSkipVerify = true
+		}
+		tr := remote.DefaultTransport.Clone()
+		if baseTransport, ok := base.(*http.Transport); ok {
+			tr = baseTransport.Clone()
+		}
+		tr.TLSClientConfig = tlsConfig
+		t.transports[host] = tr
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper, rewriting the requests to plain
+// HTTP registries and using the TLS options of the registry host.
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	if t.plainHTTP[host] && req.URL.Scheme == "https" {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+	}
+	if tr, ok := t.transports[host]; ok {
+		return tr.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
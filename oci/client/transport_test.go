@@ -0,0 +1,265 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/fluxcd/pkg/oci"
+)
+
+func Test_TLSConfigFromSecret(t *testing.T) {
+	certPEM, keyPEM := generateClientCert(t)
+
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		wantCert bool
+		wantCA   bool
+		wantErr  string
+	}{
+		{
+			name:     "client certificate and CA",
+			data:     map[string][]byte{oci.ClientCert: certPEM, oci.ClientKey: keyPEM, oci.CACert: certPEM},
+			wantCert: true,
+			wantCA:   true,
+		},
+		{
+			name:   "CA only",
+			data:   map[string][]byte{oci.CACert: certPEM},
+			wantCA: true,
+		},
+		{
+			name:    "client certificate without key",
+			data:    map[string][]byte{oci.ClientCert: certPEM},
+			wantErr: "expected both or neither",
+		},
+		{
+			name:    "invalid client certificate",
+			data:    map[string][]byte{oci.ClientCert: []byte("foo"), oci.ClientKey: keyPEM},
+			wantErr: "failed to find any PEM data in certificate input",
+		},
+		{
+			name:    "empty",
+			data:    map[string][]byte{},
+			wantErr: "no certFile and keyFile, or caFile found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tlsConfig, err := TLSConfigFromSecret(&corev1.Secret{Data: tt.data})
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(len(tlsConfig.Certificates) == 1).To(Equal(tt.wantCert))
+			g.Expect(tlsConfig.RootCAs != nil).To(Equal(tt.wantCA))
+		})
+	}
+}
+
+func Test_RegistryOptions_TLS(t *testing.T) {
+	ctx := context.Background()
+	certPEM, keyPEM := generateClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(certPEM)
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	srv := httptest.NewUnstartedServer(handlers.NewApp(ctx, config))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	url := fmt.Sprintf("%s/%s:v0.0.1", host, "test-tls"+randStringRunes(5))
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	tests := []struct {
+		name    string
+		opts    func(t *testing.T) RegistryOptions
+		wantErr bool
+	}{
+		{
+			name:    "without options",
+			opts:    nil,
+			wantErr: true,
+		},
+		{
+			name: "CA without client certificate",
+			opts: func(t *testing.T) RegistryOptions {
+				tlsConfig, err := TLSConfigFromSecret(&corev1.Secret{Data: map[string][]byte{oci.CACert: caPEM}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return RegistryOptions{TLSConfig: tlsConfig}
+			},
+			wantErr: true,
+		},
+		{
+			name: "mTLS",
+			opts: func(t *testing.T) RegistryOptions {
+				tlsConfig, err := TLSConfigFromSecret(&corev1.Secret{Data: map[string][]byte{
+					oci.ClientCert: certPEM,
+					oci.ClientKey:  keyPEM,
+					oci.CACert:     caPEM,
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return RegistryOptions{TLSConfig: tlsConfig}
+			},
+		},
+		{
+			name: "insecure with client certificate",
+			opts: func(t *testing.T) RegistryOptions {
+				tlsConfig, err := TLSConfigFromSecret(&corev1.Secret{Data: map[string][]byte{
+					oci.ClientCert: certPEM,
+					oci.ClientKey:  keyPEM,
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return RegistryOptions{TLSConfig: tlsConfig, Insecure: true}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := NewLocalClient()
+			if tt.opts != nil {
+				c.SetRegistryOptions(host, tt.opts(t))
+			}
+
+			digest, err := c.Push(ctx, url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}, nil)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+
+			meta, err := c.Pull(ctx, url, t.TempDir())
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(meta.Digest).To(Equal(digest))
+
+			tags, err := c.List(ctx, strings.TrimSuffix(url, ":v0.0.1"), ListOptions{})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(tags).To(HaveLen(1))
+		})
+	}
+}
+
+func Test_registryTransport_PlainHTTP(t *testing.T) {
+	g := NewWithT(t)
+
+	base := &mockTransport{response: &http.Response{StatusCode: http.StatusOK}}
+	tr := newRegistryTransport(base, map[string]RegistryOptions{
+		"registry.local:5000": {PlainHTTP: true},
+	})
+
+	req, err := http.NewRequest(http.MethodGet, "https://Registry.local:5000/v2/", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tr.RoundTrip(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(base.request.URL.String()).To(Equal("http://Registry.local:5000/v2/"))
+	g.Expect(req.URL.Scheme).To(Equal("https"))
+
+	req, err = http.NewRequest(http.MethodGet, "https://ghcr.io/v2/", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tr.RoundTrip(req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(base.request.URL.String()).To(Equal("https://ghcr.io/v2/"))
+}
+
+func Test_SetTransport(t *testing.T) {
+	g := NewWithT(t)
+
+	base := &mockTransport{err: errors.New("mock transport error")}
+	c := NewClient(nil)
+	c.SetTransport(base)
+	c.SetRegistryOptions("registry.local:5000", RegistryOptions{PlainHTTP: true})
+
+	// The transport is used for the hosts without registry options.
+	err := c.Delete(context.Background(), "ghcr.io/org/repo:v0.0.1")
+	g.Expect(err).To(MatchError(ContainSubstring("mock transport error")))
+	g.Expect(base.request).ToNot(BeNil())
+	g.Expect(base.request.URL.Host).To(Equal("ghcr.io"))
+
+	// The transport is wrapped with the options of the configured hosts.
+	err = c.Delete(context.Background(), "registry.local:5000/org/repo:v0.0.1")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(base.request.URL.String()).To(HavePrefix("http://registry.local:5000/"))
+}
+
+// generateClientCert returns a self-signed client certificate and its key
+// in PEM format.
+func generateClientCert(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "flux"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// VerifyOptions holds the trust configuration for verifying the signatures
+// of artifacts. Each of the configured verifiers must find a trusted
+// signature for the verification to succeed.
+type VerifyOptions struct {
+	// Cosign configures the verification of cosign signatures.
+	Cosign *CosignVerifyOptions
+	// Notation configures the verification of Notation signatures.
+	Notation *NotationVerifyOptions
+}
+
+// Verify verifies the signatures of the artifact with the given URL,
+// returning an error if no trusted signature is found.
+func (c *Client) Verify(ctx context.Context, url string, opts VerifyOptions) error {
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	digest, err := crane.Digest(url, c.optionsWithContext(ctx)...)
+	if err != nil {
+		return fmt.Errorf("fetching digest failed: %w", err)
+	}
+
+	return c.verify(ctx, ref.Context().Digest(digest), opts)
+}
+
+// verify verifies the signatures of the artifact with the given digest.
+func (c *Client) verify(ctx context.Context, ref name.Digest, opts VerifyOptions) error {
+	if opts.Cosign == nil && opts.Notation == nil {
+		return fmt.Errorf("no signature verification configured")
+	}
+	if opts.Cosign != nil {
+		if err := c.verifyCosign(ctx, ref, opts.Cosign); err != nil {
+			return fmt.Errorf("cosign verification failed: %w", err)
+		}
+	}
+	if opts.Notation != nil {
+		if err := c.verifyNotation(ctx, ref, opts.Notation); err != nil {
+			return fmt.Errorf("notation verification failed: %w", err)
+		}
+	}
+	return nil
+}
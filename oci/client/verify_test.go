@@ -0,0 +1,825 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+)
+
+func Test_Verify_Cosign(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, rootKey := newTestCA(t, "cosign-root")
+	otherRoot, _ := newTestCA(t, "other-root")
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestLeaf(t, root, rootKey, &leafKey.PublicKey, func(tmpl *x509.Certificate) {
+		tmpl.EmailAddresses = []string{"dev@example.com"}
+		// Keyless certificates are short-lived, and expired at
+		// verification time.
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(-50 * time.Minute)
+		issuer, _ := asn1.Marshal("https://token.example.com")
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidIssuerV2, Value: issuer}}
+	})
+	signedAt := time.Now().Add(-55 * time.Minute)
+	identities := []CosignIdentity{{Subject: "dev@example.com"}}
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keyless returns the annotations of a keyless signature made with the
+	// leaf certificate, with its chain and a transparency log entry signed
+	// by the Rekor key at the given time, if not nil.
+	keyless := func(leaf, chain *x509.Certificate, rekorKey *ecdsa.PrivateKey, signedAt time.Time) func(payload, sig []byte) map[string]string {
+		return func(payload, sig []byte) map[string]string {
+			annotations := map[string]string{cosignCertificateAnnotation: string(certificatePEM(leaf))}
+			if chain != nil {
+				annotations[cosignChainAnnotation] = string(certificatePEM(chain))
+			}
+			if rekorKey != nil {
+				annotations[cosignBundleAnnotation] = rekorBundle(t, rekorKey, leaf, payload, sig, signedAt)
+			}
+			return annotations
+		}
+	}
+
+	tests := []struct {
+		name    string
+		sign    func(t *testing.T, ref name.Digest)
+		opts    CosignVerifyOptions
+		wantErr string
+	}{
+		{
+			name: "ECDSA public key",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, ecKey, nil)
+			},
+			opts: CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &otherKey.PublicKey), publicKeyPEM(t, &ecKey.PublicKey)}},
+		},
+		{
+			name: "ED25519 public key",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, edKey, nil)
+			},
+			opts: CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, edKey.Public())}},
+		},
+		{
+			name: "untrusted public key",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, otherKey, nil)
+			},
+			opts:    CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &ecKey.PublicKey)}},
+			wantErr: "signature does not match any of the public keys",
+		},
+		{
+			name: "signature of another digest",
+			sign: func(t *testing.T, ref name.Digest) {
+				other := ref.Context().Digest("sha256:" + fmt.Sprintf("%064d", 0))
+				pushCosignSignature(t, ref, other, ecKey, nil)
+			},
+			opts:    CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &ecKey.PublicKey)}},
+			wantErr: "signature is for digest",
+		},
+		{
+			name:    "no signature",
+			sign:    func(t *testing.T, ref name.Digest) {},
+			opts:    CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &ecKey.PublicKey)}},
+			wantErr: "no cosign signatures found",
+		},
+		{
+			name: "keyless",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, leafKey, keyless(leaf, root, rekorKey, signedAt))
+			},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(root),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &otherKey.PublicKey), publicKeyPEM(t, &rekorKey.PublicKey)},
+				Identities:      []CosignIdentity{{Subject: "dev@example.com", Issuer: "https://token.example.com"}},
+			},
+		},
+		{
+			name: "keyless untrusted identity",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, leafKey, keyless(leaf, nil, rekorKey, signedAt))
+			},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(root),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &rekorKey.PublicKey)},
+				Identities:      []CosignIdentity{{Subject: "dev@example.com", Issuer: "https://other.example.com"}},
+			},
+			wantErr: "is not trusted",
+		},
+		{
+			name: "keyless untrusted root",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, leafKey, keyless(leaf, nil, rekorKey, signedAt))
+			},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(otherRoot),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &rekorKey.PublicKey)},
+				Identities:      identities,
+			},
+			wantErr: "untrusted signing certificate",
+		},
+		{
+			name: "keyless signed after certificate expiry",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, leafKey, keyless(leaf, root, rekorKey, time.Now()))
+			},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(root),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &rekorKey.PublicKey)},
+				Identities:      identities,
+			},
+			wantErr: "untrusted signing certificate",
+		},
+		{
+			name: "keyless without transparency log entry",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, leafKey, keyless(leaf, root, nil, signedAt))
+			},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(root),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &rekorKey.PublicKey)},
+				Identities:      identities,
+			},
+			wantErr: "keyless signature has no transparency log entry",
+		},
+		{
+			name: "keyless untrusted transparency log",
+			sign: func(t *testing.T, ref name.Digest) {
+				pushCosignSignature(t, ref, ref, leafKey, keyless(leaf, root, otherKey, signedAt))
+			},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(root),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &rekorKey.PublicKey)},
+				Identities:      identities,
+			},
+			wantErr: "transparency log entry is from untrusted log",
+		},
+		{
+			name: "keyless without identities",
+			sign: func(t *testing.T, ref name.Digest) {},
+			opts: CosignVerifyOptions{
+				Roots:           certPool(root),
+				RekorPublicKeys: [][]byte{publicKeyPEM(t, &rekorKey.PublicKey)},
+			},
+			wantErr: "no identities configured",
+		},
+		{
+			name: "keyless without Rekor public keys",
+			sign: func(t *testing.T, ref name.Digest) {},
+			opts: CosignVerifyOptions{
+				Roots: certPool(root),
+			},
+			wantErr: "no Rekor public keys configured",
+		},
+		{
+			name:    "no trust configured",
+			sign:    func(t *testing.T, ref name.Digest) {},
+			wantErr: "no cosign public keys or trust roots configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			url, ref := pushTestArtifact(t, c)
+			tt.sign(t, ref)
+
+			err := c.Verify(ctx, url, VerifyOptions{Cosign: &tt.opts})
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func Test_canonicalJSON(t *testing.T) {
+	g := NewWithT(t)
+
+	// The keys are sorted by UTF-16 code units, unlike UTF-8 bytes for
+	// U+FB01 and U+1F600, and only the quotation mark, the reverse solidus
+	// and the control characters are escaped.
+	data, err := canonicalJSON(map[string]interface{}{
+		"logIndex":       int64(12),
+		"logID":          "c0d23d6a",
+		"\ufb01":         "<a&b>\u2028\"\\\n\x01",
+		"\U0001F600":     "",
+		"integratedTime": int64(-1),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(data)).To(Equal("{\"integratedTime\":-1,\"logID\":\"c0d23d6a\",\"logIndex\":12," +
+		"\"\U0001F600\":\"\",\"\ufb01\":\"<a&b>\u2028\\\"\\\\\\n\\u0001\"}"))
+
+	_, err = canonicalJSON(map[string]interface{}{"logIndex": int64(1<<53 + 1)})
+	g.Expect(err).To(HaveOccurred())
+	_, err = canonicalJSON(map[string]interface{}{"body": "\xff"})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func Test_Verify_Notation(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	root, rootKey := newTestCA(t, "notation-root")
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestLeaf(t, root, rootKey, &leafKey.PublicKey, func(tmpl *x509.Certificate) {
+		tmpl.Subject = pkix.Name{Country: []string{"US"}, Organization: []string{"acme-rockets"}, CommonName: "signer"}
+	})
+	expiredLeaf := newTestLeaf(t, root, rootKey, &leafKey.PublicKey, func(tmpl *x509.Certificate) {
+		tmpl.NotBefore = time.Now().Add(-2 * time.Hour)
+		tmpl.NotAfter = time.Now().Add(-time.Hour)
+	})
+	futureLeaf := newTestLeaf(t, root, rootKey, &leafKey.PublicKey, func(tmpl *x509.Certificate) {
+		tmpl.NotBefore = time.Now().Add(time.Hour)
+		tmpl.NotAfter = time.Now().Add(2 * time.Hour)
+	})
+	otherRoot, otherRootKey := newTestCA(t, "other-root")
+	otherLeaf := newTestLeaf(t, otherRoot, otherRootKey, &leafKey.PublicKey, nil)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := func(level string, identities ...string) *NotationTrustPolicy {
+		return &NotationTrustPolicy{
+			Version: "1.0",
+			TrustPolicies: []NotationTrustPolicyStatement{{
+				Name:                  "test",
+				RegistryScopes:        []string{"*"},
+				SignatureVerification: NotationSignatureVerification{Level: level},
+				TrustStores:           []string{"ca:test"},
+				TrustedIdentities:     identities,
+			}},
+		}
+	}
+	stores := map[string][]*x509.Certificate{"ca:test": {root}}
+
+	tests := []struct {
+		name    string
+		chain   []*x509.Certificate
+		key     *ecdsa.PrivateKey
+		modify  func(*jwsProtected, *notationPayload)
+		policy  *NotationTrustPolicy
+		wantErr string
+	}{
+		{
+			name:   "trusted identity",
+			chain:  []*x509.Certificate{leaf, root},
+			policy: policy(NotationLevelStrict, "x509.subject: C=US, O=acme-rockets"),
+		},
+		{
+			name:   "wildcard identity",
+			chain:  []*x509.Certificate{leaf},
+			policy: policy(NotationLevelPermissive, "*"),
+		},
+		{
+			name:    "untrusted identity",
+			chain:   []*x509.Certificate{leaf},
+			policy:  policy(NotationLevelStrict, "x509.subject: C=US, O=wabbit-networks"),
+			wantErr: "is not trusted",
+		},
+		{
+			name:    "untrusted root",
+			chain:   []*x509.Certificate{otherLeaf},
+			policy:  policy(NotationLevelStrict, "*"),
+			wantErr: "untrusted signing certificate",
+		},
+		{
+			name:    "no signature",
+			policy:  policy(NotationLevelStrict, "*"),
+			wantErr: "no Notation signatures found",
+		},
+		{
+			name:    "strict expired certificate",
+			chain:   []*x509.Certificate{expiredLeaf},
+			policy:  policy(NotationLevelStrict, "*"),
+			wantErr: "untrusted signing certificate",
+		},
+		{
+			name:   "permissive expired certificate",
+			chain:  []*x509.Certificate{expiredLeaf},
+			policy: policy(NotationLevelPermissive, "*"),
+		},
+		{
+			name: "permissive expired certificate of untrusted root",
+			chain: []*x509.Certificate{newTestLeaf(t, otherRoot, otherRootKey, &leafKey.PublicKey, func(tmpl *x509.Certificate) {
+				tmpl.NotBefore = time.Now().Add(-2 * time.Hour)
+				tmpl.NotAfter = time.Now().Add(-time.Hour)
+			})},
+			policy:  policy(NotationLevelPermissive, "*"),
+			wantErr: "untrusted signing certificate",
+		},
+		{
+			name:  "permissive certificate not yet valid at claimed signing time",
+			chain: []*x509.Certificate{futureLeaf},
+			modify: func(p *jwsProtected, _ *notationPayload) {
+				signingTime := time.Now().Add(90 * time.Minute)
+				p.SigningTime = &signingTime
+			},
+			policy:  policy(NotationLevelPermissive, "*"),
+			wantErr: "untrusted signing certificate",
+		},
+		{
+			name:  "strict expired signature",
+			chain: []*x509.Certificate{leaf},
+			modify: func(p *jwsProtected, _ *notationPayload) {
+				expiry := time.Now().Add(-time.Minute)
+				p.Expiry = &expiry
+				p.Critical = append(p.Critical, notationExpiryHeader)
+			},
+			policy:  policy(NotationLevelStrict, "*"),
+			wantErr: "signature expired",
+		},
+		{
+			name:  "permissive expired signature",
+			chain: []*x509.Certificate{leaf},
+			modify: func(p *jwsProtected, _ *notationPayload) {
+				expiry := time.Now().Add(-time.Minute)
+				p.Expiry = &expiry
+				p.Critical = append(p.Critical, notationExpiryHeader)
+			},
+			policy: policy(NotationLevelPermissive, "*"),
+		},
+		{
+			name:   "audit untrusted root",
+			chain:  []*x509.Certificate{otherLeaf},
+			policy: policy(NotationLevelAudit, "*"),
+		},
+		{
+			name:   "audit untrusted identity",
+			chain:  []*x509.Certificate{leaf},
+			policy: policy(NotationLevelAudit, "x509.subject: C=US, O=wabbit-networks"),
+		},
+		{
+			name:    "audit invalid signature",
+			chain:   []*x509.Certificate{leaf},
+			key:     otherKey,
+			policy:  policy(NotationLevelAudit, "*"),
+			wantErr: "invalid ECDSA signature",
+		},
+		{
+			name:  "audit signature of another digest",
+			chain: []*x509.Certificate{leaf},
+			modify: func(_ *jwsProtected, p *notationPayload) {
+				p.TargetArtifact.Digest = gcrv1.Hash{Algorithm: "sha256", Hex: fmt.Sprintf("%064d", 0)}
+			},
+			policy:  policy(NotationLevelAudit, "*"),
+			wantErr: "is for artifact",
+		},
+		{
+			name:    "audit no signature",
+			policy:  policy(NotationLevelAudit, "*"),
+			wantErr: "no Notation signatures found",
+		},
+		{
+			name:  "signing scheme not critical",
+			chain: []*x509.Certificate{leaf},
+			modify: func(p *jwsProtected, _ *notationPayload) {
+				p.Critical = nil
+			},
+			policy:  policy(NotationLevelAudit, "*"),
+			wantErr: "is not marked critical",
+		},
+		{
+			name:  "algorithm not matching curve",
+			chain: []*x509.Certificate{leaf},
+			modify: func(p *jwsProtected, _ *notationPayload) {
+				p.Algorithm = "ES384"
+			},
+			policy:  policy(NotationLevelStrict, "*"),
+			wantErr: "does not match ECDSA key",
+		},
+		{
+			name:  "unsupported payload content type",
+			chain: []*x509.Certificate{leaf},
+			modify: func(p *jwsProtected, _ *notationPayload) {
+				p.ContentType = "application/json"
+			},
+			policy:  policy(NotationLevelAudit, "*"),
+			wantErr: "unsupported payload content type",
+		},
+		{
+			name:   "skip",
+			policy: &NotationTrustPolicy{Version: "1.0", TrustPolicies: []NotationTrustPolicyStatement{{Name: "skip", RegistryScopes: []string{"*"}, SignatureVerification: NotationSignatureVerification{Level: NotationLevelSkip}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			url, ref := pushTestArtifact(t, c)
+			if tt.chain != nil {
+				key := leafKey
+				if tt.key != nil {
+					key = tt.key
+				}
+				pushNotationSignature(t, c, ref, key, tt.chain, tt.modify)
+			}
+
+			err := c.Verify(ctx, url, VerifyOptions{Notation: &NotationVerifyOptions{TrustPolicy: tt.policy, TrustStores: stores}})
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func Test_PullWithOptions_Verify(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	c := NewLocalClient()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	url, ref := pushTestArtifact(t, c)
+	pushCosignSignature(t, ref, ref, key, nil)
+
+	tmpDir := t.TempDir()
+	_, err = c.PullWithOptions(ctx, url, tmpDir, PullOptions{
+		Verify: &VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &otherKey.PublicKey)}}},
+	})
+	g.Expect(err).To(HaveOccurred())
+	entries, err := os.ReadDir(tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(BeEmpty())
+
+	meta, err := c.PullWithOptions(ctx, url, tmpDir, PullOptions{
+		Verify: &VerifyOptions{Cosign: &CosignVerifyOptions{PublicKeys: [][]byte{publicKeyPEM(t, &key.PublicKey)}}},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(meta.Digest).To(Equal(ref.String()))
+	entries, err = os.ReadDir(tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).ToNot(BeEmpty())
+}
+
+func Test_ParseNotationTrustPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			policy: `{"version":"1.0","trustPolicies":[{"name":"a","registryScopes":["*"],"signatureVerification":{"level":"strict"},"trustStores":["ca:a"],"trustedIdentities":["x509.subject: C=US, O=acme"]}]}`,
+		},
+		{
+			name:    "unsupported version",
+			policy:  `{"version":"2.0","trustPolicies":[]}`,
+			wantErr: "unsupported trust policy version",
+		},
+		{
+			name:    "invalid level",
+			policy:  `{"version":"1.0","trustPolicies":[{"name":"a","registryScopes":["*"],"signatureVerification":{"level":"lax"}}]}`,
+			wantErr: "invalid verification level",
+		},
+		{
+			name:    "duplicate scope",
+			policy:  `{"version":"1.0","trustPolicies":[{"name":"a","registryScopes":["r/a"],"signatureVerification":{"level":"skip"}},{"name":"b","registryScopes":["r/a"],"signatureVerification":{"level":"skip"}}]}`,
+			wantErr: "is used by trust policy statements",
+		},
+		{
+			name:    "invalid identity",
+			policy:  `{"version":"1.0","trustPolicies":[{"name":"a","registryScopes":["*"],"signatureVerification":{"level":"strict"},"trustStores":["ca:a"],"trustedIdentities":["C=US"]}]}`,
+			wantErr: "invalid trusted identity",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := ParseNotationTrustPolicy([]byte(tt.policy))
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+// pushTestArtifact pushes an artifact to a new repository of the test
+// registry, and returns its URL and digest reference.
+func pushTestArtifact(t *testing.T, c *Client) (string, name.Digest) {
+	t.Helper()
+
+	url := fmt.Sprintf("%s/%s:v0.0.1", dockerReg, "test-verify"+randStringRunes(5))
+	digest, err := c.Push(context.Background(), url, "testdata/artifact", Metadata{Source: "github.com/fluxcd/flux2", Revision: "rev"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewDigest(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url, ref
+}
+
+// pushCosignSignature pushes a cosign signature of the subject digest,
+// signed with the given key, to the signature tag of the artifact.
+func pushCosignSignature(t *testing.T, artifact, subject name.Digest, key crypto.Signer, annotate func(payload, sig []byte) map[string]string) {
+	t.Helper()
+
+	payload, err := json.Marshal(cosignPayload{Critical: cosignCritical{
+		Identity: cosignIdentity{DockerReference: subject.Context().Name()},
+		Image:    cosignImage{DockerManifestDigest: subject.DigestStr()},
+		Type:     cosignSignatureType,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig []byte
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		sig, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+	} else {
+		h := sha256.Sum256(payload)
+		sig, err = key.Sign(rand.Reader, h[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layerAnnotations := map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	if annotate != nil {
+		for k, v := range annotate(payload, sig) {
+			layerAnnotations[k] = v
+		}
+	}
+	img, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer:       static.NewLayer(payload, cosignSimpleSigningMediaType),
+		Annotations: layerAnnotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := gcrv1.NewHash(artifact.DigestStr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crane.Push(img, artifact.Context().Tag(cosignSignatureTag(digest)).String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rekorBundle returns the JSON of a Rekor transparency log entry of the
+// keyless signature of the payload, integrated at the given time and signed
+// with the given key.
+func rekorBundle(t *testing.T, key *ecdsa.PrivateKey, cert *x509.Certificate, payload, sig []byte, integratedAt time.Time) string {
+	t.Helper()
+
+	h := sha256.Sum256(payload)
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       rekorHashedRekordKind,
+		"spec": map[string]interface{}{
+			"data": map[string]interface{}{
+				"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(h[:])},
+			},
+			"signature": map[string]interface{}{
+				"content":   sig,
+				"publicKey": map[string][]byte{"content": certificatePEM(cert)},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	entry := map[string]interface{}{
+		"body":           base64.StdEncoding.EncodeToString(body),
+		"integratedTime": integratedAt.Unix(),
+		"logIndex":       1,
+		"logID":          hex.EncodeToString(logID[:]),
+	}
+	canonical, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := sha256.Sum256(canonical)
+	set, err := ecdsa.SignASN1(rand.Reader, key, ch[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := json.Marshal(map[string]interface{}{"SignedEntryTimestamp": set, "Payload": entry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bundle)
+}
+
+// pushNotationSignature pushes a Notation JWS signature of the artifact,
+// signed with the given key and certificate chain, and lists it in the
+// referrers index of the artifact. The protected header and payload are
+// modified with the given function before signing, if not nil.
+func pushNotationSignature(t *testing.T, c *Client, artifact name.Digest, key *ecdsa.PrivateKey, chain []*x509.Certificate, modify func(*jwsProtected, *notationPayload)) {
+	t.Helper()
+
+	target, err := crane.Head(artifact.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := notationPayload{TargetArtifact: gcrv1.Descriptor{
+		MediaType: target.MediaType,
+		Digest:    target.Digest,
+		Size:      target.Size,
+	}}
+	signingTime := time.Now()
+	h := jwsProtected{
+		Algorithm:     "ES256",
+		ContentType:   notationPayloadContentType,
+		Critical:      []string{"io.cncf.notary.signingScheme"},
+		SigningScheme: notationSigningScheme,
+		SigningTime:   &signingTime,
+	}
+	if modify != nil {
+		modify(&h, &p)
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := jwsEnvelope{
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+		Protected: base64.RawURLEncoding.EncodeToString(protected),
+	}
+	digest := sha256.Sum256([]byte(env.Protected + "." + env.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	env.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	for _, cert := range chain {
+		env.Header.CertChain = append(env.Header.CertChain, cert.Raw)
+	}
+	envelope, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer := mutate.Addendum{Layer: static.NewLayer(envelope, notationJWSMediaType)}
+	if _, err := c.pushReferrer(context.Background(), artifact, notationArtifactType, []mutate.Addendum{layer}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestCA returns a self-signed CA certificate and its key.
+func newTestCA(t *testing.T, cn string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newTestLeaf returns a code signing certificate for the given public key,
+// issued by the given CA.
+func newTestLeaf(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, pub crypto.PublicKey, mutate func(*x509.Certificate)) *x509.Certificate {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	if mutate != nil {
+		mutate(tmpl)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, pub, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func publicKeyPEM(t *testing.T, pub crypto.PublicKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func certificatePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+	return pool
+}
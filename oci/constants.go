@@ -16,6 +16,8 @@ limitations under the License.
 
 package oci
 
+import "github.com/google/go-containerregistry/pkg/v1/types"
+
 // Provider is used to categorize the OCI registry providers.
 type Provider int
 
@@ -27,6 +29,9 @@ const (
 	ProviderAWS
 	ProviderGCP
 	ProviderAzure
+	// ProviderOIDC is used to categorize registries accepting OIDC tokens
+	// federated from the workload identity.
+	ProviderOIDC
 )
 
 // Registry TLS transport config.
@@ -49,9 +54,58 @@ const (
 	// the date and time on which the OCI artifact was built (RFC 3339).
 	CreatedAnnotation = "org.opencontainers.image.created"
 
+	// TitleAnnotation is the OpenContainers annotation for specifying
+	// the human-readable title of an OCI artifact layer.
+	TitleAnnotation = "org.opencontainers.image.title"
+
+	// DescriptionAnnotation is the OpenContainers annotation for specifying
+	// the human-readable description of an OCI artifact.
+	DescriptionAnnotation = "org.opencontainers.image.description"
+
+	// LicensesAnnotation is the OpenContainers annotation for specifying
+	// the licenses of an OCI artifact content, as an SPDX expression.
+	LicensesAnnotation = "org.opencontainers.image.licenses"
+
+	// VersionAnnotation is the OpenContainers annotation for specifying
+	// the version of the packaged software of an OCI artifact.
+	VersionAnnotation = "org.opencontainers.image.version"
+
+	// URLAnnotation is the OpenContainers annotation for specifying the
+	// URL to find more information on an OCI artifact.
+	URLAnnotation = "org.opencontainers.image.url"
+
+	// AuthorsAnnotation is the OpenContainers annotation for specifying
+	// the contact details of the people responsible for an OCI artifact.
+	AuthorsAnnotation = "org.opencontainers.image.authors"
+
 	// OCIRepositoryPrefix is the prefix used for OCIRepository URLs.
 	OCIRepositoryPrefix = "oci://"
 
 	// UserAgent string used for OCI calls.
 	UserAgent = "flux/v2"
 )
+
+const (
+	// CanonicalConfigMediaType is the OCI media type of the config of
+	// artifacts pushed by Flux.
+	CanonicalConfigMediaType types.MediaType = "application/vnd.cncf.flux.config.v1+json"
+
+	// CanonicalContentMediaType is the OCI media type of the content
+	// layers of artifacts pushed by Flux, holding a gzip compressed
+	// tarball.
+	CanonicalContentMediaType types.MediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+)
+
+const (
+	// HelmChartConfigMediaType is the OCI media type of the config of Helm
+	// chart artifacts, holding the chart metadata in JSON format.
+	HelmChartConfigMediaType types.MediaType = "application/vnd.cncf.helm.config.v1+json"
+
+	// HelmChartContentMediaType is the OCI media type of the layer of Helm
+	// chart artifacts holding the packaged chart.
+	HelmChartContentMediaType types.MediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+	// HelmChartProvenanceMediaType is the OCI media type of the layer of
+	// Helm chart artifacts holding the provenance file of the chart.
+	HelmChartProvenanceMediaType types.MediaType = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ocitestserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// ArtifactConfigMediaType is the media type of the config of the
+	// artifacts pushed with PushArtifact, as pushed by Flux.
+	ArtifactConfigMediaType types.MediaType = "application/vnd.cncf.flux.config.v1+json"
+
+	// ArtifactContentMediaType is the media type of the content layer of
+	// the artifacts pushed with PushArtifact, as pushed by Flux.
+	ArtifactContentMediaType types.MediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+)
+
+// PushArtifact pushes an artifact to the given repository with the given
+// tag, in the format of the artifacts pushed by Flux, with a content layer
+// holding the given files and the given manifest annotations. It returns
+// the digest of the artifact.
+func (r *Registry) PushArtifact(repo, tag string, files map[string][]byte, annotations map[string]string) (string, error) {
+	content, err := tarball(files)
+	if err != nil {
+		return "", err
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, ArtifactConfigMediaType)
+	img, err = mutate.Append(img, mutate.Addendum{Layer: static.NewLayer(content, ArtifactContentMediaType)})
+	if err != nil {
+		return "", err
+	}
+	if len(annotations) > 0 {
+		img = mutate.Annotations(img, annotations).(gcrv1.Image)
+	}
+	return r.PushImage(repo, tag, img)
+}
+
+// PushImage pushes the given image to the given repository with the given
+// tag, and returns its digest.
+func (r *Registry) PushImage(repo, tag string, img gcrv1.Image) (string, error) {
+	ref, err := name.NewTag(fmt.Sprintf("%s/%s:%s", r.Host(), repo, tag))
+	if err != nil {
+		return "", err
+	}
+	if err := remote.Write(ref, img, r.RemoteOptions()...); err != nil {
+		return "", err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// RemoteOptions returns the go-containerregistry options for accessing the
+// Registry, with its credentials and TLS configuration.
+func (r *Registry) RemoteOptions() []remote.Option {
+	auth := authn.Anonymous
+	if r.username != "" || r.password != "" {
+		auth = &authn.Basic{Username: r.username, Password: r.password}
+	}
+	transport := remote.DefaultTransport.Clone()
+	transport.TLSClientConfig = r.ClientTLSConfig()
+	return []remote.Option{remote.WithAuth(auth), remote.WithTransport(transport)}
+}
+
+// HTTPClient returns an HTTP client for sending requests to the Registry,
+// configured with its TLS configuration.
+func (r *Registry) HTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = r.ClientTLSConfig()
+	return &http.Client{Transport: transport}
+}
+
+// tarball returns a gzip compressed tarball holding the given files.
+func tarball(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name]))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ocitestserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// indexMediaType is the media type of the referrers API responses.
+	indexMediaType = "application/vnd.oci.image.index.v1+json"
+)
+
+var (
+	manifestPathRegex  = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+	referrersPathRegex = regexp.MustCompile(`^/v2/(.+)/referrers/([^/]+)$`)
+)
+
+// descriptor is the OCI descriptor of a manifest listed by the referrers
+// API.
+type descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest holds the fields of the OCI manifests used to index referrers.
+type manifest struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Config       descriptor        `json:"config"`
+	Subject      *descriptor       `json:"subject,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// serveRegistry serves the request with the registry API, and indexes the
+// manifests pushed with a subject for the referrers API.
+func (r *Registry) serveRegistry(w http.ResponseWriter, req *http.Request) {
+	m := manifestPathRegex.FindStringSubmatch(req.URL.Path)
+	if req.Method != http.MethodPut || m == nil {
+		r.app.ServeHTTP(w, req)
+		return
+	}
+
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	r.app.ServeHTTP(rec, req)
+	if rec.status != http.StatusCreated {
+		return
+	}
+
+	var mf manifest
+	if err := json.Unmarshal(data, &mf); err != nil || mf.Subject == nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	desc := descriptor{
+		MediaType:    mf.MediaType,
+		Digest:       "sha256:" + hex.EncodeToString(sum[:]),
+		Size:         int64(len(data)),
+		ArtifactType: mf.ArtifactType,
+		Annotations:  mf.Annotations,
+	}
+	if desc.ArtifactType == "" {
+		desc.ArtifactType = mf.Config.MediaType
+	}
+
+	key := m[1] + "@" + mf.Subject.Digest
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.subjects[key] {
+		if d.Digest == desc.Digest {
+			return
+		}
+	}
+	r.subjects[key] = append(r.subjects[key], desc)
+}
+
+// serveReferrers serves the referrers API requests, and returns false for
+// the other requests.
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request) bool {
+	m := referrersPathRegex.FindStringSubmatch(req.URL.Path)
+	if req.Method != http.MethodGet || m == nil {
+		return false
+	}
+
+	artifactType := req.URL.Query().Get("artifactType")
+	manifests := []descriptor{}
+	r.mu.Lock()
+	for _, d := range r.subjects[m[1]+"@"+m[2]] {
+		if artifactType == "" || d.ArtifactType == artifactType {
+			manifests = append(manifests, d)
+		}
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", indexMediaType)
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     indexMediaType,
+		"manifests":     manifests,
+	})
+	return true
+}
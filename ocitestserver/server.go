@@ -0,0 +1,327 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ocitestserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+const (
+	// tokenPath is the path of the token endpoint of the registry, when
+	// configured with bearer token authentication.
+	tokenPath = "/token"
+
+	// tokenService is the service of the bearer token challenges.
+	tokenService = "ocitestserver"
+)
+
+// Fault is an error response injected by the Registry.
+type Fault struct {
+	// Match returns true for the requests failing with the fault, all the
+	// requests fail if nil.
+	Match func(r *http.Request) bool
+	// StatusCode is the HTTP status code of the error response.
+	StatusCode int
+	// Count is the number of requests failing with the fault, all the
+	// matching requests fail if zero.
+	Count int
+}
+
+// Registry is an in-process OCI registry server for testing purposes, which
+// stores the artifacts in memory. It can require basic or bearer token
+// authentication, serve over HTTP/S, serve the OCI referrers API, and limit
+// the rate of requests or inject faults.
+type Registry struct {
+	app    http.Handler
+	server *httptest.Server
+	// clientTLS is the client TLS configuration trusting the registry
+	// certificate, if started with TLS.
+	clientTLS *tls.Config
+
+	username   string
+	password   string
+	bearerAuth bool
+	referrers  bool
+	rateLimit  int
+	ratePeriod time.Duration
+
+	mu sync.Mutex
+	// tokens are the bearer tokens issued by the token endpoint.
+	tokens map[string]struct{}
+	// subjects are the descriptors of the manifests pushed with a subject,
+	// keyed by repository and subject digest.
+	subjects    map[string][]descriptor
+	faults      []*Fault
+	requests    int
+	windowStart time.Time
+	windowCount int
+}
+
+// NewRegistry returns a Registry with an empty in-memory storage.
+func NewRegistry() *Registry {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	return &Registry{
+		app:      handlers.NewApp(context.Background(), config),
+		tokens:   make(map[string]struct{}),
+		subjects: make(map[string][]descriptor),
+	}
+}
+
+// WithBasicAuth configures the Registry to require basic authentication
+// with the given credentials.
+func (r *Registry) WithBasicAuth(username, password string) *Registry {
+	r.username = username
+	r.password = password
+	r.bearerAuth = false
+	return r
+}
+
+// WithBearerAuth configures the Registry to require bearer token
+// authentication, with tokens issued by its token endpoint in exchange for
+// the given credentials.
+func (r *Registry) WithBearerAuth(username, password string) *Registry {
+	r.username = username
+	r.password = password
+	r.bearerAuth = true
+	return r
+}
+
+// WithReferrersAPI configures the Registry to serve the OCI referrers API,
+// listing the manifests pushed with a subject. The API is not served by
+// default, like by registries which predate it.
+func (r *Registry) WithReferrersAPI(enabled bool) *Registry {
+	r.referrers = enabled
+	return r
+}
+
+// WithRateLimit configures the Registry to reject the requests exceeding
+// the given number of requests per period with an HTTP 429 error.
+func (r *Registry) WithRateLimit(requests int, period time.Duration) *Registry {
+	r.rateLimit = requests
+	r.ratePeriod = period
+	return r
+}
+
+// InjectFault configures the Registry to fail the requests matching the
+// given fault. It can be called while the Registry is running.
+func (r *Registry) InjectFault(fault Fault) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = append(r.faults, &fault)
+}
+
+// Start starts the Registry over HTTP.
+func (r *Registry) Start() {
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+}
+
+// StartTLS starts the Registry over HTTPS with the given certificate, key
+// and CA in PEM format. The server name is the name of the certificate
+// verified by the clients.
+func (r *Registry) StartTLS(cert, key, ca []byte, serverName string) error {
+	keyPair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return err
+	}
+	cp := x509.NewCertPool()
+	if !cp.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("invalid CA certificate")
+	}
+
+	r.server = httptest.NewUnstartedServer(http.HandlerFunc(r.serveHTTP))
+	r.server.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+	r.clientTLS = &tls.Config{RootCAs: cp, ServerName: serverName}
+	r.server.StartTLS()
+	return nil
+}
+
+// Stop stops the Registry, if started.
+func (r *Registry) Stop() {
+	if r.server != nil {
+		r.server.Close()
+	}
+}
+
+// URL returns the address the Registry is listening at, if started.
+func (r *Registry) URL() string {
+	if r.server != nil {
+		return r.server.URL
+	}
+	return ""
+}
+
+// Host returns the host of the Registry, used in the references of the
+// artifacts, if started.
+func (r *Registry) Host() string {
+	u := r.URL()
+	return u[strings.Index(u, "://")+len("://"):]
+}
+
+// Requests returns the number of requests received by the Registry.
+func (r *Registry) Requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+// ClientTLSConfig returns the TLS configuration for connecting to the
+// Registry, nil if not started with TLS.
+func (r *Registry) ClientTLSConfig() *tls.Config {
+	if r.clientTLS == nil {
+		return nil
+	}
+	return r.clientTLS.Clone()
+}
+
+// serveHTTP applies the rate limit, faults and authentication of the
+// Registry before serving the request with the registry API.
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if status, retryAfter := r.reject(req); status != 0 {
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		}
+		writeError(w, status, "UNAVAILABLE", "injected fault")
+		return
+	}
+
+	if req.URL.Path == tokenPath && r.bearerAuth {
+		r.serveToken(w, req)
+		return
+	}
+	if !r.authorized(req) {
+		if r.bearerAuth {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s%s",service="%s"`, r.URL(), tokenPath, tokenService))
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ocitestserver"`)
+		}
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	if r.referrers && r.serveReferrers(w, req) {
+		return
+	}
+	r.serveRegistry(w, req)
+}
+
+// reject returns the status code of the error response of the request if
+// it exceeds the rate limit or matches a fault, or zero otherwise. The
+// duration after which the rate limit resets is returned for HTTP 429
+// errors.
+func (r *Registry) reject(req *http.Request) (int, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+
+	if r.rateLimit > 0 {
+		now := time.Now()
+		if now.Sub(r.windowStart) >= r.ratePeriod {
+			r.windowStart = now
+			r.windowCount = 0
+		}
+		r.windowCount++
+		if r.windowCount > r.rateLimit {
+			return http.StatusTooManyRequests, r.ratePeriod - now.Sub(r.windowStart)
+		}
+	}
+
+	for i, f := range r.faults {
+		if f.Match != nil && !f.Match(req) {
+			continue
+		}
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				r.faults = append(r.faults[:i], r.faults[i+1:]...)
+			}
+		}
+		return f.StatusCode, 0
+	}
+	return 0, 0
+}
+
+// authorized returns true if the request is authenticated, or if the
+// Registry does not require authentication.
+func (r *Registry) authorized(req *http.Request) bool {
+	if r.username == "" && r.password == "" {
+		return true
+	}
+	if !r.bearerAuth {
+		username, password, ok := req.BasicAuth()
+		return ok && username == r.username && password == r.password
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.tokens[token]
+	return ok
+}
+
+// serveToken issues a bearer token in exchange for the credentials of the
+// Registry.
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	username, password, ok := req.BasicAuth()
+	if !ok || username != r.username || password != r.password {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(b)
+	r.mu.Lock()
+	r.tokens[token] = struct{}{}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   300,
+		"issued_at":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// writeError writes an error response in the format of the registry API.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
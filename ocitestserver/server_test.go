@@ -0,0 +1,351 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ocitestserver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestRegistry_PushArtifact(t *testing.T) {
+	srv := NewRegistry()
+	srv.Start()
+	defer srv.Stop()
+
+	digest, err := srv.PushArtifact("org/app", "v1.0.0",
+		map[string][]byte{"deploy.yaml": []byte("kind: Deployment")},
+		map[string]string{"org.opencontainers.image.revision": "main@sha1:abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.ParseReference(fmt.Sprintf("%s/org/app:v1.0.0", srv.Host()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := remote.Image(ref, srv.RemoteOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := img.Digest(); d.String() != digest {
+		t.Errorf("expected digest %s, got %s", digest, d)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Config.MediaType != ArtifactConfigMediaType {
+		t.Errorf("expected config media type %s, got %s", ArtifactConfigMediaType, manifest.Config.MediaType)
+	}
+	if len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != ArtifactContentMediaType {
+		t.Errorf("expected a single %s layer, got %v", ArtifactContentMediaType, manifest.Layers)
+	}
+	if got := manifest.Annotations["org.opencontainers.image.revision"]; got != "main@sha1:abc" {
+		t.Errorf("expected revision annotation, got %q", got)
+	}
+}
+
+func TestRegistry_Auth(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*Registry) *Registry
+	}{
+		{
+			name:  "basic",
+			setup: func(r *Registry) *Registry { return r.WithBasicAuth("user", "pass") },
+		},
+		{
+			name:  "bearer",
+			setup: func(r *Registry) *Registry { return r.WithBearerAuth("user", "pass") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tt.setup(NewRegistry())
+			srv.Start()
+			defer srv.Stop()
+
+			if _, err := srv.PushArtifact("app", "latest", map[string][]byte{"file": []byte("content")}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			ref, err := name.ParseReference(srv.Host() + "/app:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = remote.Head(ref)
+			var terr *transport.Error
+			if !errors.As(err, &terr) || terr.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected unauthorized error for anonymous request, got %v", err)
+			}
+			if _, err := remote.Head(ref, srv.RemoteOptions()...); err != nil {
+				t.Errorf("expected authenticated request to succeed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRegistry_StartTLS(t *testing.T) {
+	cert, key, ca := testCerts(t)
+
+	srv := NewRegistry()
+	if err := srv.StartTLS(cert, key, ca, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	if _, err := srv.PushArtifact("app", "latest", map[string][]byte{"file": []byte("content")}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := http.Get(srv.URL() + "/v2/"); err == nil {
+		t.Error("expected request without the CA certificate to fail")
+	}
+	resp, err := srv.HTTPClient().Get(srv.URL() + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestRegistry_WithReferrersAPI(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			srv := NewRegistry().WithReferrersAPI(enabled)
+			srv.Start()
+			defer srv.Stop()
+
+			subject, err := srv.PushArtifact("app", "v1", map[string][]byte{"file": []byte("content")}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			referrer := pushTestReferrer(t, srv, "app", subject, "application/spdx+json")
+
+			resp, err := srv.HTTPClient().Get(fmt.Sprintf("%s/v2/app/referrers/%s", srv.URL(), subject))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if !enabled {
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("expected status 404, got %d", resp.StatusCode)
+				}
+				return
+			}
+
+			var index gcrv1.IndexManifest
+			if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Manifests) != 1 || index.Manifests[0].Digest.String() != referrer {
+				t.Fatalf("expected referrer %s, got %v", referrer, index.Manifests)
+			}
+
+			resp, err = srv.HTTPClient().Get(fmt.Sprintf("%s/v2/app/referrers/%s?artifactType=other", srv.URL(), subject))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Manifests) != 0 || resp.Header.Get("OCI-Filters-Applied") != "artifactType" {
+				t.Errorf("expected no referrers with the filtered artifact type, got %v", index.Manifests)
+			}
+		})
+	}
+}
+
+func TestRegistry_WithRateLimit(t *testing.T) {
+	srv := NewRegistry().WithRateLimit(2, time.Minute)
+	srv.Start()
+	defer srv.Stop()
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, status := range want {
+		resp, err := http.Get(srv.URL() + "/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("request %d: expected status %d, got %d", i, status, resp.StatusCode)
+		}
+		if status == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Error("expected Retry-After header")
+		}
+	}
+}
+
+func TestRegistry_InjectFault(t *testing.T) {
+	srv := NewRegistry()
+	srv.Start()
+	defer srv.Stop()
+
+	srv.InjectFault(Fault{
+		Match:      func(r *http.Request) bool { return r.URL.Path == "/v2/" },
+		StatusCode: http.StatusServiceUnavailable,
+		Count:      1,
+	})
+
+	want := []int{http.StatusServiceUnavailable, http.StatusOK}
+	for i, status := range want {
+		resp, err := http.Get(srv.URL() + "/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("request %d: expected status %d, got %d", i, status, resp.StatusCode)
+		}
+	}
+	if srv.Requests() != 2 {
+		t.Errorf("expected 2 requests, got %d", srv.Requests())
+	}
+}
+
+// rawManifest is a manifest pushed as is to the registry.
+type rawManifest struct {
+	data      []byte
+	mediaType types.MediaType
+}
+
+func (m rawManifest) RawManifest() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m rawManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+// pushTestReferrer pushes a manifest of the given artifact type referring
+// to the given subject digest, and returns its digest.
+func pushTestReferrer(t *testing.T, srv *Registry, repo, subject, artifactType string) string {
+	t.Helper()
+
+	repoRef, err := name.NewRepository(srv.Host() + "/" + repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := static.NewLayer([]byte("{}"), types.MediaType(artifactType))
+	if err := remote.WriteLayer(repoRef, config, srv.RemoteOptions()...); err != nil {
+		t.Fatal(err)
+	}
+	configDesc, err := partial.Descriptor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjectDesc, err := remote.Head(repoRef.Digest(subject), srv.RemoteOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     types.OCIManifestSchema1,
+		"artifactType":  artifactType,
+		"config":        configDesc,
+		"layers":        []gcrv1.Descriptor{*configDesc},
+		"subject":       subjectDesc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _, err := gcrv1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Put(repoRef.Digest(digest.String()), rawManifest{data: data, mediaType: types.OCIManifestSchema1}, srv.RemoteOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest.String()
+}
+
+// testCerts returns a server certificate and key for "example.com" and
+// the CA certificate signing it, in PEM format.
+func testCerts(t *testing.T) ([]byte, []byte, []byte) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ocitestserver CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentSocketEnvVar is the environment variable holding the path of the
+// socket of the SSH agent.
+const AgentSocketEnvVar = "SSH_AUTH_SOCK"
+
+// AgentSocket returns the path of the socket of the SSH agent, as
+// configured with the SSH_AUTH_SOCK environment variable.
+func AgentSocket() string {
+	return os.Getenv(AgentSocketEnvVar)
+}
+
+// AgentSigners returns an ssh.Signer for each of the keys held by the SSH
+// agent listening on the given socket, including the certificates added
+// to the agent together with their private key.
+//
+// The agent is connected to for listing the keys, and every time a signer
+// signs data, so that no connection needs to be kept open in between.
+func AgentSigners(socket string) ([]ssh.Signer, error) {
+	if socket == "" {
+		return nil, fmt.Errorf("no SSH agent socket configured, %s is not set", AgentSocketEnvVar)
+	}
+	var keys []*agent.Key
+	if err := withAgent(socket, func(a agent.ExtendedAgent) error {
+		var err error
+		keys, err = a.List()
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, k := range keys {
+		pub, err := ssh.ParsePublicKey(k.Marshal())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH agent key '%s': %w", k.Comment, err)
+		}
+		signers = append(signers, &agentSigner{socket: socket, pub: pub})
+	}
+	return signers, nil
+}
+
+// AgentAuth returns an ssh.AuthMethod which authenticates with the keys
+// held by the SSH agent listening on the given socket.
+func AgentAuth(socket string) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		return AgentSigners(socket)
+	})
+}
+
+// agentSigner is an ssh.AlgorithmSigner for a key held by an SSH agent.
+type agentSigner struct {
+	socket string
+	pub    ssh.PublicKey
+}
+
+var _ ssh.AlgorithmSigner = &agentSigner{}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm signs the data with the agent. The agent has its own
+// entropy source, the rand argument is ignored.
+func (s *agentSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	// The algorithm of certificates can either be the certificate or the
+	// underlying key algorithm.
+	keyType := s.pub.Type()
+	if cert, ok := s.pub.(*ssh.Certificate); ok {
+		keyType = cert.Key.Type()
+	}
+
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case "", s.pub.Type(), keyType:
+	case ssh.KeyAlgoRSASHA256, ssh.CertAlgoRSASHA256v01:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512, ssh.CertAlgoRSASHA512v01:
+		flags = agent.SignatureFlagRsaSha512
+	default:
+		return nil, fmt.Errorf("unsupported SSH agent signature algorithm '%s'", algorithm)
+	}
+
+	var sig *ssh.Signature
+	err := withAgent(s.socket, func(a agent.ExtendedAgent) error {
+		var err error
+		sig, err = a.SignWithFlags(s.pub, data, flags)
+		return err
+	})
+	return sig, err
+}
+
+// withAgent calls fn with a client for the SSH agent listening on the
+// given socket, and closes the connection afterwards.
+func withAgent(socket string, fn func(agent.ExtendedAgent) error) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+	defer conn.Close()
+	return fn(agent.NewClient(conn))
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentAuth(t *testing.T) {
+	g := NewWithT(t)
+
+	ca, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	caSigner, err := ssh.ParsePrivateKey(ca.PrivateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	rsaKP, err := GenerateKeyPair(RSA_4096)
+	g.Expect(err).ToNot(HaveOccurred())
+	certKP, err := GenerateKeyPair(ECDSA_P256)
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, err := SignUserCertificate(ca, certKP.PublicKey, CertificateOptions{Principals: []string{"cert-user"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	unknownKP, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Accepts the RSA key for "rsa-user", and certificates signed by the
+	// CA.
+	rsaPub, _, _, _, err := ssh.ParseAuthorizedKey(rsaKP.PublicKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	certChecker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), caSigner.PublicKey().Marshal())
+		},
+		UserKeyFallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "rsa-user" && bytes.Equal(key.Marshal(), rsaPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key")
+		},
+	}
+	var mu sync.Mutex
+	var authenticated []string
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			perms, err := certChecker.Authenticate(conn, key)
+			if err == nil {
+				mu.Lock()
+				authenticated = append(authenticated, conn.User())
+				mu.Unlock()
+			}
+			return perms, err
+		},
+	}
+	hostKP, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	hostSigner, err := ssh.ParsePrivateKey(hostKP.PrivateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sConn, _, _, err := ssh.NewServerConn(conn, serverConfig); err == nil {
+					sConn.Close()
+				}
+			}()
+		}
+	}()
+	serverAddr := listener.Addr().String()
+
+	keyring := agent.NewKeyring()
+	for _, kp := range []*KeyPair{rsaKP, unknownKP} {
+		pk, err := ssh.ParseRawPrivateKey(kp.PrivateKey)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(keyring.Add(agent.AddedKey{PrivateKey: pk})).To(Succeed())
+	}
+	certPK, err := ssh.ParseRawPrivateKey(certKP.PrivateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	certPub, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(keyring.Add(agent.AddedKey{PrivateKey: certPK, Certificate: certPub.(*ssh.Certificate)})).To(Succeed())
+	socket := serveAgent(t, keyring)
+
+	t.Run("lists agent keys", func(t *testing.T) {
+		g := NewWithT(t)
+
+		signers, err := AgentSigners(socket)
+		g.Expect(err).ToNot(HaveOccurred())
+		var types []string
+		for _, s := range signers {
+			types = append(types, s.PublicKey().Type())
+		}
+		g.Expect(types).To(ConsistOf(ssh.KeyAlgoRSA, ssh.KeyAlgoED25519, ssh.CertAlgoECDSA256v01))
+	})
+
+	for _, user := range []string{"rsa-user", "cert-user"} {
+		t.Run("authenticates "+user, func(t *testing.T) {
+			g := NewWithT(t)
+
+			client, err := ssh.Dial("tcp", serverAddr, &ssh.ClientConfig{
+				User:            user,
+				Auth:            []ssh.AuthMethod{AgentAuth(socket)},
+				HostKeyCallback: ssh.FixedHostKey(hostSigner.PublicKey()),
+				Timeout:         5 * time.Second,
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+			client.Close()
+		})
+	}
+
+	t.Run("scans host key with agent auth", func(t *testing.T) {
+		g := NewWithT(t)
+
+		mu.Lock()
+		authenticated = nil
+		mu.Unlock()
+
+		kh, err := ScanHostKey(serverAddr, 5*time.Second, nil, false, WithAuth("cert-user", AgentAuth(socket)))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(string(kh)).To(ContainSubstring(ssh.KeyAlgoED25519))
+
+		mu.Lock()
+		defer mu.Unlock()
+		g.Expect(authenticated).To(Equal([]string{"cert-user"}))
+	})
+
+	t.Run("no agent socket", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := AgentSigners("")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(AgentSocketEnvVar + " is not set"))
+
+		_, err = AgentSigners(filepath.Join(t.TempDir(), "missing.sock"))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("failed to connect to SSH agent"))
+	})
+}
+
+// serveAgent serves the given agent on a Unix socket in a temporary
+// directory, and returns the path of the socket.
+func serveAgent(t *testing.T, a agent.Agent) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+	return socket
+}
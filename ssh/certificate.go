@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CertificateOptions holds the options used to issue an OpenSSH
+// certificate.
+type CertificateOptions struct {
+	// KeyID is the identifier of the certificate, logged by the server
+	// on authentication.
+	KeyID string
+	// Principals are the user names (for user certificates) the
+	// certificate is valid for. An empty list means the certificate is
+	// valid for any principal.
+	Principals []string
+	// ValidAfter is the time from which the certificate is valid.
+	// Defaults to one minute ago.
+	ValidAfter time.Time
+	// ValidBefore is the time until which the certificate is valid.
+	// Defaults to one hour from now.
+	ValidBefore time.Time
+	// Extensions are the certificate extensions, e.g. "permit-pty".
+	// Defaults to the extensions set by ssh-keygen for user certificates.
+	Extensions map[string]string
+}
+
+// defaultUserCertExtensions are the extensions ssh-keygen sets by default
+// on user certificates.
+var defaultUserCertExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// SignUserCertificate issues an OpenSSH user certificate for the given
+// public key (in authorized_keys format), signed by the private key of the
+// given certificate authority KeyPair. The certificate is returned in
+// authorized_keys format, as written by ssh-keygen to "<key>-cert.pub".
+func SignUserCertificate(ca *KeyPair, publicKey []byte, opts CertificateOptions) ([]byte, error) {
+	if ca == nil {
+		return nil, fmt.Errorf("certificate authority key pair is required")
+	}
+	caSigner, err := ssh.ParsePrivateKey(ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate authority private key: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	now := time.Now()
+	if opts.ValidAfter.IsZero() {
+		opts.ValidAfter = now.Add(-time.Minute)
+	}
+	if opts.ValidBefore.IsZero() {
+		opts.ValidBefore = now.Add(time.Hour)
+	}
+	if opts.Extensions == nil {
+		opts.Extensions = defaultUserCertExtensions
+	}
+
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           opts.KeyID,
+		ValidPrincipals: opts.Principals,
+		ValidAfter:      uint64(opts.ValidAfter.Unix()),
+		ValidBefore:     uint64(opts.ValidBefore.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: opts.Extensions,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return ssh.MarshalAuthorizedKey(cert), nil
+}
+
+// NewCertSigner returns an ssh.Signer which authenticates using the given
+// OpenSSH certificate (in authorized_keys format), for which the private
+// key is held by the given signer.
+func NewCertSigner(signer ssh.Signer, certificate []byte) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse certificate: public key of type '%s' is not a certificate", pub.Type())
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signer: %w", err)
+	}
+	return certSigner, nil
+}
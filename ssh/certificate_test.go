@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignUserCertificate(t *testing.T) {
+	tests := []struct {
+		name       string
+		caType     KeyPairType
+		principals []string
+		user       string
+		validAfter time.Time
+		wantErr    string
+	}{
+		{name: "ED25519 CA", caType: ED25519, principals: []string{"git"}, user: "git"},
+		{name: "ECDSA P256 CA", caType: ECDSA_P256, principals: []string{"git"}, user: "git"},
+		{name: "RSA 4096 CA", caType: RSA_4096, principals: []string{"git"}, user: "git"},
+		{name: "principal mismatch", caType: ED25519, principals: []string{"admin"}, user: "git", wantErr: "ssh: handshake failed"},
+		{name: "not yet valid", caType: ED25519, user: "git", validAfter: time.Now().Add(time.Hour), wantErr: "ssh: handshake failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ca, err := GenerateKeyPair(tt.caType)
+			g.Expect(err).ToNot(HaveOccurred())
+			caPub, _, _, _, err := ssh.ParseAuthorizedKey(ca.PublicKey)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			kp, err := GenerateKeyPair(ED25519)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			certBytes, err := SignUserCertificate(ca, kp.PublicKey, CertificateOptions{
+				KeyID:      "test",
+				Principals: tt.principals,
+				ValidAfter: tt.validAfter,
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(certBytes)).To(HavePrefix(ssh.CertAlgoED25519v01))
+
+			keySigner, err := ssh.ParsePrivateKey(kp.PrivateKey)
+			g.Expect(err).ToNot(HaveOccurred())
+			signer, err := NewCertSigner(keySigner, certBytes)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			checker := &ssh.CertChecker{
+				IsUserAuthority: func(auth ssh.PublicKey) bool {
+					return bytes.Equal(auth.Marshal(), caPub.Marshal())
+				},
+			}
+			serverConfig := &ssh.ServerConfig{
+				PublicKeyCallback: checker.Authenticate,
+			}
+			hkp, err := GenerateKeyPair(ED25519)
+			g.Expect(err).ToNot(HaveOccurred())
+			hostSigner, err := ssh.ParsePrivateKey(hkp.PrivateKey)
+			g.Expect(err).ToNot(HaveOccurred())
+			serverConfig.AddHostKey(hostSigner)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			g.Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				ssh.NewServerConn(conn, serverConfig)
+			}()
+
+			client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+				User:            tt.user,
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         5 * time.Second,
+			})
+			if tt.wantErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				client.Close()
+			} else {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+			}
+		})
+	}
+}
+
+func TestNewCertSigner(t *testing.T) {
+	g := NewWithT(t)
+
+	ca, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	kp, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	other, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	certBytes, err := SignUserCertificate(ca, kp.PublicKey, CertificateOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	otherSigner, err := ssh.ParsePrivateKey(other.PrivateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = NewCertSigner(otherSigner, certBytes)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("failed to create certificate signer"))
+
+	_, err = NewCertSigner(otherSigner, kp.PublicKey)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("is not a certificate"))
+}
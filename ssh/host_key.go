@@ -26,6 +26,22 @@ import (
 	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+// ScanHostKeyOption configures the ssh.ClientConfig used to scan the host
+// keys of a host.
+type ScanHostKeyOption func(*ssh.ClientConfig)
+
+// WithAuth configures the scan to authenticate as the given user with the
+// given auth methods, e.g. AgentAuth. The host key is collected before
+// authentication takes place, but authenticating lets the scan complete
+// the handshake instead of leaving a failed authentication attempt in the
+// logs of the host.
+func WithAuth(user string, methods ...ssh.AuthMethod) ScanHostKeyOption {
+	return func(config *ssh.ClientConfig) {
+		config.User = user
+		config.Auth = methods
+	}
+}
+
 // ScanHostKey collects the given host's preferred public key for the
 // host. Any errors (e.g. authentication  failures) are ignored, except
 // if no key could be collected from the host.
@@ -33,7 +49,7 @@ import (
 // clientHostKeyAlgos defines what HostKey algorithms to be
 // used by the ssh client when using `ssh.Dial`. The default is
 // empty, which defaults to Golang's preferred HostKey algorithms.
-func ScanHostKey(host string, timeout time.Duration, clientHostKeyAlgos []string, hashKeys bool) ([]byte, error) {
+func ScanHostKey(host string, timeout time.Duration, clientHostKeyAlgos []string, hashKeys bool, opts ...ScanHostKeyOption) ([]byte, error) {
 	col := &HostKeyCollector{hashKeys: hashKeys}
 	config := &ssh.ClientConfig{
 		HostKeyCallback: col.StoreKey(),
@@ -44,6 +60,9 @@ func ScanHostKey(host string, timeout time.Duration, clientHostKeyAlgos []string
 	if len(clientHostKeyAlgos) > 0 {
 		config.HostKeyAlgorithms = clientHostKeyAlgos
 	}
+	for _, opt := range opts {
+		opt(config)
+	}
 
 	client, err := ssh.Dial("tcp", host, config)
 	if err == nil {
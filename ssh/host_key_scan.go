@@ -0,0 +1,315 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHFP algorithm numbers, as registered with IANA.
+//
+// Ref: https://www.iana.org/assignments/dns-sshfp-rr-parameters/dns-sshfp-rr-parameters.xhtml
+const (
+	SSHFPAlgorithmRSA     uint8 = 1
+	SSHFPAlgorithmDSA     uint8 = 2
+	SSHFPAlgorithmECDSA   uint8 = 3
+	SSHFPAlgorithmEd25519 uint8 = 4
+)
+
+// SSHFP fingerprint type numbers, as registered with IANA.
+const (
+	SSHFPFingerprintSHA1   uint8 = 1
+	SSHFPFingerprintSHA256 uint8 = 2
+)
+
+// SSHFPRecord is a DNS SSHFP resource record, as defined in RFC 4255.
+type SSHFPRecord struct {
+	Algorithm       uint8
+	FingerprintType uint8
+	Fingerprint     []byte
+}
+
+// SSHFPResolver looks up the SSHFP records of a host.
+type SSHFPResolver interface {
+	LookupSSHFP(host string) ([]SSHFPRecord, error)
+}
+
+// SSHFPResolverFunc is an adapter to allow the use of ordinary functions
+// as SSHFPResolver.
+type SSHFPResolverFunc func(host string) ([]SSHFPRecord, error)
+
+// LookupSSHFP calls f(host).
+func (f SSHFPResolverFunc) LookupSSHFP(host string) ([]SSHFPRecord, error) {
+	return f(host)
+}
+
+// ScanOptions holds the options for ScanHostKeys.
+type ScanOptions struct {
+	// Timeout is the timeout of each SSH handshake.
+	Timeout time.Duration
+	// HostKeyAlgos holds the host key algorithms the SSH client advertises
+	// to the server, e.g. the HostKeyAlgos of the
+	// github.com/fluxcd/pkg/git package. A key is collected for each key
+	// type of the algorithms. If empty, Go's default is used instead, and a
+	// single key is collected.
+	HostKeyAlgos []string
+	// HashHosts writes the host of the known_hosts entries hashed.
+	HashHosts bool
+	// SSHFPResolver, if set, is used to verify the collected keys against
+	// the SSHFP DNS records of the host.
+	SSHFPResolver SSHFPResolver
+	// ClientOptions configure the SSH client used for every handshake,
+	// e.g. WithAuth.
+	ClientOptions []ScanHostKeyOption
+}
+
+// ScanHostKeys collects the public keys of the given host for each of the
+// key types of the configured host key algorithms, and returns them in
+// known_hosts format. Unlike ScanHostKey, which performs a single handshake
+// and hence collects a single key, a handshake is performed for every key
+// type, offering the configured algorithms of that type. Key types not
+// offered by the host are skipped, but an error is returned if no key could
+// be collected.
+//
+// If an SSHFPResolver is configured, the keys are verified against the
+// SSHFP records of the host. A key for which records exist for its
+// algorithm, but none of them match, results in an error. Keys for which no
+// records exist are left out, and an error is returned if no key could be
+// verified.
+func ScanHostKeys(host string, opts ScanOptions) ([]byte, error) {
+	// The algorithms are grouped by key type, e.g. the RSA key is
+	// collected with a single handshake for rsa-sha2-512, rsa-sha2-256 and
+	// ssh-rsa.
+	var keyTypes []string
+	algosByType := make(map[string][]string)
+	for _, algo := range opts.HostKeyAlgos {
+		t := hostKeyType(algo)
+		if _, ok := algosByType[t]; !ok {
+			keyTypes = append(keyTypes, t)
+		}
+		algosByType[t] = append(algosByType[t], algo)
+	}
+	if len(keyTypes) == 0 {
+		keyTypes = []string{""}
+	}
+
+	var keys []ssh.PublicKey
+	var errs []string
+	for _, keyType := range keyTypes {
+		algos := algosByType[keyType]
+		key, err := scanHostKeyForAlgos(host, algos, opts.Timeout, opts.ClientOptions)
+		if err != nil {
+			// There is no point in trying other algorithms if the host
+			// can not be reached.
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Op == "dial" {
+				return nil, err
+			}
+			if len(algos) > 0 {
+				err = fmt.Errorf("%s: %w", strings.Join(algos, ","), err)
+			}
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !containsKey(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("failed to collect any host key from '%s': %s", host, strings.Join(errs, ", "))
+	}
+
+	if opts.SSHFPResolver != nil {
+		var err error
+		if keys, err = verifySSHFP(host, keys, opts.SSHFPResolver); err != nil {
+			return nil, err
+		}
+	}
+
+	var knownHosts []byte
+	for _, key := range keys {
+		h := knownhosts.Normalize(host)
+		if opts.HashHosts {
+			h = knownhosts.HashHostname(h)
+		}
+		knownHosts = append(knownHosts,
+			fmt.Sprintf("%s %s %s\n", h, key.Type(), base64.StdEncoding.EncodeToString(key.Marshal()))...)
+	}
+	return knownHosts, nil
+}
+
+// hostKeyType returns the type of the keys of the given host key
+// algorithm, as the RSA keys are used with several signature algorithms.
+func hostKeyType(algo string) string {
+	switch algo {
+	case ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512:
+		return ssh.KeyAlgoRSA
+	case ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01:
+		return ssh.CertAlgoRSAv01
+	default:
+		return algo
+	}
+}
+
+// scanHostKeyForAlgos performs an SSH handshake with the host offering only
+// the given host key algorithms, or Go's default if empty, and returns the
+// host key presented.
+func scanHostKeyForAlgos(host string, algos []string, timeout time.Duration, clientOpts []ScanHostKeyOption) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return nil
+		},
+		HostKeyAlgorithms: algos,
+		Timeout:           timeout,
+	}
+	config.SetDefaults()
+	for _, opt := range clientOpts {
+		opt(config)
+	}
+
+	client, err := ssh.Dial("tcp", host, config)
+	if err == nil {
+		client.Close()
+	}
+	// Authentication failures are expected, unless auth methods are
+	// configured. The host key is verified before authentication.
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	if err == nil {
+		err = errors.New("no host key presented")
+	}
+	return nil, err
+}
+
+// verifySSHFP verifies the given keys against the SSHFP records of the
+// host, and returns the keys which could be verified.
+func verifySSHFP(host string, keys []ssh.PublicKey, resolver SSHFPResolver) ([]ssh.PublicKey, error) {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	records, err := resolver.LookupSSHFP(hostname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup SSHFP records for '%s': %w", hostname, err)
+	}
+
+	var verified []ssh.PublicKey
+	for _, key := range keys {
+		algo, ok := sshfpAlgorithm(key)
+		if !ok {
+			continue
+		}
+		found, matched := false, false
+		for _, r := range records {
+			if r.Algorithm != algo {
+				continue
+			}
+			fp, ok := sshfpFingerprint(key, r.FingerprintType)
+			if !ok {
+				continue
+			}
+			found = true
+			if bytes.Equal(fp, r.Fingerprint) {
+				matched = true
+				break
+			}
+		}
+		if found && !matched {
+			return nil, fmt.Errorf("host key '%s' of '%s' does not match any SSHFP record",
+				ssh.FingerprintSHA256(key), hostname)
+		}
+		if matched {
+			verified = append(verified, key)
+		}
+	}
+	if len(verified) == 0 {
+		return nil, fmt.Errorf("no host key of '%s' could be verified with SSHFP records", hostname)
+	}
+	return verified, nil
+}
+
+// sshfpAlgorithm returns the SSHFP algorithm number for the given key.
+func sshfpAlgorithm(key ssh.PublicKey) (uint8, bool) {
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		return SSHFPAlgorithmRSA, true
+	case ssh.KeyAlgoDSA:
+		return SSHFPAlgorithmDSA, true
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return SSHFPAlgorithmECDSA, true
+	case ssh.KeyAlgoED25519:
+		return SSHFPAlgorithmEd25519, true
+	default:
+		return 0, false
+	}
+}
+
+// sshfpFingerprint returns the fingerprint of the given key for the SSHFP
+// fingerprint type.
+func sshfpFingerprint(key ssh.PublicKey, fpType uint8) ([]byte, bool) {
+	switch fpType {
+	case SSHFPFingerprintSHA1:
+		sum := sha1.Sum(key.Marshal())
+		return sum[:], true
+	case SSHFPFingerprintSHA256:
+		sum := sha256.Sum256(key.Marshal())
+		return sum[:], true
+	default:
+		return nil, false
+	}
+}
+
+// NewSSHFPRecord returns the SSHFP record for the given public key (in
+// authorized_keys format) with the given fingerprint type.
+func NewSSHFPRecord(publicKey []byte, fpType uint8) (*SSHFPRecord, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	algo, ok := sshfpAlgorithm(key)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type for SSHFP: %s", key.Type())
+	}
+	fp, ok := sshfpFingerprint(key, fpType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSHFP fingerprint type: %d", fpType)
+	}
+	return &SSHFPRecord{Algorithm: algo, FingerprintType: fpType, Fingerprint: fp}, nil
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestScanHostKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	sshConfig := &ssh.ServerConfig{
+		NoClientAuth: true,
+	}
+	hostKeys := map[KeyPairType]*KeyPair{}
+	for _, keyType := range []KeyPairType{ED25519, ECDSA_P256, RSA_4096} {
+		hkp, err := GenerateKeyPair(keyType)
+		g.Expect(err).NotTo(HaveOccurred())
+		signer, err := ssh.ParsePrivateKey(hkp.PrivateKey)
+		g.Expect(err).NotTo(HaveOccurred())
+		sshConfig.AddHostKey(signer)
+		hostKeys[keyType] = hkp
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer listener.Close()
+	var handshakes int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&handshakes, 1)
+			go func() {
+				if sConn, _, _, err := ssh.NewServerConn(conn, sshConfig); err == nil {
+					sConn.Close()
+				}
+			}()
+		}
+	}()
+	serverAddr := listener.Addr().String()
+
+	sshfpRecords := func(keyTypes ...KeyPairType) SSHFPResolver {
+		return SSHFPResolverFunc(func(host string) ([]SSHFPRecord, error) {
+			if host != "127.0.0.1" {
+				return nil, fmt.Errorf("unexpected host '%s'", host)
+			}
+			var records []SSHFPRecord
+			for _, keyType := range keyTypes {
+				r, err := NewSSHFPRecord(hostKeys[keyType].PublicKey, SSHFPFingerprintSHA256)
+				if err != nil {
+					return nil, err
+				}
+				records = append(records, *r)
+			}
+			return records, nil
+		})
+	}
+
+	algos := []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+	}
+
+	tests := []struct {
+		name           string
+		opts           ScanOptions
+		wantTypes      []string
+		wantHandshakes int32
+		wantErr        string
+	}{
+		{
+			name:      "collects all host keys",
+			opts:      ScanOptions{HostKeyAlgos: algos},
+			wantTypes: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoRSA},
+			// The RSA key is collected with a single handshake.
+			wantHandshakes: 5,
+		},
+		{
+			name:           "collects a single host key with the default algorithms",
+			wantHandshakes: 1,
+		},
+		{
+			name:      "collects host keys for configured algorithms",
+			opts:      ScanOptions{HostKeyAlgos: []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384}},
+			wantTypes: []string{ssh.KeyAlgoECDSA256},
+		},
+		{
+			name:      "hashes hosts",
+			opts:      ScanOptions{HostKeyAlgos: algos, HashHosts: true},
+			wantTypes: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoRSA},
+		},
+		{
+			name:    "no host key for configured algorithms",
+			opts:    ScanOptions{HostKeyAlgos: []string{ssh.KeyAlgoECDSA521}},
+			wantErr: "failed to collect any host key",
+		},
+		{
+			name:      "verifies host keys with SSHFP records",
+			opts:      ScanOptions{HostKeyAlgos: algos, SSHFPResolver: sshfpRecords(ED25519, RSA_4096)},
+			wantTypes: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSA},
+		},
+		{
+			name: "SSHFP record mismatch",
+			opts: ScanOptions{HostKeyAlgos: algos, SSHFPResolver: SSHFPResolverFunc(func(string) ([]SSHFPRecord, error) {
+				return []SSHFPRecord{{Algorithm: SSHFPAlgorithmEd25519, FingerprintType: SSHFPFingerprintSHA256, Fingerprint: []byte("invalid")}}, nil
+			})},
+			wantErr: "does not match any SSHFP record",
+		},
+		{
+			name:    "no SSHFP records",
+			opts:    ScanOptions{HostKeyAlgos: algos, SSHFPResolver: sshfpRecords()},
+			wantErr: "could be verified with SSHFP records",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			atomic.StoreInt32(&handshakes, 0)
+			tt.opts.Timeout = 5 * time.Second
+			kh, err := ScanHostKeys(serverAddr, tt.opts)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.wantHandshakes > 0 {
+				g.Expect(atomic.LoadInt32(&handshakes)).To(Equal(tt.wantHandshakes))
+			}
+
+			lines := strings.Split(strings.TrimSpace(string(kh)), "\n")
+			if tt.wantTypes == nil {
+				g.Expect(lines).To(HaveLen(1))
+				return
+			}
+			g.Expect(lines).To(HaveLen(len(tt.wantTypes)))
+			for i, line := range lines {
+				fields := strings.Fields(line)
+				g.Expect(fields).To(HaveLen(3))
+				if tt.opts.HashHosts {
+					g.Expect(fields[0]).To(HavePrefix("|1|"))
+				} else {
+					g.Expect(fields[0]).To(Equal("[127.0.0.1]:" + strings.Split(serverAddr, ":")[1]))
+				}
+				g.Expect(fields[1]).To(Equal(tt.wantTypes[i]))
+			}
+		})
+	}
+}
+
+func TestScanHostKeys_unreachable(t *testing.T) {
+	g := NewWithT(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = ScanHostKeys(addr, ScanOptions{Timeout: time.Second})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("connection refused"))
+}
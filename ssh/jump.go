@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHost is an SSH server through which connections to other hosts are
+// tunneled, like a host configured with the ProxyJump option of OpenSSH.
+type JumpHost struct {
+	// Addr is the address of the jump host, in the form "host:port".
+	Addr string
+	// Config is the client configuration used to connect to the jump host,
+	// including its authentication methods and host key callback.
+	Config *ssh.ClientConfig
+}
+
+// DialContextFunc dials the given address on the named network.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialThroughJumpHosts connects to the given address through the given
+// chain of jump hosts. The first jump host is dialed with the given dial
+// function, or a net.Dialer if nil, and every next host (including the
+// target address) is reached through a TCP/IP forwarding channel of the
+// previous jump host. The deadline of the context applies to the
+// connection to all the jump hosts.
+//
+// Closing the returned net.Conn also closes the connections to the jump
+// hosts.
+func DialThroughJumpHosts(ctx context.Context, dial DialContextFunc, addr string, jumpHosts []JumpHost) (net.Conn, error) {
+	if len(jumpHosts) == 0 {
+		return nil, fmt.Errorf("at least one jump host is required")
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", jumpHosts[0].Addr)
+	if err != nil {
+		return nil, err
+	}
+	// The connections to the other jump hosts are tunneled through the
+	// first one, hence the deadline of the first connection applies to all
+	// the handshakes.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	first := conn
+
+	var clients []*ssh.Client
+	for i, jh := range jumpHosts {
+		if i > 0 {
+			if conn, err = clients[i-1].Dial("tcp", jh.Addr); err != nil {
+				closeClients(clients)
+				return nil, fmt.Errorf("failed to dial jump host '%s' through '%s': %w", jh.Addr, jumpHosts[i-1].Addr, err)
+			}
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, jh.Addr, jh.Config)
+		if err != nil {
+			conn.Close()
+			closeClients(clients)
+			return nil, fmt.Errorf("failed to connect to jump host '%s': %w", jh.Addr, err)
+		}
+		clients = append(clients, ssh.NewClient(c, chans, reqs))
+	}
+
+	conn, err = clients[len(clients)-1].Dial("tcp", addr)
+	if err != nil {
+		closeClients(clients)
+		return nil, fmt.Errorf("failed to dial '%s' through jump host '%s': %w", addr, jumpHosts[len(jumpHosts)-1].Addr, err)
+	}
+	_ = first.SetDeadline(time.Time{})
+	return &jumpConn{Conn: conn, clients: clients}, nil
+}
+
+// jumpConn is a net.Conn tunneled through a chain of jump hosts.
+type jumpConn struct {
+	net.Conn
+	clients []*ssh.Client
+}
+
+// Close closes the connection and the connections to the jump hosts.
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	closeClients(c.clients)
+	return err
+}
+
+// closeClients closes the given clients in reverse order.
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		_ = clients[i].Close()
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialThroughJumpHosts(t *testing.T) {
+	g := NewWithT(t)
+
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	echoAddr := echoListener.Addr().String()
+
+	clientKP, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	clientSigner, err := ssh.ParsePrivateKey(clientKP.PrivateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	jump1Addr, jump1Key, jump1Conns := startJumpServer(t)
+	jump2Addr, jump2Key, jump2Conns := startJumpServer(t)
+
+	clientConfig := func(hostKey ssh.PublicKey) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            "jump",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		jumpHosts []JumpHost
+		addr      string
+		wantErr   string
+	}{
+		{
+			name:      "single jump host",
+			jumpHosts: []JumpHost{{Addr: jump1Addr, Config: clientConfig(jump1Key)}},
+			addr:      echoAddr,
+		},
+		{
+			name: "chain of jump hosts",
+			jumpHosts: []JumpHost{
+				{Addr: jump1Addr, Config: clientConfig(jump1Key)},
+				{Addr: jump2Addr, Config: clientConfig(jump2Key)},
+			},
+			addr: echoAddr,
+		},
+		{
+			name: "host key mismatch of jump host",
+			jumpHosts: []JumpHost{
+				{Addr: jump1Addr, Config: clientConfig(jump1Key)},
+				{Addr: jump2Addr, Config: clientConfig(jump1Key)},
+			},
+			addr:    echoAddr,
+			wantErr: "failed to connect to jump host '" + jump2Addr + "'",
+		},
+		{
+			name:      "unreachable target",
+			jumpHosts: []JumpHost{{Addr: jump1Addr, Config: clientConfig(jump1Key)}},
+			addr:      "127.0.0.1:1",
+			wantErr:   "failed to dial '127.0.0.1:1' through jump host '" + jump1Addr + "'",
+		},
+		{
+			name:    "no jump hosts",
+			addr:    echoAddr,
+			wantErr: "at least one jump host is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := DialThroughJumpHosts(ctx, nil, tt.addr, tt.jumpHosts)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+
+			_, err = conn.Write([]byte("ping"))
+			g.Expect(err).ToNot(HaveOccurred())
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(buf)).To(Equal("ping"))
+
+			g.Expect(conn.Close()).To(Succeed())
+		})
+	}
+
+	// All the connections to the jump hosts are closed.
+	g.Eventually(func() int32 {
+		return atomic.LoadInt32(jump1Conns) + atomic.LoadInt32(jump2Conns)
+	}, 5*time.Second).Should(BeZero())
+}
+
+// startJumpServer starts an SSH server which accepts any public key and
+// only allows TCP/IP forwarding. It returns the address and host key of
+// the server, and a counter of the open connections.
+func startJumpServer(t *testing.T) (string, ssh.PublicKey, *int32) {
+	t.Helper()
+	g := NewWithT(t)
+
+	hostKP, err := GenerateKeyPair(ED25519)
+	g.Expect(err).ToNot(HaveOccurred())
+	hostSigner, err := ssh.ParsePrivateKey(hostKP.PrivateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	t.Cleanup(func() { listener.Close() })
+
+	var open int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				atomic.AddInt32(&open, 1)
+				defer atomic.AddInt32(&open, -1)
+				go ssh.DiscardRequests(reqs)
+				for newChan := range chans {
+					var payload struct {
+						Addr       string
+						Port       uint32
+						OriginAddr string
+						OriginPort uint32
+					}
+					if newChan.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChan.ExtraData(), &payload) != nil {
+						newChan.Reject(ssh.Prohibited, "only TCP/IP forwarding is allowed")
+						continue
+					}
+					target, err := net.Dial("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+					if err != nil {
+						newChan.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, chReqs, err := newChan.Accept()
+					if err != nil {
+						target.Close()
+						continue
+					}
+					go ssh.DiscardRequests(chReqs)
+					go func() {
+						defer ch.Close()
+						io.Copy(ch, target)
+					}()
+					go func() {
+						defer target.Close()
+						io.Copy(target, ch)
+					}()
+				}
+				sConn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String(), hostSigner.PublicKey(), &open
+}
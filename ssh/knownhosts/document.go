@@ -0,0 +1,298 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Marker is the optional marker of a known_hosts entry.
+type Marker string
+
+const (
+	// MarkerNone is used for regular host key entries.
+	MarkerNone Marker = ""
+	// MarkerCertAuthority indicates the key of the entry is a certificate
+	// authority trusted to sign host certificates.
+	MarkerCertAuthority Marker = markerCert
+	// MarkerRevoked indicates the key of the entry is revoked.
+	MarkerRevoked Marker = markerRevoked
+)
+
+// Entry is a host key entry of a known_hosts document.
+type Entry struct {
+	// Marker is the optional marker of the entry.
+	Marker Marker
+	// Hosts are the host patterns of the entry, either plain or hashed.
+	Hosts []string
+	// Key is the public key of the entry.
+	Key ssh.PublicKey
+	// Comment is the optional trailing comment of the entry.
+	Comment string
+}
+
+// String returns the entry as known_hosts line, without line ending.
+func (e Entry) String() string {
+	var b strings.Builder
+	if e.Marker != MarkerNone {
+		b.WriteString(string(e.Marker))
+		b.WriteByte(' ')
+	}
+	b.WriteString(strings.Join(e.Hosts, ","))
+	b.WriteByte(' ')
+	b.WriteString(e.Key.Type())
+	b.WriteByte(' ')
+	b.WriteString(base64.StdEncoding.EncodeToString(e.Key.Marshal()))
+	if e.Comment != "" {
+		b.WriteByte(' ')
+		b.WriteString(e.Comment)
+	}
+	return b.String()
+}
+
+// MatchesHost returns true if any of the host patterns of the entry equals
+// the given host, or is a hash of it. The host is normalized before
+// matching, e.g. "example.com:22" matches "example.com" and
+// "example.com:2222" matches "[example.com]:2222". Wildcard and negated
+// patterns are not considered a match.
+func (e Entry) MatchesHost(host string) bool {
+	return e.hostIndex(knownhosts.Normalize(host)) >= 0
+}
+
+// hostIndex returns the index of the host pattern matching the normalized
+// host, or -1.
+func (e Entry) hostIndex(host string) int {
+	for i, h := range e.Hosts {
+		if h == "" {
+			continue
+		}
+		if h[0] == '|' {
+			if match, _ := matchHashedHost(h, host); match {
+				return i
+			}
+			continue
+		}
+		if h == host {
+			return i
+		}
+	}
+	return -1
+}
+
+// line is a line of a known_hosts document. Lines which are not host key
+// entries (comments, blank lines) only have raw set. Lines which have not
+// been modified are written back as raw.
+type line struct {
+	raw   string
+	entry *Entry
+}
+
+// Document is an editable known_hosts document. Comments, blank lines and
+// the formatting of entries which are not modified are preserved.
+type Document struct {
+	lines []line
+}
+
+// ParseDocument parses the given known_hosts content into a Document.
+func ParseDocument(b []byte) (*Document, error) {
+	doc := &Document{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		raw := scanner.Text()
+		marker, hosts, key, comment, _, err := ssh.ParseKnownHosts([]byte(raw))
+		if err != nil {
+			// Lines that aren't host public keys result in EOF, like a
+			// comment line.
+			if err == io.EOF {
+				doc.lines = append(doc.lines, line{raw: raw})
+				continue
+			}
+			return nil, fmt.Errorf("failed to parse known_hosts line %d: %w", lineNum, err)
+		}
+		e := &Entry{Hosts: hosts, Key: key, Comment: comment}
+		if marker != "" {
+			e.Marker = Marker("@" + marker)
+		}
+		doc.lines = append(doc.lines, line{raw: raw, entry: e})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Entries returns a copy of all the host key entries of the document.
+func (d *Document) Entries() []Entry {
+	var entries []Entry
+	for _, l := range d.lines {
+		if l.entry != nil {
+			entries = append(entries, copyEntry(*l.entry))
+		}
+	}
+	return entries
+}
+
+// Lookup returns a copy of the entries with the given marker which match
+// the given host.
+func (d *Document) Lookup(host string, marker Marker) []Entry {
+	var entries []Entry
+	for _, l := range d.lines {
+		if l.entry != nil && l.entry.Marker == marker && l.entry.MatchesHost(host) {
+			entries = append(entries, copyEntry(*l.entry))
+		}
+	}
+	return entries
+}
+
+// Add appends the given entry to the document.
+func (d *Document) Add(e Entry) error {
+	if len(e.Hosts) == 0 {
+		return fmt.Errorf("entry must have at least one host")
+	}
+	if e.Key == nil {
+		return fmt.Errorf("entry must have a key")
+	}
+	switch e.Marker {
+	case MarkerNone, MarkerCertAuthority, MarkerRevoked:
+	default:
+		return fmt.Errorf("unsupported marker '%s'", e.Marker)
+	}
+	e = copyEntry(e)
+	d.lines = append(d.lines, line{entry: &e})
+	return nil
+}
+
+// AddHost appends an entry for the given host and key to the document. The
+// host is normalized, and hashed if hash is true.
+func (d *Document) AddHost(host string, key ssh.PublicKey, marker Marker, hash bool) error {
+	return d.Add(newHostEntry(host, key, marker, hash))
+}
+
+// Remove removes the given host from all the entries with the given marker,
+// and returns the number of entries modified. Entries without any host left
+// are removed from the document, while entries which also list other hosts
+// are kept for those.
+func (d *Document) Remove(host string, marker Marker) int {
+	_, n := d.remove(knownhosts.Normalize(host), marker)
+	return n
+}
+
+// RemoveKey removes the entries with the given marker for the given key,
+// regardless of their hosts, and returns the number of entries removed.
+func (d *Document) RemoveKey(key ssh.PublicKey, marker Marker) int {
+	n := 0
+	lines := d.lines[:0]
+	for _, l := range d.lines {
+		if l.entry != nil && l.entry.Marker == marker && keyEq(l.entry.Key, key) {
+			n++
+			continue
+		}
+		lines = append(lines, l)
+	}
+	d.lines = lines
+	return n
+}
+
+// Replace replaces the keys of the given host for the given marker with
+// the given keys. The new entries are inserted at the position of the first
+// entry which was removed for the host, or appended to the document if the
+// host had no entries. The host is normalized, and hashed if hash is true.
+func (d *Document) Replace(host string, keys []ssh.PublicKey, marker Marker, hash bool) {
+	pos, _ := d.remove(knownhosts.Normalize(host), marker)
+	if pos < 0 {
+		pos = len(d.lines)
+	}
+	newLines := make([]line, 0, len(keys))
+	for _, key := range keys {
+		e := newHostEntry(host, key, marker, hash)
+		newLines = append(newLines, line{entry: &e})
+	}
+	lines := make([]line, 0, len(d.lines)+len(newLines))
+	lines = append(lines, d.lines[:pos]...)
+	lines = append(lines, newLines...)
+	lines = append(lines, d.lines[pos:]...)
+	d.lines = lines
+}
+
+// Bytes returns the document in known_hosts format.
+func (d *Document) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range d.lines {
+		if l.raw != "" || l.entry == nil {
+			b.WriteString(l.raw)
+		} else {
+			b.WriteString(l.entry.String())
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// remove removes the normalized host from the entries with the given
+// marker. It returns the position of the first entry modified or -1, and
+// the number of entries modified.
+func (d *Document) remove(host string, marker Marker) (int, int) {
+	pos, n := -1, 0
+	lines := d.lines[:0]
+	for _, l := range d.lines {
+		if l.entry == nil || l.entry.Marker != marker {
+			lines = append(lines, l)
+			continue
+		}
+		i := l.entry.hostIndex(host)
+		if i < 0 {
+			lines = append(lines, l)
+			continue
+		}
+		if pos < 0 {
+			pos = len(lines)
+		}
+		n++
+		e := copyEntry(*l.entry)
+		for ; i >= 0; i = e.hostIndex(host) {
+			e.Hosts = append(e.Hosts[:i], e.Hosts[i+1:]...)
+		}
+		if len(e.Hosts) > 0 {
+			lines = append(lines, line{entry: &e})
+		}
+	}
+	d.lines = lines
+	return pos, n
+}
+
+func newHostEntry(host string, key ssh.PublicKey, marker Marker, hash bool) Entry {
+	h := knownhosts.Normalize(host)
+	if hash {
+		h = knownhosts.HashHostname(h)
+	}
+	return Entry{Marker: marker, Hosts: []string{h}, Key: key}
+}
+
+func copyEntry(e Entry) Entry {
+	e.Hosts = append([]string(nil), e.Hosts...)
+	return e
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package knownhosts
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	edKeyFixture    = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	ecdsaKeyFixture = "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBEmKSENjQEezOmxkZMy7opKgwFB9nkt5YRrYMjNuG5N87uRgg6CLrbo5wAdT/y6v0mKV0U2w0WZ2YB/++Tpockg="
+)
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseDocument(t *testing.T) {
+	g := NewWithT(t)
+
+	content := strings.Join([]string{
+		"# GitHub",
+		"github.com,192.30.255.112 " + edKeyFixture + " primary",
+		"",
+		"@cert-authority *.example.com " + ecdsaKeyFixture,
+		"@revoked * " + edKeyFixture,
+		"|1|vApZG0Ybr4rHfTb69+cjjFIGIv0=|M5sSXen14encOvQAy0gseRahnJw= " + ecdsaKeyFixture,
+	}, "\n") + "\n"
+
+	doc, err := ParseDocument([]byte(content))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// An unmodified document is written back as is.
+	g.Expect(string(doc.Bytes())).To(Equal(content))
+
+	entries := doc.Entries()
+	g.Expect(entries).To(HaveLen(4))
+	g.Expect(entries[0].Hosts).To(Equal([]string{"github.com", "192.30.255.112"}))
+	g.Expect(entries[0].Comment).To(Equal("primary"))
+	g.Expect(entries[1].Marker).To(Equal(MarkerCertAuthority))
+	g.Expect(entries[2].Marker).To(Equal(MarkerRevoked))
+
+	g.Expect(doc.Lookup("github.com:22", MarkerNone)).To(HaveLen(1))
+	g.Expect(doc.Lookup("[127.0.0.1]:44167", MarkerNone)).To(HaveLen(1))
+	g.Expect(doc.Lookup("127.0.0.1:44167", MarkerNone)).To(HaveLen(1))
+	g.Expect(doc.Lookup("gitlab.com", MarkerNone)).To(BeEmpty())
+
+	_, err = ParseDocument([]byte("github.com ssh-ed25519 invalid"))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("failed to parse known_hosts line 1"))
+}
+
+func TestDocument_Add(t *testing.T) {
+	g := NewWithT(t)
+
+	doc, err := ParseDocument([]byte("# comment\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	key := newTestKey(t)
+	g.Expect(doc.AddHost("example.com:2222", key, MarkerNone, false)).To(Succeed())
+	g.Expect(doc.AddHost("example.com", key, MarkerNone, true)).To(Succeed())
+	g.Expect(doc.Add(Entry{Marker: MarkerCertAuthority, Hosts: []string{"*.example.com"}, Key: key, Comment: "ca"})).To(Succeed())
+
+	g.Expect(doc.Add(Entry{Key: key})).ToNot(Succeed())
+	g.Expect(doc.Add(Entry{Hosts: []string{"example.com"}})).ToNot(Succeed())
+	g.Expect(doc.Add(Entry{Marker: "@invalid", Hosts: []string{"example.com"}, Key: key})).ToNot(Succeed())
+
+	lines := strings.Split(strings.TrimSuffix(string(doc.Bytes()), "\n"), "\n")
+	g.Expect(lines).To(HaveLen(4))
+	g.Expect(lines[0]).To(Equal("# comment"))
+	g.Expect(lines[1]).To(HavePrefix("[example.com]:2222 ssh-ed25519 "))
+	g.Expect(lines[2]).To(HavePrefix("|1|"))
+	g.Expect(lines[3]).To(HavePrefix("@cert-authority *.example.com ssh-ed25519 "))
+	g.Expect(lines[3]).To(HaveSuffix(" ca"))
+
+	// The serialized document can be parsed, and hashed hosts matched.
+	doc, err = ParseDocument(doc.Bytes())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(doc.Lookup("example.com", MarkerNone)).To(HaveLen(1))
+	g.Expect(doc.Lookup("[example.com]:2222", MarkerNone)).To(HaveLen(1))
+	g.Expect(doc.Lookup("*.example.com", MarkerCertAuthority)).To(HaveLen(1))
+}
+
+func TestDocument_Remove(t *testing.T) {
+	g := NewWithT(t)
+
+	content := strings.Join([]string{
+		"# GitHub",
+		"github.com,192.30.255.112 " + edKeyFixture,
+		"github.com " + ecdsaKeyFixture,
+		"|1|vApZG0Ybr4rHfTb69+cjjFIGIv0=|M5sSXen14encOvQAy0gseRahnJw= " + ecdsaKeyFixture,
+		"@revoked github.com " + edKeyFixture,
+	}, "\n")
+
+	doc, err := ParseDocument([]byte(content))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(doc.Remove("github.com", MarkerNone)).To(Equal(2))
+	g.Expect(doc.Remove("[127.0.0.1]:44167", MarkerNone)).To(Equal(1))
+	g.Expect(doc.Remove("gitlab.com", MarkerNone)).To(Equal(0))
+
+	g.Expect(string(doc.Bytes())).To(Equal(strings.Join([]string{
+		"# GitHub",
+		"192.30.255.112 " + edKeyFixture,
+		"@revoked github.com " + edKeyFixture,
+	}, "\n") + "\n"))
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(edKeyFixture))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(doc.RemoveKey(key, MarkerRevoked)).To(Equal(1))
+	g.Expect(doc.Entries()).To(HaveLen(1))
+}
+
+func TestDocument_Replace(t *testing.T) {
+	g := NewWithT(t)
+
+	content := strings.Join([]string{
+		"# first",
+		"gitlab.com " + edKeyFixture,
+		"github.com " + edKeyFixture,
+		"github.com " + ecdsaKeyFixture,
+		"# last",
+	}, "\n")
+
+	doc, err := ParseDocument([]byte(content))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	key1, key2 := newTestKey(t), newTestKey(t)
+	doc.Replace("github.com", []ssh.PublicKey{key1, key2}, MarkerNone, false)
+
+	lines := strings.Split(strings.TrimSuffix(string(doc.Bytes()), "\n"), "\n")
+	g.Expect(lines).To(HaveLen(5))
+	g.Expect(lines[0]).To(Equal("# first"))
+	g.Expect(lines[1]).To(Equal("gitlab.com " + edKeyFixture))
+	g.Expect(lines[2]).To(Equal("github.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key1)))))
+	g.Expect(lines[3]).To(Equal("github.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key2)))))
+	g.Expect(lines[4]).To(Equal("# last"))
+
+	// Replacing the keys of an unknown host appends them.
+	doc.Replace("bitbucket.org", []ssh.PublicKey{key1}, MarkerNone, true)
+	entries := doc.Entries()
+	g.Expect(entries).To(HaveLen(4))
+	g.Expect(entries[3].Hosts[0]).To(HavePrefix("|1|"))
+	g.Expect(entries[3].MatchesHost("bitbucket.org")).To(BeTrue())
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fluxcd/pkg/ssh/internal/bcrypt_pbkdf"
+)
+
+const (
+	opensshMagic         = "openssh-key-v1\x00"
+	opensshPEMType       = "OPENSSH PRIVATE KEY"
+	opensshCipherName    = "aes256-ctr"
+	opensshCipherKeySize = 32
+	opensshKdfName       = "bcrypt"
+	opensshKdfRounds     = 16
+	opensshKdfSaltSize   = 16
+	// opensshNoneBlockSize is the block size used to pad unencrypted keys.
+	opensshNoneBlockSize = 8
+)
+
+// encodePrivateKeyToOpenSSH encodes the given private key to a PEM block
+// in the OpenSSH private key format, as written by ssh-keygen. If the
+// passphrase is not empty, the key is encrypted with aes256-ctr using a
+// bcrypt derived key.
+//
+// Ref: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+func encodePrivateKeyToOpenSSH(pk crypto.PrivateKey, passphrase []byte, comment string) ([]byte, error) {
+	keyType, pubKey, keyFields, err := opensshKeyFields(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherName, kdfName, kdfOpts, blockSize := "none", "none", "", opensshNoneBlockSize
+	var salt []byte
+	if len(passphrase) > 0 {
+		salt = make([]byte, opensshKdfSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		cipherName, kdfName, blockSize = opensshCipherName, opensshKdfName, aes.BlockSize
+		kdfOpts = string(ssh.Marshal(struct {
+			Salt   []byte
+			Rounds uint32
+		}{salt, opensshKdfRounds}))
+	}
+
+	// The check integers are used to verify the decryption succeeded.
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	checkInt := binary.BigEndian.Uint32(check)
+
+	privBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+	}{checkInt, checkInt, keyType})
+	privBlock = append(privBlock, keyFields...)
+	privBlock = append(privBlock, ssh.Marshal(struct{ Comment string }{comment})...)
+	for i := 1; len(privBlock)%blockSize != 0; i++ {
+		privBlock = append(privBlock, byte(i))
+	}
+
+	if len(passphrase) > 0 {
+		k, err := bcrypt_pbkdf.Key(passphrase, salt, opensshKdfRounds, opensshCipherKeySize+aes.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(k[:opensshCipherKeySize])
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCTR(block, k[opensshCipherKeySize:]).XORKeyStream(privBlock, privBlock)
+	}
+
+	w := struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{cipherName, kdfName, kdfOpts, 1, pubKey.Marshal(), privBlock}
+
+	block := pem.Block{
+		Type:  opensshPEMType,
+		Bytes: append([]byte(opensshMagic), ssh.Marshal(w)...),
+	}
+	return pem.EncodeToMemory(&block), nil
+}
+
+// opensshKeyFields returns the key type, the public key and the
+// marshalled type specific private key fields of the given private key.
+func opensshKeyFields(pk crypto.PrivateKey) (string, ssh.PublicKey, []byte, error) {
+	switch k := pk.(type) {
+	case *rsa.PrivateKey:
+		pub, err := ssh.NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if len(k.Primes) != 2 {
+			return "", nil, nil, fmt.Errorf("unsupported RSA key with %d primes", len(k.Primes))
+		}
+		k.Precompute()
+		return ssh.KeyAlgoRSA, pub, ssh.Marshal(struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			Iqmp *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]}), nil
+	case *ecdsa.PrivateKey:
+		pub, err := ssh.NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		// The curve name and the encoded point of the private key fields
+		// are the ones of the SSH wire format of the public key.
+		var wire struct {
+			Type  string
+			Curve string
+			Pub   []byte
+		}
+		if err := ssh.Unmarshal(pub.Marshal(), &wire); err != nil {
+			return "", nil, nil, err
+		}
+		return pub.Type(), pub, ssh.Marshal(struct {
+			Curve string
+			Pub   []byte
+			D     *big.Int
+		}{wire.Curve, wire.Pub, k.D}), nil
+	case ed25519.PrivateKey:
+		pub, err := ssh.NewPublicKey(k.Public())
+		if err != nil {
+			return "", nil, nil, err
+		}
+		return ssh.KeyAlgoED25519, pub, ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{[]byte(k.Public().(ed25519.PublicKey)), []byte(k)}), nil
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported private key type: %T", pk)
+	}
+}
@@ -20,10 +20,42 @@ import (
 	"time"
 )
 
+// Option configures the extraction of a tarball.
+type Option func(*options)
+
+type options struct {
+	maxUntarSize int64
+	skip         func(name string, isDir bool) bool
+}
+
+// WithMaxUntarSize limits the total size of the files extracted from the
+// tarball to the given number of bytes. There is no limit if the size is
+// zero or negative.
+func WithMaxUntarSize(size int64) Option {
+	return func(o *options) {
+		o.maxUntarSize = size
+	}
+}
+
+// WithSkipFunc skips the entries of the tarball for which the given function
+// returns true. The function is called with the slash-separated path of the
+// entries relative to the root of the tarball.
+func WithSkipFunc(skip func(name string, isDir bool) bool) Option {
+	return func(o *options) {
+		o.skip = skip
+	}
+}
+
 // Untar reads the gzip-compressed tar file from r and writes it into dir.
-func Untar(r io.Reader, dir string) (summary string, err error) {
+func Untar(r io.Reader, dir string, opts ...Option) (summary string, err error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	t0 := time.Now()
 	nFiles := 0
+	var totalSize int64
 	madeDir := map[string]bool{}
 	defer func() {
 		td := time.Since(t0)
@@ -56,8 +88,15 @@ func Untar(r io.Reader, dir string) (summary string, err error) {
 
 		fi := f.FileInfo()
 		mode := fi.Mode()
+		if o.skip != nil && o.skip(strings.TrimSuffix(f.Name, "/"), mode.IsDir()) {
+			continue
+		}
 		switch {
 		case mode.IsRegular():
+			totalSize += f.Size
+			if o.maxUntarSize > 0 && totalSize > o.maxUntarSize {
+				return summary, fmt.Errorf("tarball size exceeds the max untar size of %d bytes", o.maxUntarSize)
+			}
 			// Make the directory. This is redundant because it should
 			// already be made by a directory entry in the tar
 			// beforehand. Thus, don't check for errors; the next
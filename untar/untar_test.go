@@ -0,0 +1,160 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package untar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name    string
+	content string
+}
+
+// testTarball returns a gzip-compressed tarball holding the given entries,
+// entries whose name ends with a slash are directories.
+func testTarball(t *testing.T, entries []testEntry) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(e.name, "/") {
+			hdr = &tar.Header{Name: e.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestUntar_WithMaxUntarSize(t *testing.T) {
+	// The directories do not count towards the size.
+	entries := []testEntry{
+		{name: "dir/"},
+		{name: "dir/a.txt", content: "12345"},
+		{name: "b.txt", content: "67890"},
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		wantErr string
+	}{
+		{name: "no limit"},
+		{name: "size equal to the limit", maxSize: 10},
+		{name: "size above the limit", maxSize: 9, wantErr: "tarball size exceeds the max untar size of 9 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := Untar(testTarball(t, entries), dir, WithMaxUntarSize(tt.maxSize))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, f := range []string{"dir/a.txt", "b.txt"} {
+				if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+					t.Errorf("expected file %s to be extracted: %v", f, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUntar_WithSkipFunc(t *testing.T) {
+	entries := []testEntry{
+		{name: "keep/"},
+		{name: "keep/a.yaml", content: "a"},
+		{name: "keep/b.txt", content: "b"},
+		{name: "skip/"},
+		{name: "skip/c.yaml", content: "c"},
+		{name: "skip/nested/"},
+		{name: "skip/nested/d.yaml", content: "d"},
+		{name: "e.yaml", content: "e"},
+	}
+
+	type call struct {
+		name  string
+		isDir bool
+	}
+	var calls []call
+	skip := func(name string, isDir bool) bool {
+		calls = append(calls, call{name, isDir})
+		return name == "skip" || strings.HasPrefix(name, "skip/") || (!isDir && strings.HasSuffix(name, ".txt"))
+	}
+
+	dir := t.TempDir()
+	// The skipped files do not count towards the size.
+	if _, err := Untar(testTarball(t, entries), dir, WithSkipFunc(skip), WithMaxUntarSize(2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, f := range []string{"keep/a.yaml", "e.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", f, err)
+		}
+	}
+	for _, f := range []string{"keep/b.txt", "skip"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be skipped, got %v", f, err)
+		}
+	}
+
+	// The directory names are passed without their trailing slash.
+	wantCalls := []call{
+		{"keep", true},
+		{"keep/a.yaml", false},
+		{"keep/b.txt", false},
+		{"skip", true},
+		{"skip/c.yaml", false},
+		{"skip/nested", true},
+		{"skip/nested/d.yaml", false},
+		{"e.yaml", false},
+	}
+	if len(calls) != len(wantCalls) {
+		t.Fatalf("expected %d calls to the skip function, got %d: %v", len(wantCalls), len(calls), calls)
+	}
+	for i := range wantCalls {
+		if calls[i] != wantCalls[i] {
+			t.Errorf("expected call %d to be %v, got %v", i, wantCalls[i], calls[i])
+		}
+	}
+}